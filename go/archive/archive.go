@@ -0,0 +1,189 @@
+// Package archive moves the files of a volume in and out of a tar stream.
+//
+// every file is an entry in the tar, followed by a manifest entry which
+// carries the size, mtime and the crc32 of every file.
+package archive
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/chzyer/logex"
+)
+
+const (
+	ManifestName    = ".madq-manifest.json"
+	ManifestVersion = 1
+
+	tarBlockSize = 512
+)
+
+var (
+	ErrNoManifest       = logex.Define("archive is incomplete: manifest is not found")
+	ErrChecksumMismatch = logex.Define("checksum mismatch")
+	ErrResumeMismatch   = logex.Define("the partial file in volume differs from the archive")
+)
+
+type FileEntry struct {
+	Name  string
+	Size  int64
+	Mtime time.Time
+	CRC32 uint32
+}
+
+type Manifest struct {
+	Version int
+	Files   []FileEntry
+}
+
+func (m *Manifest) Get(name string) *FileEntry {
+	for idx := range m.Files {
+		if m.Files[idx].Name == name {
+			return &m.Files[idx]
+		}
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// Filter is a list of patterns in the syntax of path.Match,
+// empty Filter matches everything.
+type Filter []string
+
+// s: patterns separated by ','
+func NewFilter(s string) (Filter, error) {
+	var f Filter
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid filter %q: %v", p, err)
+		}
+		f = append(f, p)
+	}
+	return f, nil
+}
+
+func (f Filter) Match(name string) bool {
+	if len(f) == 0 {
+		return true
+	}
+	for _, p := range f {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// -----------------------------------------------------------------------------
+
+// the size of an entry in tar, the names are short enough to fit in one
+// header block.
+func entrySize(size int64) int64 {
+	return tarBlockSize + (size+tarBlockSize-1)/tarBlockSize*tarBlockSize
+}
+
+func writeEntry(tw *tar.Writer, e *FileEntry, r io.Reader) error {
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     e.Name,
+		Mode:     0644,
+		Size:     e.Size,
+		ModTime:  e.Mtime,
+		Typeflag: tar.TypeReg,
+		Format:   tar.FormatUSTAR,
+	}); err != nil {
+		return logex.Trace(err)
+	}
+	h := crc32.NewIEEE()
+	n, err := io.Copy(io.MultiWriter(tw, h), r)
+	if err != nil {
+		return logex.Trace(err)
+	}
+	if n != e.Size {
+		return fmt.Errorf("%v: short read: %v, want: %v", e.Name, n, e.Size)
+	}
+	e.CRC32 = h.Sum32()
+	return nil
+}
+
+func writeManifest(tw *tar.Writer, m *Manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return logex.Trace(err)
+	}
+	if err := tw.WriteHeader(&tar.Header{
+		Name:     ManifestName,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  time.Now(),
+		Typeflag: tar.TypeReg,
+		Format:   tar.FormatUSTAR,
+	}); err != nil {
+		return logex.Trace(err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return logex.Trace(err)
+	}
+	return nil
+}
+
+func readManifest(r io.Reader) (*Manifest, error) {
+	var m Manifest
+	if err := json.NewDecoder(r).Decode(&m); err != nil {
+		return nil, logex.Trace(err)
+	}
+	if m.Version != ManifestVersion {
+		return nil, fmt.Errorf("manifest version %v is not supported", m.Version)
+	}
+	return &m, nil
+}
+
+// scanArchive reads the complete entries in the archive, a truncated
+// archive is not an error.
+// return the end offset of the last complete entry, and the manifest if
+// the archive is complete.
+func scanArchive(r io.Reader) (entries []FileEntry, end int64, m *Manifest, err error) {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return entries, end, m, nil
+		}
+		if err != nil {
+			return nil, 0, nil, logex.Trace(err)
+		}
+		if hdr.Name == ManifestName {
+			m, err = readManifest(tr)
+			if err != nil {
+				return entries, end, nil, nil
+			}
+			return entries, end, m, nil
+		}
+
+		h := crc32.NewIEEE()
+		n, err := io.Copy(h, tr)
+		if err == io.ErrUnexpectedEOF || n != hdr.Size {
+			return entries, end, m, nil
+		}
+		if err != nil {
+			return nil, 0, nil, logex.Trace(err)
+		}
+		entries = append(entries, FileEntry{
+			Name:  hdr.Name,
+			Size:  hdr.Size,
+			Mtime: hdr.ModTime,
+			CRC32: h.Sum32(),
+		})
+		end += entrySize(hdr.Size)
+	}
+}
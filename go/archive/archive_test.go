@@ -0,0 +1,214 @@
+package archive
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/allmad/madq/go/fs"
+	"github.com/allmad/madq/go/fs/fstest"
+	"github.com/chzyer/logex"
+	"github.com/chzyer/test"
+)
+
+func testCheckFile(vol *fs.Volume, name string, size int) {
+	if size == 0 {
+		// nothing is flushed for an empty file
+		_, err := vol.Ino(name)
+		test.Nil(err)
+		return
+	}
+	fd, err := vol.Open(name, 0)
+	test.Nil(err)
+	defer fd.Close()
+	test.Equal(fd.Size(), int64(size))
+	buf := make([]byte, size)
+	test.ReadAt(fd, buf, 0)
+	test.EqualBytes(buf, test.SeqBytes(size))
+}
+
+var testFiles = map[string]int{
+	"a":     100,
+	"b":     70 << 10,
+	"log.1": 3000,
+	"log.2": 0,
+}
+
+func testExport(root string, filter Filter) (*Manifest, []byte) {
+	vol, closer := fstest.OpenVolume(root + "/src")
+	defer closer()
+	fstest.WriteFiles(vol, testFiles)
+
+	buf := bytes.NewBuffer(nil)
+	m, err := Export(vol, buf, filter, nil)
+	test.Nil(err)
+	return m, buf.Bytes()
+}
+
+func TestRoundTrip(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+	defer os.RemoveAll(root)
+
+	m, data := testExport(root, nil)
+	test.Equal(len(m.Files), len(testFiles))
+	for _, e := range m.Files {
+		test.Equal(e.Size, int64(testFiles[e.Name]))
+		test.Equal(e.Mtime.IsZero(), e.Size == 0)
+	}
+
+	vol, closer := fstest.OpenVolume(root + "/dst")
+	defer closer()
+	m2, names, err := Import(vol, bytes.NewReader(data), nil, false)
+	test.Nil(err)
+	test.Equal(len(names), len(testFiles))
+	test.Equal(len(m2.Files), len(testFiles))
+	test.Nil(Verify(vol, m2, names))
+	for name, size := range testFiles {
+		testCheckFile(vol, name, size)
+	}
+
+	// files exist
+	_, _, err = Import(vol, bytes.NewReader(data), nil, false)
+	test.NotNil(err)
+	// all skipped
+	_, names, err = Import(vol, bytes.NewReader(data), nil, true)
+	test.Nil(err)
+	test.Nil(Verify(vol, m2, names))
+}
+
+func TestFilter(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+	defer os.RemoveAll(root)
+
+	_, err := NewFilter("[")
+	test.NotNil(err)
+
+	filter, err := NewFilter("log.*, a")
+	test.Nil(err)
+	test.True(filter.Match("a"))
+	test.True(filter.Match("log.1"))
+	test.False(filter.Match("b"))
+
+	m, data := testExport(root, filter)
+	test.Equal(len(m.Files), 3)
+	test.Nil(m.Get("b"))
+
+	vol, closer := fstest.OpenVolume(root + "/dst")
+	defer closer()
+	only, err := NewFilter("log.1")
+	test.Nil(err)
+	_, names, err := Import(vol, bytes.NewReader(data), only, false)
+	test.Nil(err)
+	test.Equal(names, []string{"log.1"})
+	test.Equal(vol.Names(), []string{"log.1"})
+}
+
+func TestExportResume(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+	defer os.RemoveAll(root)
+
+	m, data := testExport(root, nil)
+	fpath := root + "/archive"
+
+	// cut in the middle of the second entry
+	cut := entrySize(m.Files[0].Size) + 700
+	test.Nil(ioutil.WriteFile(fpath, data[:cut], 0644))
+
+	vol, closer := fstest.OpenVolume(root + "/src")
+	defer closer()
+
+	_, _, err := openExportArchive(fpath, false)
+	test.NotNil(err)
+
+	f, done, err := openExportArchive(fpath, true)
+	test.Nil(err)
+	test.Equal(len(done), 1)
+	test.Equal(done[0], m.Files[0])
+	m2, err := Export(vol, f, nil, done)
+	test.Nil(err)
+	test.Nil(f.Close())
+	test.Equal(len(m2.Files), len(m.Files))
+
+	// the archive is complete
+	f, done, err = openExportArchive(fpath, true)
+	test.Nil(err)
+	test.True(f == nil)
+	test.Equal(len(done), len(m.Files))
+
+	resumed, err := ioutil.ReadFile(fpath)
+	test.Nil(err)
+	dst, closer2 := fstest.OpenVolume(root + "/dst")
+	defer closer2()
+	m3, names, err := Import(dst, bytes.NewReader(resumed), nil, false)
+	test.Nil(err)
+	test.Nil(Verify(dst, m3, names))
+	for name, size := range testFiles {
+		testCheckFile(dst, name, size)
+	}
+}
+
+func TestImportResume(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+	defer os.RemoveAll(root)
+
+	_, data := testExport(root, nil)
+	vol, closer := fstest.OpenVolume(root + "/dst")
+	defer closer()
+
+	// partial imported
+	fstest.WriteFiles(vol, map[string]int{"b": 4096})
+	_, _, err := Import(vol, bytes.NewReader(data), nil, false)
+	test.NotNil(err)
+
+	m, names, err := Import(vol, bytes.NewReader(data), nil, true)
+	test.Nil(err)
+	test.Nil(Verify(vol, m, names))
+	testCheckFile(vol, "b", testFiles["b"])
+
+	// larger than the one in archive
+	fstest.WriteFiles(vol, map[string]int{"a": 10})
+	_, _, err = Import(vol, bytes.NewReader(data), nil, true)
+	test.NotNil(err)
+
+	// the partial one isn't the prefix of the one in archive
+	vol2, closer2 := fstest.OpenVolume(root + "/dst2")
+	defer closer2()
+	fstest.WriteFile(vol2, "log.1", bytes.Repeat([]byte{0xff}, 100))
+	_, _, err = Import(vol2, bytes.NewReader(data), nil, true)
+	test.True(logex.Equal(err, ErrResumeMismatch))
+}
+
+func TestChecksum(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+	defer os.RemoveAll(root)
+
+	m, data := testExport(root, nil)
+
+	// no manifest
+	end := int64(0)
+	for _, e := range m.Files {
+		end += entrySize(e.Size)
+	}
+	vol, closer := fstest.OpenVolume(root + "/dst")
+	defer closer()
+	_, _, err := Import(vol, bytes.NewReader(data[:end]), nil, false)
+	test.True(logex.Equal(err, ErrNoManifest))
+
+	// corrupt the data of the first entry
+	corrupted := append([]byte(nil), data...)
+	corrupted[tarBlockSize+1] ^= 0xff
+	vol2, closer2 := fstest.OpenVolume(root + "/dst2")
+	defer closer2()
+	_, _, err = Import(vol2, bytes.NewReader(corrupted), nil, false)
+	test.True(logex.Equal(err, ErrChecksumMismatch))
+
+	// the manifest doesn't match the volume
+	m.Files[0].CRC32++
+	test.True(logex.Equal(Verify(vol2, m, []string{m.Files[0].Name}), ErrChecksumMismatch))
+}
@@ -0,0 +1,169 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/allmad/madq/go/fs"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+)
+
+type ExportConfig struct {
+	Dir    string `type:"[0]" desc:"volume directory, or the directories separated by comma"`
+	Out    string `desc:"the archive file"`
+	Filter string `desc:"only the files match the patterns, separated by ','"`
+	Resume bool   `desc:"continue an interrupted export into the same archive"`
+}
+
+func (c *ExportConfig) FlaglyDesc() string {
+	return "export the files of a volume into a tar archive"
+}
+
+func (c *ExportConfig) FlaglyHandle(f *flow.Flow) error {
+	defer f.Close()
+
+	if c.Dir == "" || c.Out == "" {
+		return fmt.Errorf("error: directory and out are required")
+	}
+	filter, err := NewFilter(c.Filter)
+	if err != nil {
+		return err
+	}
+
+	vs, err := fs.OpenVolumeSourceReadOnly(fs.SplitDirs(c.Dir))
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	vol, err := fs.NewVolume(f, &fs.VolumeConfig{
+		Delegate: vs,
+		ReadOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer vol.Close()
+
+	out, done, err := openExportArchive(c.Out, c.Resume)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		println("archive is complete:", c.Out)
+		return nil
+	}
+	defer out.Close()
+
+	m, err := Export(vol, out, filter, done)
+	if err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return logex.Trace(err)
+	}
+	println(fmt.Sprintf("exported %v files (%v resumed) to %v",
+		len(m.Files), len(done), c.Out))
+	return nil
+}
+
+// without resume, the archive must not exist.
+// return nil file if the archive is complete already.
+func openExportArchive(name string, resume bool) (*os.File, []FileEntry, error) {
+	if !resume {
+		f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return nil, nil, logex.Trace(err)
+		}
+		return f, nil, nil
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, nil, logex.Trace(err)
+	}
+	done, end, m, err := scanArchive(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if m != nil {
+		f.Close()
+		return nil, done, nil
+	}
+	// drop the partial entry
+	if err := f.Truncate(end); err != nil {
+		f.Close()
+		return nil, nil, logex.Trace(err)
+	}
+	if _, err := f.Seek(end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, nil, logex.Trace(err)
+	}
+	return f, done, nil
+}
+
+// Export writes the files in vol which match filter into w, and the
+// manifest at the end. files in done are in the archive already.
+func Export(vol *fs.Volume, w io.Writer, filter Filter, done []FileEntry) (*Manifest, error) {
+	m := &Manifest{
+		Version: ManifestVersion,
+		Files:   append([]FileEntry(nil), done...),
+	}
+	exported := make(map[string]bool, len(done))
+	for _, e := range done {
+		exported[e.Name] = true
+	}
+
+	tw := tar.NewWriter(w)
+	for _, name := range vol.Names() {
+		if exported[name] || !filter.Match(name) {
+			continue
+		}
+		e, err := exportFile(vol, tw, name)
+		if err != nil {
+			return nil, err
+		}
+		m.Files = append(m.Files, *e)
+	}
+
+	if err := writeManifest(tw, m); err != nil {
+		return nil, err
+	}
+	if err := tw.Close(); err != nil {
+		return nil, logex.Trace(err)
+	}
+	return m, nil
+}
+
+func exportFile(vol *fs.Volume, tw *tar.Writer, name string) (*FileEntry, error) {
+	fd, err := vol.Open(name, 0)
+	if logex.Equal(err, fs.ErrInodeNotFound) {
+		// created but nothing is written
+		e := &FileEntry{Name: name}
+		return e, writeEntry(tw, e, bytes.NewReader(nil))
+	}
+	if err != nil {
+		return nil, logex.Trace(err, name)
+	}
+	defer fd.Close()
+
+	inode, err := fd.Stat()
+	if err != nil {
+		return nil, logex.Trace(err, name)
+	}
+	// the precision of mtime in ustar is second
+	e := &FileEntry{
+		Name:  name,
+		Size:  fd.Size(),
+		Mtime: inode.Mtime.Get().Truncate(time.Second),
+	}
+	if err := writeEntry(tw, e, io.NewSectionReader(fd, 0, e.Size)); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
@@ -0,0 +1,215 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/allmad/madq/go/fs"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+)
+
+type ImportConfig struct {
+	Dir      string `type:"[0]" desc:"volume directory, or the directories separated by comma, it's created if not exists"`
+	In       string `desc:"the archive file"`
+	Filter   string `desc:"only the files match the patterns, separated by ','"`
+	Resume   bool   `desc:"skip the files imported, and continue the partial ones"`
+	NoVerify bool   `name:"noverify" desc:"don't read back the files to verify"`
+}
+
+func (c *ImportConfig) FlaglyDesc() string {
+	return "import the files in a tar archive into a volume"
+}
+
+func (c *ImportConfig) FlaglyHandle(f *flow.Flow) error {
+	defer f.Close()
+
+	if c.Dir == "" || c.In == "" {
+		return fmt.Errorf("error: directory and in are required")
+	}
+	filter, err := NewFilter(c.Filter)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(c.In)
+	if err != nil {
+		return logex.Trace(err)
+	}
+	defer in.Close()
+
+	vs, err := fs.OpenVolumeSource(fs.SplitDirs(c.Dir), nil)
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	vol, err := fs.NewVolume(f, &fs.VolumeConfig{
+		Delegate: vs,
+	})
+	if err != nil {
+		return err
+	}
+	defer vol.Close()
+
+	m, names, err := Import(vol, in, filter, c.Resume)
+	if err != nil {
+		return err
+	}
+	if !c.NoVerify {
+		if err := Verify(vol, m, names); err != nil {
+			return err
+		}
+	}
+	println(fmt.Sprintf("imported %v files from %v", len(names), c.In))
+	return nil
+}
+
+// Import recreates the files in the archive which match filter, and
+// checks them against the manifest.
+// with resume, files in vol are treated as imported partially, their
+// data must be the prefix of the ones in archive.
+// the manifest is at the end of the archive, so a checksum mismatch is
+// only found after the data is written into vol, the volume should be
+// dropped in that case.
+// return the manifest and the names of the files imported.
+func Import(vol *fs.Volume, r io.Reader, filter Filter, resume bool) (*Manifest, []string, error) {
+	var m *Manifest
+	var names []string
+	sums := make(map[string]uint32)
+
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, logex.Trace(err)
+		}
+		if hdr.Name == ManifestName {
+			if m, err = readManifest(tr); err != nil {
+				return nil, nil, err
+			}
+			break
+		}
+		if !filter.Match(hdr.Name) {
+			continue
+		}
+
+		sum, err := importFile(vol, hdr, tr, resume)
+		if err != nil {
+			return nil, nil, err
+		}
+		sums[hdr.Name] = sum
+		names = append(names, hdr.Name)
+	}
+
+	if m == nil {
+		return nil, nil, ErrNoManifest.Trace()
+	}
+	for _, name := range names {
+		e := m.Get(name)
+		if e == nil || e.CRC32 != sums[name] {
+			return nil, nil, ErrChecksumMismatch.Trace("in archive:", name)
+		}
+	}
+	return m, names, nil
+}
+
+func importFile(vol *fs.Volume, hdr *tar.Header, r io.Reader, resume bool) (uint32, error) {
+	existing := int64(0)
+	fd, err := vol.Open(hdr.Name, 0)
+	if err == nil {
+		existing = fd.Size()
+		if !resume {
+			fd.Close()
+			return 0, fmt.Errorf("%v is exists", hdr.Name)
+		}
+		if existing > hdr.Size {
+			fd.Close()
+			return 0, fmt.Errorf("%v is larger than the one in archive: %v > %v",
+				hdr.Name, existing, hdr.Size)
+		}
+	} else if logex.Equal(err, fs.ErrFileNotExist) || logex.Equal(err, fs.ErrInodeNotFound) {
+		fd, err = vol.Open(hdr.Name, os.O_CREATE)
+	}
+	if err != nil {
+		return 0, logex.Trace(err, hdr.Name)
+	}
+	defer fd.Close()
+
+	// files are append-only, the first existing bytes are imported
+	h := crc32.NewIEEE()
+	r = io.TeeReader(r, h)
+	if err := comparePrefix(io.NewSectionReader(fd, 0, existing), r, existing); err != nil {
+		return 0, logex.Trace(err, hdr.Name)
+	}
+	if _, err := io.Copy(fd, r); err != nil {
+		return 0, logex.Trace(err, hdr.Name)
+	}
+	fd.Sync()
+	return h.Sum32(), nil
+}
+
+// compare the first n bytes of a and b
+func comparePrefix(a, b io.Reader, n int64) error {
+	bufA := make([]byte, 32<<10)
+	bufB := make([]byte, len(bufA))
+	for n > 0 {
+		size := len(bufA)
+		if int64(size) > n {
+			size = int(n)
+		}
+		if _, err := io.ReadFull(a, bufA[:size]); err != nil {
+			return logex.Trace(err)
+		}
+		if _, err := io.ReadFull(b, bufB[:size]); err != nil {
+			return logex.Trace(err)
+		}
+		if !bytes.Equal(bufA[:size], bufB[:size]) {
+			return ErrResumeMismatch.Trace()
+		}
+		n -= int64(size)
+	}
+	return nil
+}
+
+// Verify reads back the files in vol and compares them with the manifest.
+func Verify(vol *fs.Volume, m *Manifest, names []string) error {
+	for _, name := range names {
+		e := m.Get(name)
+		if e == nil {
+			return fmt.Errorf("%v is not in manifest", name)
+		}
+		size, sum, err := checksum(vol, name)
+		if err != nil {
+			return err
+		}
+		if size != e.Size || sum != e.CRC32 {
+			return ErrChecksumMismatch.Trace("in volume:", name)
+		}
+	}
+	return nil
+}
+
+func checksum(vol *fs.Volume, name string) (int64, uint32, error) {
+	fd, err := vol.Open(name, 0)
+	if logex.Equal(err, fs.ErrInodeNotFound) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, logex.Trace(err, name)
+	}
+	defer fd.Close()
+
+	size := fd.Size()
+	h := crc32.NewIEEE()
+	if _, err := io.Copy(h, io.NewSectionReader(fd, 0, size)); err != nil {
+		return 0, 0, logex.Trace(err, name)
+	}
+	return size, h.Sum32(), nil
+}
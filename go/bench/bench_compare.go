@@ -0,0 +1,372 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/allmad/madq/go/ptrace"
+	"github.com/chzyer/flow"
+)
+
+type Compare struct {
+	Engines   string `desc:"engines to compare, the first one is the baseline" default:"fs,lfs"`
+	Count     int    `desc:"messages in every workload" default:"10000"`
+	BlockSize int    `name:"bs" desc:"message size" default:"200"`
+	Writers   int    `desc:"writers in fanin-append" default:"8"`
+	Timeout   int    `desc:"seconds for one workload, the engine is abandoned after timeout" default:"60"`
+	Mem       bool
+	Dir       string `desc:"test directory path" default:"/tmp/madq/bench/compare"`
+	JSON      bool   `name:"json" desc:"print the report in json"`
+}
+
+func (c *Compare) FlaglyDesc() string {
+	return "run the same workloads on every engine side by side"
+}
+
+// the workloads, in the order they run. the reads are on the file
+// written by seq-append.
+var CompareWorkloads = []string{"seq-append", "fanin-append", "seq-read", "rand-read"}
+
+var compareWorkloadFuncs = map[string]func(*compareRun) (*PhaseReport, error){
+	"seq-append":   (*compareRun).seqAppend,
+	"fanin-append": (*compareRun).faninAppend,
+	"seq-read":     (*compareRun).seqRead,
+	"rand-read":    (*compareRun).randRead,
+}
+
+type CompareResult struct {
+	Workload string
+	Engine   string
+	Error    string       `json:",omitempty"`
+	Phase    *PhaseReport `json:",omitempty"`
+}
+
+type CompareReport struct {
+	Count     int
+	BlockSize int
+	Writers   int
+	Engines   []string
+	Results   []*CompareResult
+}
+
+func (r *CompareReport) Get(workload, engine string) *CompareResult {
+	for _, ret := range r.Results {
+		if ret.Workload == workload && ret.Engine == engine {
+			return ret
+		}
+	}
+	return nil
+}
+
+// the throughput relative to the first engine
+func (r *CompareReport) relative(ret *CompareResult) string {
+	base := r.Get(ret.Workload, r.Engines[0])
+	if ret.Phase == nil || base == nil || base.Phase == nil || base.Phase.BytesPerSec == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.2fx", ret.Phase.BytesPerSec/base.Phase.BytesPerSec)
+}
+
+func (r *CompareReport) WriteTable(w io.Writer) {
+	fmt.Fprintf(w, "count: %v, bs: %v, writers: %v, baseline: %v\n",
+		r.Count, r.BlockSize, r.Writers, r.Engines[0])
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "WORKLOAD\tENGINE\tMSG/S\tBYTES/S\tRELATIVE\tP50\tP99\tMAX\tERROR")
+	for _, name := range CompareWorkloads {
+		for _, engine := range r.Engines {
+			ret := r.Get(name, engine)
+			if ret == nil {
+				continue
+			}
+			if ret.Phase == nil {
+				fmt.Fprintf(tw, "%v\t%v\t-\t-\t-\t-\t-\t-\t%v\n", name, engine, ret.Error)
+				continue
+			}
+			ph := ret.Phase
+			fmt.Fprintf(tw, "%v\t%v\t%.0f\t%v\t%v\t%v\t%v\t%v\t\n",
+				name, engine, ph.MsgPerSec, ptrace.Unit(int64(ph.BytesPerSec)),
+				r.relative(ret), ph.Latency.P50, ph.Latency.P99, ph.Latency.Max)
+		}
+	}
+	tw.Flush()
+}
+
+func (c *Compare) Validate() error {
+	switch {
+	case c.Count <= 0:
+		return fmt.Errorf("count must be positive")
+	case c.BlockSize <= 0:
+		return fmt.Errorf("bs must be positive")
+	case c.Writers <= 0:
+		return fmt.Errorf("writers must be positive")
+	case c.Timeout <= 0:
+		return fmt.Errorf("timeout must be positive")
+	}
+	for _, name := range c.EngineList() {
+		if _, ok := Engines[name]; !ok {
+			return fmt.Errorf("unknown engine %q, available: %v",
+				name, strings.Join(EngineNames(), ", "))
+		}
+	}
+	if len(c.EngineList()) == 0 {
+		return fmt.Errorf("engines is required")
+	}
+	return nil
+}
+
+func (c *Compare) EngineList() []string {
+	var ret []string
+	for _, name := range strings.Split(c.Engines, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ret = append(ret, name)
+		}
+	}
+	return ret
+}
+
+func (c *Compare) FlaglyHandle(f *flow.Flow) error {
+	defer f.Close()
+
+	report, err := c.Run(f)
+	if err != nil {
+		return err
+	}
+	if c.JSON {
+		ret, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(ret))
+		return nil
+	}
+	report.WriteTable(os.Stdout)
+	return nil
+}
+
+// Run runs every workload on every engine, the failures are in the report.
+func (c *Compare) Run(f *flow.Flow) (*CompareReport, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	report := &CompareReport{
+		Count:     c.Count,
+		BlockSize: c.BlockSize,
+		Writers:   c.Writers,
+		Engines:   c.EngineList(),
+	}
+	for _, name := range report.Engines {
+		report.Results = append(report.Results, c.runEngine(f, name)...)
+	}
+	return report, nil
+}
+
+func (c *Compare) runEngine(f *flow.Flow, name string) []*CompareResult {
+	results := make([]*CompareResult, len(CompareWorkloads))
+	for idx, workload := range CompareWorkloads {
+		results[idx] = &CompareResult{Workload: workload, Engine: name}
+	}
+	fail := func(from int, err error) []*CompareResult {
+		for _, ret := range results[from:] {
+			ret.Error = err.Error()
+		}
+		return results
+	}
+
+	engine, err := Engines[name](f, filepath.Join(c.Dir, name), c.Mem)
+	if err != nil {
+		return fail(0, err)
+	}
+
+	r := newCompareRun(c, engine)
+	for idx, workload := range CompareWorkloads {
+		type resp struct {
+			phase *PhaseReport
+			err   error
+		}
+		done := make(chan resp, 1)
+		go func() {
+			phase, err := compareWorkloadFuncs[workload](r)
+			done <- resp{phase, err}
+		}()
+
+		select {
+		case ret := <-done:
+			if ret.err != nil {
+				results[idx].Error = ret.err.Error()
+			} else {
+				ret.phase.Name = workload
+				results[idx].Phase = ret.phase
+			}
+		case <-time.After(time.Duration(c.Timeout) * time.Second):
+			// it may be stuck, don't touch it anymore
+			return fail(idx, fmt.Errorf("timeout after %vs", c.Timeout))
+		}
+	}
+	engine.Close()
+	return results
+}
+
+// -----------------------------------------------------------------------------
+
+type compareRun struct {
+	cfg    *Compare
+	engine Engine
+	msg    []byte
+
+	// written by seq-append
+	seqSize int64
+}
+
+const compareSeqFile = "/compare-seq"
+
+func newCompareRun(cfg *Compare, engine Engine) *compareRun {
+	msg := make([]byte, cfg.BlockSize)
+	rand.New(rand.NewSource(1)).Read(msg)
+	return &compareRun{cfg: cfg, engine: engine, msg: msg}
+}
+
+func (r *compareRun) write(fd EngineFile, stat *phaseStat) error {
+	now := time.Now()
+	n, err := fd.Write(r.msg)
+	if err != nil {
+		return err
+	}
+	stat.latency.AddNow(now)
+	stat.msgs.Add(1)
+	stat.bytes.AddInt(n)
+	return nil
+}
+
+// one writer appends Count messages
+func (r *compareRun) seqAppend() (*PhaseReport, error) {
+	fd, err := r.engine.Open(compareSeqFile, true)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var stat phaseStat
+	start := time.Now()
+	for i := 0; i < r.cfg.Count; i++ {
+		if err := r.write(fd, &stat); err != nil {
+			return nil, err
+		}
+	}
+	if err := fd.Sync(); err != nil {
+		return nil, err
+	}
+	r.seqSize = int64(stat.bytes)
+	return stat.report("", time.Now().Sub(start)), nil
+}
+
+// Writers append Count messages in total into one file
+func (r *compareRun) faninAppend() (*PhaseReport, error) {
+	fd, err := r.engine.Open("/compare-fanin", true)
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var stat phaseStat
+	var wg sync.WaitGroup
+	var errOnce sync.Once
+	var firstErr error
+	start := time.Now()
+	for i := 0; i < r.cfg.Writers; i++ {
+		n := r.cfg.Count / r.cfg.Writers
+		if i < r.cfg.Count%r.cfg.Writers {
+			n++
+		}
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			for ; n > 0; n-- {
+				if err := r.write(fd, &stat); err != nil {
+					errOnce.Do(func() { firstErr = err })
+					return
+				}
+			}
+		}(n)
+	}
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
+	}
+	if err := fd.Sync(); err != nil {
+		return nil, err
+	}
+	return stat.report("", time.Now().Sub(start)), nil
+}
+
+func (r *compareRun) openSeq() (EngineFile, error) {
+	if r.seqSize == 0 {
+		return nil, fmt.Errorf("nothing to read, seq-append is failed")
+	}
+	return r.engine.Open(compareSeqFile, false)
+}
+
+// read the message at off, and check the content
+func (r *compareRun) read(fd EngineFile, buf []byte, off int64, stat *phaseStat) error {
+	now := time.Now()
+	n, err := fd.ReadAt(buf, off)
+	if err != nil {
+		return fmt.Errorf("read at %v: %v", off, err)
+	}
+	stat.latency.AddNow(now)
+	if !bytes.Equal(buf[:n], r.msg) {
+		return fmt.Errorf("data mismatch at %v", off)
+	}
+	stat.msgs.Add(1)
+	stat.bytes.AddInt(n)
+	return nil
+}
+
+// read back the file of seq-append message by message
+func (r *compareRun) seqRead() (*PhaseReport, error) {
+	fd, err := r.openSeq()
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var stat phaseStat
+	buf := make([]byte, len(r.msg))
+	start := time.Now()
+	for off := int64(0); off < r.seqSize; off += int64(len(buf)) {
+		if err := r.read(fd, buf, off, &stat); err != nil {
+			return nil, err
+		}
+	}
+	return stat.report("", time.Now().Sub(start)), nil
+}
+
+// read Count messages at random in the file of seq-append
+func (r *compareRun) randRead() (*PhaseReport, error) {
+	fd, err := r.openSeq()
+	if err != nil {
+		return nil, err
+	}
+	defer fd.Close()
+
+	var stat phaseStat
+	buf := make([]byte, len(r.msg))
+	msgs := r.seqSize / int64(len(buf))
+	rnd := rand.New(rand.NewSource(1))
+	start := time.Now()
+	for i := 0; i < r.cfg.Count; i++ {
+		off := rnd.Int63n(msgs) * int64(len(buf))
+		if err := r.read(fd, buf, off, &stat); err != nil {
+			return nil, err
+		}
+	}
+	return stat.report("", time.Now().Sub(start)), nil
+}
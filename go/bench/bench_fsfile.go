@@ -21,7 +21,9 @@ type FsFile struct {
 	BlockSize int    `name:"bs" desc:"block size" default:"200"`
 	Stat      bool   `name:"stat"`
 	RStat     bool   `name:"rstat"`
-	Dir       string `desc:"test directory path" default:"/tmp/madq/bench/fsfile"`
+	Dir       string `desc:"test directory path, or the paths separated by comma to stripe over" default:"/tmp/madq/bench/fsfile"`
+	BlockBit  int    `name:"blockbit" desc:"volume block size in bit" default:"18"`
+	InodeBlk  int    `name:"inodeblk" desc:"number of blocks in one inode" default:"150"`
 }
 
 func (f *FsFile) FlaglyDesc() string {
@@ -37,6 +39,7 @@ func (cfg *FsFile) BenchWrite(f *flow.Flow, volcfg *fs.VolumeConfig, buf []byte)
 		defer DisableTrace()
 	}
 
+	volcfg.Stat = fs.NewStat()
 	now := time.Now()
 	var size ptrace.Size
 	size.AddInt(cfg.BlockSize * cfg.BenchCnt)
@@ -45,7 +48,7 @@ func (cfg *FsFile) BenchWrite(f *flow.Flow, volcfg *fs.VolumeConfig, buf []byte)
 		duration := time.Now().Sub(now)
 
 		if cfg.Stat {
-			println(fs.Stat.String())
+			println(volcfg.Stat.String())
 		}
 		println("write performance:", size.Rate(duration).String())
 	}()
@@ -73,6 +76,7 @@ func (cfg *FsFile) BenchRead(f *flow.Flow, volcfg *fs.VolumeConfig, expect []byt
 	f = f.Fork(0)
 	defer f.Close()
 
+	volcfg.Stat = fs.NewStat()
 	now := time.Now()
 	var size ptrace.Size
 	size.AddInt(cfg.BlockSize * cfg.BenchCnt)
@@ -80,7 +84,7 @@ func (cfg *FsFile) BenchRead(f *flow.Flow, volcfg *fs.VolumeConfig, expect []byt
 		duration := time.Now().Sub(now)
 
 		if cfg.RStat {
-			println(fs.Stat.String())
+			println(volcfg.Stat.String())
 		}
 		println("read performance:", size.Rate(duration).String())
 	}()
@@ -113,12 +117,16 @@ func (cfg *FsFile) FlaglyHandle(f *flow.Flow) error {
 	buf := make([]byte, cfg.BlockSize)
 	rand.Read(buf)
 
-	volcfg := &fs.VolumeConfig{}
+	geo, err := fs.NewGeometry(uint(cfg.BlockBit), cfg.InodeBlk)
+	if err != nil {
+		return err
+	}
+	volcfg := &fs.VolumeConfig{Geometry: geo}
 
 	if cfg.Mem {
-		volcfg.Delegate = bio.NewHybrid(test.NewMemDisk(), fs.BlockBit)
+		volcfg.Delegate = bio.NewHybrid(test.NewMemDisk(), geo.BlockBit)
 	} else {
-		vs, err := fs.NewVolumeSource(cfg.Dir)
+		vs, err := fs.NewVolumeSource(fs.SplitDirs(cfg.Dir), geo)
 		if err != nil {
 			return err
 		}
@@ -132,7 +140,6 @@ func (cfg *FsFile) FlaglyHandle(f *flow.Flow) error {
 	if err := cfg.BenchWrite(f, volcfg, buf); err != nil {
 		return err
 	}
-	fs.ResetStat()
 	if err := cfg.BenchRead(f, volcfg, buf); err != nil {
 		logex.Error(err)
 		return err
@@ -0,0 +1,110 @@
+package bench
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/allmad/madq/go/bio"
+	"github.com/allmad/madq/go/fs"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+type Workload struct {
+	Profile  string `desc:"builtin profile: default, fanin, single, topics" default:"default"`
+	Files    int    `desc:"number of files, -1 means the one in profile" default:"-1"`
+	Writers  int    `desc:"writers of each file, -1 means the one in profile" default:"-1"`
+	Readers  int    `desc:"tailing readers of each file, -1 means the one in profile" default:"-1"`
+	Size     string `desc:"message size distribution, eg: 200, 100-1000, 200:8,4096:2"`
+	Rate     int    `desc:"messages per second of each writer, 0 means unlimited, -1 means the one in profile" default:"-1"`
+	Count    int    `desc:"messages of each writer, -1 means the one in profile" default:"-1"`
+	Duration int    `desc:"seconds of the write phase, -1 means the one in profile" default:"-1"`
+	JSON     bool   `name:"json" desc:"print the report in json"`
+	Mem      bool
+	Dir      string `desc:"test directory path, or the paths separated by comma to stripe over" default:"/tmp/madq/bench/workload"`
+	BlockBit int    `name:"blockbit" desc:"volume block size in bit" default:"18"`
+	InodeBlk int    `name:"inodeblk" desc:"number of blocks in one inode" default:"150"`
+}
+
+func (w *Workload) FlaglyDesc() string {
+	return "benchmark with multiple writers and tailing readers on many files"
+}
+
+// the profile with the overrides in flags
+func (w *Workload) GetProfile() (*Profile, error) {
+	base, ok := Profiles[w.Profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown profile %q, available: %v",
+			w.Profile, strings.Join(ProfileNames(), ", "))
+	}
+	p := *base
+	override := func(dst *int, n int) {
+		if n >= 0 {
+			*dst = n
+		}
+	}
+	override(&p.Files, w.Files)
+	override(&p.Writers, w.Writers)
+	override(&p.Readers, w.Readers)
+	override(&p.Rate, w.Rate)
+	override(&p.Messages, w.Count)
+	if w.Duration >= 0 {
+		p.Duration = time.Duration(w.Duration) * time.Second
+	}
+	if w.Size != "" {
+		size, err := NewSizeDist(w.Size)
+		if err != nil {
+			return nil, err
+		}
+		p.Size = size
+	}
+	return &p, p.Validate()
+}
+
+func (w *Workload) FlaglyHandle(f *flow.Flow) error {
+	defer f.Close()
+
+	p, err := w.GetProfile()
+	if err != nil {
+		return err
+	}
+	geo, err := fs.NewGeometry(uint(w.BlockBit), w.InodeBlk)
+	if err != nil {
+		return err
+	}
+	volcfg := &fs.VolumeConfig{Geometry: geo}
+	if w.Mem {
+		volcfg.Delegate = bio.NewHybrid(test.NewMemDisk(), geo.BlockBit)
+	} else {
+		vs, err := fs.NewVolumeSource(fs.SplitDirs(w.Dir), geo)
+		if err != nil {
+			return err
+		}
+		defer vs.Close()
+		volcfg.Delegate = vs
+	}
+
+	vol, err := fs.NewVolume(f, volcfg)
+	if err != nil {
+		return err
+	}
+	defer vol.Close()
+
+	report, err := RunWorkload(vol, w.Profile, p)
+	if err != nil {
+		return err
+	}
+	if w.JSON {
+		ret, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(os.Stdout, string(ret))
+		return nil
+	}
+	report.WriteTable(os.Stdout)
+	return nil
+}
@@ -0,0 +1,64 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+func TestCompareValidate(t *testing.T) {
+	defer test.New(t)
+
+	c := &Compare{Engines: "fs,none", Count: 1, BlockSize: 1, Writers: 1, Timeout: 1}
+	test.NotNil(c.Validate())
+	c.Engines = " fs , lfs,"
+	test.Nil(c.Validate())
+	test.Equal(c.EngineList(), []string{"fs", "lfs"})
+	c.Writers = 0
+	test.NotNil(c.Validate())
+}
+
+func TestCompare(t *testing.T) {
+	defer test.New(t)
+
+	c := &Compare{
+		Engines:   "fs,lfs",
+		Count:     500,
+		BlockSize: 64,
+		Writers:   4,
+		Timeout:   30,
+		Mem:       true,
+	}
+	report, err := c.Run(flow.New())
+	test.Nil(err)
+	test.Equal(len(report.Results), len(CompareWorkloads)*2)
+
+	for _, workload := range CompareWorkloads {
+		ret := report.Get(workload, "fs")
+		test.Equal(ret.Error, "")
+		test.Equal(ret.Phase.Name, workload)
+		test.Equal(ret.Phase.Messages, int64(c.Count))
+		test.Equal(ret.Phase.Bytes, int64(c.Count*c.BlockSize))
+		test.Equal(report.relative(ret), "1.00x")
+
+		// every workload has a result, succeeded or not
+		ret = report.Get(workload, "lfs")
+		test.True(ret.Phase != nil || ret.Error != "")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	report.WriteTable(buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	test.Equal(len(lines), 2+len(report.Results))
+	test.True(strings.HasPrefix(lines[2], "seq-append"))
+
+	ret, err := json.Marshal(report)
+	test.Nil(err)
+	var obj CompareReport
+	test.Nil(json.Unmarshal(ret, &obj))
+	test.Equal(len(obj.Results), len(report.Results))
+}
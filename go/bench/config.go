@@ -1,8 +1,10 @@
 package bench
 
 type Config struct {
-	FsFile  *FsFile  `flagly:"handler"`
-	RawDisk *RawDisk `flagly:"handler"`
+	FsFile   *FsFile   `flagly:"handler"`
+	RawDisk  *RawDisk  `flagly:"handler"`
+	Workload *Workload `flagly:"handler"`
+	Compare  *Compare  `flagly:"handler"`
 }
 
 func (c *Config) FlaglyDesc() string {
@@ -0,0 +1,175 @@
+package bench
+
+import (
+	"io"
+	"os"
+	"sort"
+
+	"github.com/allmad/madq/go/bio"
+	"github.com/allmad/madq/go/fs"
+	ibio "github.com/allmad/madq/internal/bio"
+	"github.com/allmad/madq/internal/lfs"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+	"github.com/chzyer/test"
+)
+
+// Engine is the common adapter of the storage engines, so they can run
+// the same workloads.
+type Engine interface {
+	Open(name string, create bool) (EngineFile, error)
+	Close() error
+}
+
+type EngineFile interface {
+	io.Writer
+	io.ReaderAt
+	// make the written data readable
+	Sync() error
+	Close() error
+}
+
+// open a fresh engine in dir, or on a memory disk if mem is true.
+type EngineOpener func(f *flow.Flow, dir string, mem bool) (Engine, error)
+
+var Engines = map[string]EngineOpener{
+	"fs":  OpenFsEngine,
+	"lfs": OpenLfsEngine,
+}
+
+func EngineNames() []string {
+	names := make([]string, 0, len(Engines))
+	for name := range Engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// -----------------------------------------------------------------------------
+
+// go/fs
+type fsEngine struct {
+	vs  *fs.VolumeSource
+	vol *fs.Volume
+}
+
+func OpenFsEngine(f *flow.Flow, dir string, mem bool) (Engine, error) {
+	e := &fsEngine{}
+	volcfg := &fs.VolumeConfig{}
+	if mem {
+		volcfg.Delegate = bio.NewHybrid(test.NewMemDisk(), fs.DefaultBlockBit)
+	} else {
+		vs, err := fs.NewVolumeSource([]string{dir}, nil)
+		if err != nil {
+			return nil, err
+		}
+		e.vs = vs
+		volcfg.Delegate = vs
+	}
+
+	vol, err := fs.NewVolume(f, volcfg)
+	if err != nil {
+		if e.vs != nil {
+			e.vs.Close()
+		}
+		return nil, err
+	}
+	e.vol = vol
+	return e, nil
+}
+
+func (e *fsEngine) Open(name string, create bool) (EngineFile, error) {
+	flags := 0
+	if create {
+		flags = os.O_CREATE
+	}
+	fd, err := e.vol.Open(name, flags)
+	if err != nil {
+		return nil, err
+	}
+	return fsEngineFile{fd}, nil
+}
+
+func (e *fsEngine) Close() error {
+	e.vol.Close()
+	if e.vs != nil {
+		e.vs.Close()
+	}
+	return nil
+}
+
+type fsEngineFile struct {
+	*fs.Handle
+}
+
+func (f fsEngineFile) Sync() error {
+	f.Handle.Sync()
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// internal/lfs
+type lfsEngine struct {
+	raw ibio.RawDisker
+	vol *lfs.Volume
+}
+
+func OpenLfsEngine(f *flow.Flow, dir string, mem bool) (Engine, error) {
+	e := &lfsEngine{}
+	if mem {
+		e.raw = test.NewMemDisk()
+	} else {
+		if err := os.RemoveAll(dir); err != nil {
+			return nil, logex.Trace(err)
+		}
+		raw, err := ibio.NewFile(dir)
+		if err != nil {
+			return nil, err
+		}
+		e.raw = raw
+	}
+
+	vol, err := lfs.NewVolume(f, e.raw)
+	if err != nil {
+		e.closeRaw()
+		return nil, err
+	}
+	e.vol = vol
+	return e, nil
+}
+
+func (e *lfsEngine) Open(name string, create bool) (EngineFile, error) {
+	fd, err := e.vol.OpenFile(name, create)
+	if err != nil {
+		return nil, err
+	}
+	return lfsEngineFile{fd}, nil
+}
+
+func (e *lfsEngine) closeRaw() error {
+	if closer, ok := e.raw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (e *lfsEngine) Close() error {
+	e.vol.Close()
+	return e.closeRaw()
+}
+
+type lfsEngineFile struct {
+	*lfs.File
+}
+
+func (f lfsEngineFile) Sync() error {
+	f.File.Flush()
+	return nil
+}
+
+func (f lfsEngineFile) Close() error {
+	f.File.Close()
+	return nil
+}
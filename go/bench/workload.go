@@ -0,0 +1,468 @@
+package bench
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/tabwriter"
+	"time"
+
+	"github.com/allmad/madq/go/fs"
+	"github.com/allmad/madq/go/ptrace"
+	"github.com/chzyer/logex"
+)
+
+// every message is | length (4) | unix nano when it's written (8) | payload |
+const msgHeaderSize = 12
+
+// Profile describes a workload: Files topics, each written by Writers
+// and tailed by Readers at the same time.
+type Profile struct {
+	Files   int
+	Writers int // of each file
+	Readers int // tailing readers of each file
+	Size    SizeDist
+	Rate    int // messages per second of each writer, 0 means unlimited
+	// the write phase stops when every writer writes Messages or Duration
+	// passed, 0 means no limit on either but not both.
+	Messages int
+	Duration time.Duration
+}
+
+var Profiles = map[string]*Profile{
+	"default": {Files: 16, Writers: 4, Readers: 1,
+		Size: MustSizeDist("200"), Duration: 5 * time.Second},
+	// what `bench fsfile` does
+	"single": {Files: 1, Writers: 1,
+		Size: MustSizeDist("200"), Duration: 5 * time.Second},
+	"topics": {Files: 200, Writers: 8, Readers: 1,
+		Size: MustSizeDist("200:8,1024:2"), Rate: 100, Duration: 10 * time.Second},
+	"fanin": {Files: 1, Writers: 64, Readers: 2,
+		Size: MustSizeDist("64-512"), Duration: 5 * time.Second},
+}
+
+func ProfileNames() []string {
+	names := make([]string, 0, len(Profiles))
+	for name := range Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *Profile) Validate() error {
+	switch {
+	case p.Files <= 0:
+		return fmt.Errorf("files must be positive")
+	case p.Writers <= 0:
+		return fmt.Errorf("writers must be positive")
+	case p.Readers < 0:
+		return fmt.Errorf("readers can't be negative")
+	case p.Rate < 0:
+		return fmt.Errorf("rate can't be negative")
+	case p.Messages <= 0 && p.Duration <= 0:
+		return fmt.Errorf("either messages or duration is required")
+	case len(p.Size) == 0:
+		return fmt.Errorf("size is required")
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+type sizeChoice struct {
+	Min, Max int
+	Weight   int
+}
+
+// SizeDist is the distribution of the message size. "200" is always 200
+// bytes, "100-1000" is uniform in [100, 1000], and "200:8,1024-4096:2" is
+// 200 bytes in 80% and [1024, 4096] in 20%.
+// sizes are at least the size of message header.
+type SizeDist []sizeChoice
+
+func NewSizeDist(s string) (SizeDist, error) {
+	var d SizeDist
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		c := sizeChoice{Weight: 1}
+		var err error
+		if idx := strings.Index(item, ":"); idx >= 0 {
+			if c.Weight, err = strconv.Atoi(item[idx+1:]); err != nil || c.Weight <= 0 {
+				return nil, fmt.Errorf("invalid weight in %q", item)
+			}
+			item = item[:idx]
+		}
+		min, max := item, item
+		if idx := strings.Index(item, "-"); idx >= 0 {
+			min, max = item[:idx], item[idx+1:]
+		}
+		if c.Min, err = strconv.Atoi(min); err != nil {
+			return nil, fmt.Errorf("invalid size %q", item)
+		}
+		if c.Max, err = strconv.Atoi(max); err != nil {
+			return nil, fmt.Errorf("invalid size %q", item)
+		}
+		if c.Min < msgHeaderSize || c.Max < c.Min {
+			return nil, fmt.Errorf("invalid size %q: at least %v bytes", item, msgHeaderSize)
+		}
+		d = append(d, c)
+	}
+	if len(d) == 0 {
+		return nil, fmt.Errorf("size distribution is empty")
+	}
+	return d, nil
+}
+
+func MustSizeDist(s string) SizeDist {
+	d, err := NewSizeDist(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (d SizeDist) Next(r *rand.Rand) int {
+	total := 0
+	for _, c := range d {
+		total += c.Weight
+	}
+	n := r.Intn(total)
+	for _, c := range d {
+		if n -= c.Weight; n < 0 {
+			return c.Min + r.Intn(c.Max-c.Min+1)
+		}
+	}
+	panic("unreachable")
+}
+
+func (d SizeDist) Max() int {
+	max := 0
+	for _, c := range d {
+		if c.Max > max {
+			max = c.Max
+		}
+	}
+	return max
+}
+
+func (d SizeDist) String() string {
+	items := make([]string, len(d))
+	for idx, c := range d {
+		items[idx] = strconv.Itoa(c.Min)
+		if c.Max != c.Min {
+			items[idx] += "-" + strconv.Itoa(c.Max)
+		}
+		if len(d) > 1 {
+			items[idx] += ":" + strconv.Itoa(c.Weight)
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+func (d SizeDist) MarshalJSON() ([]byte, error) {
+	return strconv.AppendQuote(nil, d.String()), nil
+}
+
+// -----------------------------------------------------------------------------
+
+// Latency is in nanoseconds when it's encoded in json
+type Latency struct {
+	Mean, P50, P90, P99, P999, Max time.Duration
+}
+
+func newLatency(h *ptrace.Histogram) Latency {
+	return Latency{
+		Mean: h.Mean(),
+		P50:  h.Percentile(0.5),
+		P90:  h.Percentile(0.9),
+		P99:  h.Percentile(0.99),
+		P999: h.Percentile(0.999),
+		Max:  h.Max(),
+	}
+}
+
+// PhaseReport is the result of one phase. the latency in "write" is of
+// Write(), in "tail" is from the message is written to it's read by a
+// tailing reader, and in "read" is of reading one message back after the
+// write phase.
+type PhaseReport struct {
+	Name        string
+	Seconds     float64
+	Messages    int64
+	Bytes       int64
+	MsgPerSec   float64
+	BytesPerSec float64
+	Latency     Latency
+}
+
+func newPhaseReport(name string, d time.Duration, msgs, bytes int64, h *ptrace.Histogram) *PhaseReport {
+	r := &PhaseReport{
+		Name:     name,
+		Seconds:  d.Seconds(),
+		Messages: msgs,
+		Bytes:    bytes,
+		Latency:  newLatency(h),
+	}
+	if r.Seconds > 0 {
+		r.MsgPerSec = float64(msgs) / r.Seconds
+		r.BytesPerSec = float64(bytes) / r.Seconds
+	}
+	return r
+}
+
+type WorkloadReport struct {
+	Profile string
+	Config  *Profile
+	Phases  []*PhaseReport
+}
+
+func (r *WorkloadReport) Phase(name string) *PhaseReport {
+	for _, p := range r.Phases {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (r *WorkloadReport) WriteTable(w io.Writer) {
+	p := r.Config
+	fmt.Fprintf(w, "profile: %v, files: %v, writers: %v, readers: %v, size: %v, rate: %v\n",
+		r.Profile, p.Files, p.Writers, p.Readers, p.Size, p.Rate)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tMSGS\tBYTES\tTIME\tMSG/S\tBYTES/S\tMEAN\tP50\tP90\tP99\tP999\tMAX")
+	for _, ph := range r.Phases {
+		l := ph.Latency
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%.2fs\t%.0f\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+			ph.Name, ph.Messages, ptrace.Unit(ph.Bytes), ph.Seconds,
+			ph.MsgPerSec, ptrace.Unit(int64(ph.BytesPerSec)),
+			l.Mean, l.P50, l.P90, l.P99, l.P999, l.Max)
+	}
+	tw.Flush()
+}
+
+// -----------------------------------------------------------------------------
+
+type phaseStat struct {
+	msgs    ptrace.Int
+	bytes   ptrace.Int
+	latency ptrace.Histogram
+}
+
+func (s *phaseStat) report(name string, d time.Duration) *PhaseReport {
+	return newPhaseReport(name, d, int64(s.msgs), int64(s.bytes), &s.latency)
+}
+
+type workloadRun struct {
+	vol  *fs.Volume
+	p    *Profile
+	done int32 // writers are done
+
+	errOnce sync.Once
+	err     error
+	stop    chan struct{}
+
+	write, tail, read phaseStat
+}
+
+func (w *workloadRun) fail(err error) {
+	w.errOnce.Do(func() {
+		w.err = err
+		close(w.stop)
+	})
+}
+
+func (w *workloadRun) isStopped() bool {
+	select {
+	case <-w.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// RunWorkload runs the write phase with tailing readers, and then reads
+// everything back in the read phase.
+func RunWorkload(vol *fs.Volume, name string, p *Profile) (*WorkloadReport, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	w := &workloadRun{vol: vol, p: p, stop: make(chan struct{})}
+
+	files := make([]*fs.Handle, p.Files)
+	for idx := range files {
+		fd, err := vol.Open(fmt.Sprintf("/workload/%v", idx), os.O_CREATE)
+		if err != nil {
+			return nil, logex.Trace(err)
+		}
+		defer fd.Close()
+		files[idx] = fd
+	}
+
+	start := time.Now()
+	var writers, readers sync.WaitGroup
+	for idx, fd := range files {
+		for i := 0; i < p.Writers; i++ {
+			writers.Add(1)
+			go func(fd *fs.Handle, seed int64) {
+				defer writers.Done()
+				w.writer(fd, seed)
+			}(fd, int64(idx*p.Writers+i))
+		}
+		for i := 0; i < p.Readers; i++ {
+			readers.Add(1)
+			go func(fd *fs.Handle) {
+				defer readers.Done()
+				w.tailer(fd)
+			}(fd)
+		}
+	}
+	writers.Wait()
+	for _, fd := range files {
+		fd.Sync()
+	}
+	writeTime := time.Now().Sub(start)
+	atomic.StoreInt32(&w.done, 1)
+	readers.Wait()
+	tailTime := time.Now().Sub(start)
+	if w.err != nil {
+		return nil, w.err
+	}
+
+	start = time.Now()
+	for _, fd := range files {
+		if err := w.readAll(fd); err != nil {
+			return nil, err
+		}
+	}
+	readTime := time.Now().Sub(start)
+	if w.read.msgs != w.write.msgs {
+		return nil, fmt.Errorf("read %v messages back, but %v are written",
+			w.read.msgs, w.write.msgs)
+	}
+
+	report := &WorkloadReport{Profile: name, Config: p}
+	report.Phases = append(report.Phases, w.write.report("write", writeTime))
+	if p.Readers > 0 {
+		report.Phases = append(report.Phases, w.tail.report("tail", tailTime))
+	}
+	report.Phases = append(report.Phases, w.read.report("read", readTime))
+	return report, nil
+}
+
+func (w *workloadRun) writer(fd *fs.Handle, seed int64) {
+	r := rand.New(rand.NewSource(seed))
+	buf := make([]byte, w.p.Size.Max())
+	r.Read(buf[msgHeaderSize:])
+
+	var interval time.Duration
+	if w.p.Rate > 0 {
+		interval = time.Second / time.Duration(w.p.Rate)
+	}
+	start := time.Now()
+	for i := 0; w.p.Messages <= 0 || i < w.p.Messages; i++ {
+		now := time.Now()
+		if w.p.Duration > 0 && now.Sub(start) >= w.p.Duration {
+			return
+		}
+		if w.isStopped() {
+			return
+		}
+		if interval > 0 {
+			if wait := start.Add(time.Duration(i) * interval).Sub(now); wait > 0 {
+				time.Sleep(wait)
+				now = time.Now()
+			}
+		}
+
+		msg := buf[:w.p.Size.Next(r)]
+		binary.BigEndian.PutUint32(msg[0:4], uint32(len(msg)))
+		binary.BigEndian.PutUint64(msg[4:12], uint64(now.UnixNano()))
+		if _, err := fd.Write(msg); err != nil {
+			w.fail(logex.Trace(err))
+			return
+		}
+		w.write.latency.AddNow(now)
+		w.write.msgs.Add(1)
+		w.write.bytes.AddInt(len(msg))
+	}
+}
+
+// read the message at off, return (nil, nil) if it is beyond size
+func readMessage(fd *fs.Handle, off, size int64, buf []byte) ([]byte, error) {
+	if off+msgHeaderSize > size {
+		return nil, nil
+	}
+	if _, err := fd.ReadAt(buf[:msgHeaderSize], off); err != nil {
+		return nil, logex.Trace(err, off)
+	}
+	n := int64(binary.BigEndian.Uint32(buf))
+	if n < msgHeaderSize || n > int64(len(buf)) {
+		return nil, fmt.Errorf("invalid message length %v at %v", n, off)
+	}
+	if off+n > size {
+		return nil, nil
+	}
+	if _, err := fd.ReadAt(buf[msgHeaderSize:n], off+msgHeaderSize); err != nil {
+		return nil, logex.Trace(err, off)
+	}
+	return buf[:n], nil
+}
+
+func (w *workloadRun) tailer(fd *fs.Handle) {
+	buf := make([]byte, w.p.Size.Max())
+	off := int64(0)
+	for !w.isStopped() {
+		done := atomic.LoadInt32(&w.done) == 1
+		size := fd.Size()
+		msg, err := readMessage(fd, off, size, buf)
+		if err != nil {
+			w.fail(err)
+			return
+		}
+		if msg == nil {
+			if done {
+				return
+			}
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		sent := time.Unix(0, int64(binary.BigEndian.Uint64(msg[4:12])))
+		w.tail.latency.AddNow(sent)
+		w.tail.msgs.Add(1)
+		w.tail.bytes.AddInt(len(msg))
+		off += int64(len(msg))
+	}
+}
+
+func (w *workloadRun) readAll(fd *fs.Handle) error {
+	buf := make([]byte, w.p.Size.Max())
+	size := fd.Size()
+	off := int64(0)
+	for off < size {
+		now := time.Now()
+		msg, err := readMessage(fd, off, size, buf)
+		if err != nil {
+			return err
+		}
+		if msg == nil {
+			return fmt.Errorf("truncated message at %v, size: %v", off, size)
+		}
+		w.read.latency.AddNow(now)
+		w.read.msgs.Add(1)
+		w.read.bytes.AddInt(len(msg))
+		off += int64(len(msg))
+	}
+	return nil
+}
@@ -0,0 +1,116 @@
+package bench
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/allmad/madq/go/bio"
+	"github.com/allmad/madq/go/fs"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+func TestSizeDist(t *testing.T) {
+	defer test.New(t)
+
+	for _, s := range []string{"", "abc", "8", "100-50", "200:0", "200:x"} {
+		_, err := NewSizeDist(s)
+		test.NotNil(err)
+	}
+
+	d, err := NewSizeDist("200:8, 1024-4096:2")
+	test.Nil(err)
+	test.Equal(d.String(), "200:8,1024-4096:2")
+	test.Equal(d.Max(), 4096)
+
+	r := rand.New(rand.NewSource(1))
+	small := 0
+	for i := 0; i < 1000; i++ {
+		n := d.Next(r)
+		if n == 200 {
+			small++
+			continue
+		}
+		test.True(n >= 1024 && n <= 4096)
+	}
+	test.True(small > 700 && small < 900)
+}
+
+func TestWorkloadProfile(t *testing.T) {
+	defer test.New(t)
+
+	w := &Workload{Profile: "none"}
+	_, err := w.GetProfile()
+	test.NotNil(err)
+
+	w = &Workload{Profile: "topics", Files: -1, Writers: 2, Readers: 0,
+		Rate: -1, Count: -1, Duration: -1, Size: "64-128"}
+	p, err := w.GetProfile()
+	test.Nil(err)
+	test.Equal(p.Files, Profiles["topics"].Files)
+	test.Equal(p.Writers, 2)
+	test.Equal(p.Readers, 0)
+	test.Equal(p.Size.String(), "64-128")
+	// the builtin one is not changed
+	test.Equal(Profiles["topics"].Writers, 8)
+}
+
+func TestRunWorkload(t *testing.T) {
+	defer test.New(t)
+
+	vol, err := fs.NewVolume(flow.New(), &fs.VolumeConfig{
+		Delegate: bio.NewHybrid(test.NewMemDisk(), fs.DefaultBlockBit),
+	})
+	test.Nil(err)
+	defer vol.Close()
+
+	p := &Profile{
+		Files:    4,
+		Writers:  3,
+		Readers:  2,
+		Size:     MustSizeDist("16-300:3,2000:1"),
+		Messages: 200,
+	}
+	report, err := RunWorkload(vol, "test", p)
+	test.Nil(err)
+
+	write := report.Phase("write")
+	test.Equal(write.Messages, int64(4*3*200))
+	test.True(write.Bytes > write.Messages*16)
+	test.True(write.Latency.Max > 0)
+	test.True(write.Latency.P50 <= write.Latency.P99)
+
+	// every message is seen by every reader
+	tail := report.Phase("tail")
+	test.Equal(tail.Messages, write.Messages*2)
+	test.Equal(tail.Bytes, write.Bytes*2)
+
+	read := report.Phase("read")
+	test.Equal(read.Messages, write.Messages)
+	test.Equal(read.Bytes, write.Bytes)
+
+	ret, err := json.Marshal(report)
+	test.Nil(err)
+	var obj struct {
+		Config struct{ Size string }
+		Phases []struct {
+			Name     string
+			Messages int64
+			Latency  struct{ P99 int64 }
+		}
+	}
+	test.Nil(json.Unmarshal(ret, &obj))
+	test.Equal(obj.Config.Size, "16-300:3,2000:1")
+	test.Equal(len(obj.Phases), 3)
+	test.Equal(obj.Phases[2].Name, "read")
+	test.Equal(obj.Phases[2].Messages, read.Messages)
+
+	buf := bytes.NewBuffer(nil)
+	report.WriteTable(buf)
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	test.Equal(len(lines), 5)
+	test.True(strings.HasPrefix(lines[4], "read "))
+}
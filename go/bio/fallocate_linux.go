@@ -0,0 +1,14 @@
+package bio
+
+import (
+	"os"
+	"syscall"
+)
+
+// FALLOC_FL_KEEP_SIZE, the size of the file is not changed so that the
+// reads after the data written get EOF as before.
+const fallocKeepSize = 1
+
+func fallocate(fd *os.File, size int64) error {
+	return syscall.Fallocate(int(fd.Fd()), fallocKeepSize, 0, size)
+}
@@ -1,6 +1,7 @@
 package bio
 
 import (
+	"container/list"
 	"io"
 	"os"
 	"path/filepath"
@@ -12,7 +13,10 @@ import (
 )
 
 const (
-	SlotSize = 8
+	DefaultChunkBit = 22
+	// the descriptors cached for reading and writing
+	DefaultReaderCacheSize = 32
+	DefaultWriterCacheSize = 2
 )
 
 var (
@@ -26,23 +30,71 @@ type ReadWriterAt interface {
 	WriteAt(b []byte, off int64) (int, error)
 }
 
+type FileConfig struct {
+	// the size of a chunk file is 1<<ChunkBit, 0 is DefaultChunkBit
+	ChunkBit uint
+	// 0 is the default size
+	ReaderCacheSize int
+	WriterCacheSize int
+	// allocate the whole chunk on opening it for writing
+	Preallocate bool
+}
+
+func (c *FileConfig) init() error {
+	if c.ChunkBit == 0 {
+		c.ChunkBit = DefaultChunkBit
+	}
+	if c.ChunkBit > 32 {
+		return ErrFileInvalidBit.Trace(c.ChunkBit)
+	}
+	if c.ReaderCacheSize <= 0 {
+		c.ReaderCacheSize = DefaultReaderCacheSize
+	}
+	if c.WriterCacheSize <= 0 {
+		c.WriterCacheSize = DefaultWriterCacheSize
+	}
+	return nil
+}
+
+type FileStats struct {
+	// the descriptors opened and closed
+	Opens  int64
+	Closes int64
+	// the lookups in the cache
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Preallocs int64
+}
+
+// File is a sparse file stored in chunk files. the descriptors of the
+// chunks are cached in LRU, the ones for reading and writing are separated
+// so that the readers don't evict the write head.
 type File struct {
 	root      string
+	cfg       FileConfig
 	bit       uint
 	chunkSize int64
 
-	closed int32
-	slot   [SlotSize]*chunkctx
-	m      sync.Mutex
+	closed  int32
+	m       sync.Mutex
+	readers fdCache
+	writers fdCache
+
+	stats FileStats
 }
 
 func NewFile(path string) (*File, error) {
-	return NewFileEx(path, 22)
+	return NewFileEx(path, DefaultChunkBit)
 }
 
 func NewFileEx(root string, bit uint) (*File, error) {
-	if bit > 32 {
-		return nil, ErrFileInvalidBit.Trace(bit)
+	return NewFileWithConfig(root, &FileConfig{ChunkBit: bit})
+}
+
+func NewFileWithConfig(root string, cfg *FileConfig) (*File, error) {
+	if err := cfg.init(); err != nil {
+		return nil, err
 	}
 	if err := os.MkdirAll(root, 0755); err != nil {
 		return nil, logex.Trace(err)
@@ -51,9 +103,12 @@ func NewFileEx(root string, bit uint) (*File, error) {
 	root = filepath.Join(root) + string(os.PathSeparator)
 	file := &File{
 		root:      root,
-		bit:       bit,
-		chunkSize: 1 << bit,
+		cfg:       *cfg,
+		bit:       cfg.ChunkBit,
+		chunkSize: 1 << cfg.ChunkBit,
 	}
+	file.readers.init(cfg.ReaderCacheSize)
+	file.writers.init(cfg.WriterCacheSize)
 	return file, nil
 }
 
@@ -63,44 +118,72 @@ func (f *File) Close() error {
 	}
 	f.m.Lock()
 	defer f.m.Unlock()
-	for idx, chunk := range f.slot {
-		if chunk != nil {
-			chunk.done()
-			f.slot[idx] = nil
-		}
-	}
+	f.readers.clean()
+	f.writers.clean()
 	return nil
 }
 
+func (f *File) Stats() FileStats {
+	return FileStats{
+		Opens:     atomic.LoadInt64(&f.stats.Opens),
+		Closes:    atomic.LoadInt64(&f.stats.Closes),
+		Hits:      atomic.LoadInt64(&f.stats.Hits),
+		Misses:    atomic.LoadInt64(&f.stats.Misses),
+		Evictions: atomic.LoadInt64(&f.stats.Evictions),
+		Preallocs: atomic.LoadInt64(&f.stats.Preallocs),
+	}
+}
+
 func (f *File) getChunk(off int64, writeOp bool) (int64, *chunkctx, error) {
 	if off < 0 {
 		return -1, nil, ErrFileInvalidOffset.Trace()
 	}
 	chunkIdx := off >> f.bit
+	cache := &f.readers
+	if writeOp {
+		cache = &f.writers
+	}
 
 	f.m.Lock()
-	chunk := f.slot[chunkIdx%SlotSize]
+	defer f.m.Unlock()
 
 	// already opened
-	if chunk != nil && chunk.idx == chunkIdx {
+	if chunk := cache.get(chunkIdx); chunk != nil {
+		atomic.AddInt64(&f.stats.Hits, 1)
 		chunk.add()
-		f.m.Unlock()
 		return chunkIdx, chunk, nil
 	}
+	atomic.AddInt64(&f.stats.Misses, 1)
 
-	newChunk, err := newChunkctx(f.root, chunkIdx, writeOp)
+	chunk, err := f.openChunk(chunkIdx, writeOp)
 	if err != nil {
-		f.m.Unlock()
 		return chunkIdx, nil, logex.Trace(err)
 	}
+	chunk.add()
+	evicted := cache.put(chunk)
+	atomic.AddInt64(&f.stats.Evictions, int64(evicted))
+	return chunkIdx, chunk, nil
+}
 
-	newChunk.add()
-	f.slot[chunkIdx%SlotSize] = newChunk
-	f.m.Unlock()
-
-	// clean old chunk
-	chunk.done()
-	return chunkIdx, newChunk, nil
+func (f *File) openChunk(idx int64, writeOp bool) (*chunkctx, error) {
+	chunk, err := newChunkctx(f.root, idx, writeOp)
+	if err != nil {
+		return nil, err
+	}
+	chunk.stats = &f.stats
+	atomic.AddInt64(&f.stats.Opens, 1)
+
+	if writeOp && f.cfg.Preallocate {
+		info, err := chunk.fd.Stat()
+		if err == nil && info.Size() < f.chunkSize {
+			if err := fallocate(chunk.fd, f.chunkSize); err != nil {
+				logex.Info("preallocate chunk", idx, "failed:", err)
+			} else {
+				atomic.AddInt64(&f.stats.Preallocs, 1)
+			}
+		}
+	}
+	return chunk, nil
 }
 
 func (f *File) WriteAt(b []byte, off int64) (n int, err error) {
@@ -188,17 +271,60 @@ func (f *File) Delete(close bool) error {
 
 // -----------------------------------------------------------------------------
 
+// the LRU of the descriptors, the cache holds a ref of each of them
+type fdCache struct {
+	size  int
+	lru   *list.List
+	index map[int64]*list.Element
+}
+
+func (c *fdCache) init(size int) {
+	c.size = size
+	c.lru = list.New()
+	c.index = make(map[int64]*list.Element, size)
+}
+
+func (c *fdCache) get(idx int64) *chunkctx {
+	elem := c.index[idx]
+	if elem == nil {
+		return nil
+	}
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*chunkctx)
+}
+
+// add chunk and returns the number of the chunks evicted
+func (c *fdCache) put(chunk *chunkctx) int {
+	c.index[chunk.idx] = c.lru.PushFront(chunk)
+	evicted := 0
+	for c.lru.Len() > c.size {
+		old := c.lru.Remove(c.lru.Back()).(*chunkctx)
+		delete(c.index, old.idx)
+		old.done()
+		evicted++
+	}
+	return evicted
+}
+
+func (c *fdCache) clean() {
+	for elem := c.lru.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*chunkctx).done()
+	}
+	c.init(c.size)
+}
+
 type chunkctx struct {
-	fd  *os.File
-	idx int64
-	ref int32
+	fd    *os.File
+	idx   int64
+	ref   int32
+	stats *FileStats
 }
 
 func newChunkctx(base string, idx int64, writeOp bool) (*chunkctx, error) {
 	fp := base + strconv.FormatInt(idx, 36)
-	oflag := os.O_RDWR
+	oflag := os.O_RDONLY
 	if writeOp {
-		oflag |= os.O_CREATE
+		oflag = os.O_RDWR | os.O_CREATE
 	}
 	fd, err := os.OpenFile(fp, oflag, 0600)
 	if err != nil {
@@ -225,6 +351,9 @@ func (f *chunkctx) done() {
 	}
 	if atomic.AddInt32(&f.ref, -1) == 0 {
 		f.fd.Close()
+		if f.stats != nil {
+			atomic.AddInt64(&f.stats.Closes, 1)
+		}
 	}
 }
 
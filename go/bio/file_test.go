@@ -92,3 +92,48 @@ func TestFile(t *testing.T) {
 	test.Equals(n, 0)
 	test.Equal(err, io.EOF)
 }
+
+func TestFileFdCache(t *testing.T) {
+	defer test.New(t)
+
+	f, err := NewFileWithConfig(test.Root(), &FileConfig{
+		ChunkBit:        4,
+		ReaderCacheSize: 2,
+		Preallocate:     true,
+	})
+	test.Nil(err)
+	test.Nil(f.Delete(false))
+
+	buf := test.SeqBytes(16 * 16)
+	test.WriteAt(f, buf, 0)
+	stats := f.Stats()
+	test.Equal(stats.Opens, int64(16))
+	test.Equal(stats.Preallocs, int64(16))
+	test.Equal(stats.Evictions, int64(14))
+
+	// the readers on the chunks 8 apart don't thrash each other
+	got := make([]byte, 4)
+	for i := 0; i < 100; i++ {
+		off := int64(i%2*8*16 + 3)
+		test.ReadAt(f, got, off)
+		test.EqualBytes(got, buf[off:off+4])
+	}
+	test.Equal(f.Stats().Opens-stats.Opens, int64(2))
+
+	// and they don't evict the write head
+	stats = f.Stats()
+	for off := int64(0); off < int64(len(buf)); off += 16 {
+		test.ReadAt(f, got, off)
+	}
+	test.WriteAt(f, []byte("head"), 15*16)
+	// chunk 0 is cached
+	test.Equal(f.Stats().Opens-stats.Opens, int64(15))
+
+	// the size is kept after preallocated
+	_, err = f.ReadAt(got, int64(len(buf)-2))
+	test.Equal(err, io.EOF)
+
+	test.Nil(f.Close())
+	stats = f.Stats()
+	test.Equal(stats.Closes, stats.Opens)
+}
@@ -5,35 +5,96 @@ import (
 	"github.com/chzyer/logex"
 )
 
+// the capacity of the block cache in bytes
+const DefaultCacheSize = 32 << 20
+
 type Hybrid struct {
 	ReadWriterAt
 	blksize int
 	blkbit  uint
-	lru     *common.LRUBytes
+	cache   *common.BlockCache
 }
 
 func NewHybrid(rw ReadWriterAt, blkbit uint) *Hybrid {
+	return NewHybridEx(rw, blkbit, DefaultCacheSize)
+}
+
+// NewHybridEx caches the blocks of rw in cacheSize bytes at most.
+func NewHybridEx(rw ReadWriterAt, blkbit uint, cacheSize int64) *Hybrid {
 	return &Hybrid{
 		ReadWriterAt: rw,
 		blkbit:       blkbit,
 		blksize:      1 << blkbit,
-		lru:          common.NewLRUBytes(8, 1<<blkbit),
+		cache:        common.NewBlockCache(cacheSize, blkbit),
 	}
 }
 
-func (h *Hybrid) ReadData(off int64, n int) ([]byte, error) {
-	offblk := off & int64(h.blksize-1)
-	if int(offblk)+n > h.blksize {
-		buf := make([]byte, n)
-		n, err := h.ReadWriterAt.ReadAt(buf, off)
+func (h *Hybrid) SetCacheSize(n int64) {
+	h.cache.SetCapacity(n)
+}
+
+func (h *Hybrid) CacheStats() common.CacheStats {
+	return h.cache.Stats()
+}
+
+// Invalidate drops the cache of [off, off+n), it must be called after the
+// region is rewritten without WriteAt.
+func (h *Hybrid) Invalidate(off int64, n int) {
+	h.cache.Invalidate(off, n)
+}
+
+// WriteAt invalidates the cache of the region written.
+func (h *Hybrid) WriteAt(b []byte, off int64) (int, error) {
+	n, err := h.ReadWriterAt.WriteAt(b, off)
+	h.cache.Invalidate(off, len(b))
+	return n, err
+}
+
+// ReadRef appends the views of [off, off+n) in cache to r without copying.
+func (h *Hybrid) ReadRef(r *Ref, off int64, n int) error {
+	for read := 0; read < n; {
+		blkoff := (off + int64(read)) & int64(h.blksize-1)
+		size := h.blksize - int(blkoff)
+		if size > n-read {
+			size = n - read
+		}
+		block := h.cache.Get(off + int64(read) - blkoff)
+		data, err := block.Get(h.ReadWriterAt, off+int64(read), size)
 		if err != nil {
-			return nil, logex.Trace(err)
+			block.Release()
+			return logex.Trace(err)
 		}
-		return buf[:n], nil
+		r.Append(data, block)
+		read += size
 	}
+	return nil
+}
 
-	alignOff := off - offblk
-	block := h.lru.Get(alignOff)
-	ret, err := block.Get(h.ReadWriterAt, off, n)
-	return ret, logex.Trace(err)
+// ReadData returns a copy of [off, off+n).
+func (h *Hybrid) ReadData(off int64, n int) ([]byte, error) {
+	r := NewRef()
+	defer r.Release()
+	if err := h.ReadRef(r, off, n); err != nil {
+		return nil, logex.Trace(err)
+	}
+	buf := make([]byte, n)
+	r.CopyTo(buf)
+	return buf, nil
+}
+
+// Prefetch loads the blocks overlap with [off, off+n) into the cache, it
+// stops at the end of data.
+func (h *Hybrid) Prefetch(off int64, n int) {
+	end := off + int64(n)
+	for blk := off &^ int64(h.blksize-1); blk < end; blk += int64(h.blksize) {
+		block := h.cache.Prefetch(blk)
+		if block == nil {
+			continue
+		}
+		_, err := block.Get(h.ReadWriterAt, blk, 1)
+		block.Release()
+		if err != nil {
+			return
+		}
+	}
 }
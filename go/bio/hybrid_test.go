@@ -1,8 +1,11 @@
 package bio
 
 import (
+	"math/rand"
+	"sync"
 	"testing"
 
+	"github.com/allmad/madq/go/common"
 	"github.com/chzyer/test"
 )
 
@@ -35,3 +38,208 @@ func TestHybrid(t *testing.T) {
 		off += n
 	}
 }
+
+func TestHybridCache(t *testing.T) {
+	defer test.New(t)
+
+	disk := test.NewMemDisk()
+	buf := test.SeqBytes(4 << 10)
+	_, err := disk.WriteAt(buf, 0)
+	test.Nil(err)
+
+	// 16 bytes per block, 2 blocks per shard
+	h := NewHybridEx(disk, 4, 32*common.CacheShards)
+	read := func(off int64) {
+		ret, err := h.ReadData(off, 4)
+		test.Nil(err)
+		test.EqualBytes(ret, buf[off:off+4])
+	}
+
+	// the hot block is seen again after evicted from A1in
+	read(0)
+	for blk := int64(1); blk <= 2; blk++ {
+		read(blk * 16 * common.CacheShards)
+	}
+	read(0)
+	read(0)
+	test.Equal(h.CacheStats().Hits, int64(1))
+
+	// sequential scan doesn't evict it
+	for off := int64(1 << 10); off < int64(len(buf)); off += 16 {
+		read(off)
+	}
+	stats := h.CacheStats()
+	read(0)
+	test.Equal(h.CacheStats().Hits, stats.Hits+1)
+	test.True(stats.Evictions > 0)
+	test.True(h.cache.Size() <= 32*common.CacheShards)
+
+	// rewritten by WriteAt
+	_, err = h.WriteAt([]byte("abcd"), 2)
+	test.Nil(err)
+	ret, err := h.ReadData(0, 8)
+	test.Nil(err)
+	test.EqualBytes(ret, append(append(buf[:2:2], "abcd"...), buf[6:8]...))
+	test.Equal(h.CacheStats().Invalidations, int64(1))
+
+	h.SetCacheSize(0)
+	test.Equal(h.cache.Size(), int64(0))
+}
+
+func TestHybridCacheCapacity(t *testing.T) {
+	defer test.New(t)
+
+	disk := test.NewMemDisk()
+	buf := test.SeqBytes(1 << 10)
+	_, err := disk.WriteAt(buf, 0)
+	test.Nil(err)
+	read := func(h *Hybrid, off int64) {
+		ret, err := h.ReadData(off, 4)
+		test.Nil(err)
+		test.EqualBytes(ret, buf[off:off+4])
+	}
+
+	// negative means nothing is cached
+	h := NewHybridEx(disk, 4, -1)
+	read(h, 0)
+	read(h, 0)
+	test.Equal(h.cache.Size(), int64(0))
+	test.Equal(h.CacheStats().Hits, int64(0))
+
+	// less than one block per shard still caches
+	h.SetCacheSize(1)
+	read(h, 0)
+	read(h, 0)
+	test.Equal(h.CacheStats().Hits, int64(1))
+	test.Equal(h.cache.Size(), int64(16))
+
+	h.SetCacheSize(-1 << 20)
+	test.Equal(h.cache.Size(), int64(0))
+}
+
+func TestHybridConcurrent(t *testing.T) {
+	defer test.New(t)
+
+	disk := &testLockedDisk{disk: test.NewMemDisk()}
+	buf := test.SeqBytes(64 << 10)
+	_, err := disk.WriteAt(buf, 0)
+	test.Nil(err)
+
+	h := NewHybridEx(disk, 8, 16<<10)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for j := 0; j < 2000; j++ {
+				n := r.Intn(64) + 1
+				off := r.Int63n(int64(len(buf) - n))
+				ret, err := h.ReadData(off, n)
+				test.Nil(err)
+				test.EqualBytes(ret, buf[off:off+int64(n)])
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	stats := h.CacheStats()
+	test.True(stats.Hits > 0)
+	test.True(stats.Evictions > 0)
+	test.True(h.cache.Size() <= 16<<10)
+}
+
+type testLockedDisk struct {
+	m    sync.Mutex
+	disk *test.MemDisk
+}
+
+func (d *testLockedDisk) WriteAt(b []byte, off int64) (int, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.disk.WriteAt(b, off)
+}
+
+func (d *testLockedDisk) ReadAt(b []byte, off int64) (int, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.disk.ReadAt(b, off)
+}
+
+func TestHybridReadRef(t *testing.T) {
+	defer test.New(t)
+
+	disk := test.NewMemDisk()
+	buf := test.SeqBytes(1 << 10)
+	_, err := disk.WriteAt(buf, 0)
+	test.Nil(err)
+
+	h := NewHybridEx(disk, 6, 1<<10)
+	r := NewRef()
+	test.Nil(h.ReadRef(r, 60, 100))
+	test.Equal(r.Len(), 100)
+	// 60-64, 64-128, 128-160
+	test.Equal(len(r.Bytes()), 3)
+	got := make([]byte, r.Len())
+	test.Equal(r.CopyTo(got), 100)
+	test.EqualBytes(got, buf[60:160])
+
+	// the views are valid after evicted
+	h.SetCacheSize(0)
+	h.SetCacheSize(1 << 10)
+	for off := int64(0); off < int64(len(buf)); off += 64 {
+		_, err := h.ReadData(off, 64)
+		test.Nil(err)
+	}
+	test.Equal(r.CopyTo(got), 100)
+	test.EqualBytes(got, buf[60:160])
+	r.Release()
+
+	r = NewRef()
+	test.NotNil(h.ReadRef(r, 1000, 100))
+	r.Release()
+}
+
+func BenchmarkHybridReadData(b *testing.B) {
+	benchHybridRead(b, func(h *Hybrid, buf []byte, off int64) {
+		data, err := h.ReadData(off, len(buf))
+		if err != nil {
+			b.Fatal(err)
+		}
+		copy(buf, data)
+	})
+}
+
+func BenchmarkHybridReadRef(b *testing.B) {
+	benchHybridRead(b, func(h *Hybrid, buf []byte, off int64) {
+		r := NewRef()
+		if err := h.ReadRef(r, off, len(buf)); err != nil {
+			b.Fatal(err)
+		}
+		r.CopyTo(buf)
+		r.Release()
+	})
+}
+
+// read 200 bytes messages in sequence
+func benchHybridRead(b *testing.B, read func(*Hybrid, []byte, int64)) {
+	disk := test.NewMemDisk()
+	data := test.RandBytes(1 << 20)
+	_, err := disk.WriteAt(data, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	h := NewHybridEx(disk, 12, 2<<20)
+	buf := make([]byte, 200)
+
+	b.ReportAllocs()
+	b.SetBytes(int64(len(buf)))
+	b.ResetTimer()
+	off := int64(0)
+	for i := 0; i < b.N; i++ {
+		if off+int64(len(buf)) > int64(len(data)) {
+			off = 0
+		}
+		read(h, buf, off)
+		off += int64(len(buf))
+	}
+}
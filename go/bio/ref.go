@@ -0,0 +1,69 @@
+package bio
+
+import (
+	"sync"
+
+	"github.com/allmad/madq/go/common"
+)
+
+var refPool = sync.Pool{
+	New: func() interface{} { return new(Ref) },
+}
+
+// Ref is the views of some data without copying, the data is valid until
+// it's released.
+type Ref struct {
+	bufs   [][]byte
+	blocks []*common.CacheBlock
+	size   int
+}
+
+// NewRef returns an empty Ref from the pool.
+func NewRef() *Ref {
+	return refPool.Get().(*Ref)
+}
+
+// Append adds the view b of block to the end, and the ref of block is
+// taken over. block is nil if b is not in cache.
+func (r *Ref) Append(b []byte, block *common.CacheBlock) {
+	r.bufs = append(r.bufs, b)
+	if block != nil {
+		r.blocks = append(r.blocks, block)
+	}
+	r.size += len(b)
+}
+
+// the views in order, they must not be changed
+func (r *Ref) Bytes() [][]byte {
+	return r.bufs
+}
+
+func (r *Ref) Len() int {
+	return r.size
+}
+
+func (r *Ref) CopyTo(b []byte) int {
+	n := 0
+	for _, buf := range r.bufs {
+		if n == len(b) {
+			break
+		}
+		n += copy(b[n:], buf)
+	}
+	return n
+}
+
+// Release gives back the blocks, r and the views must not be used after.
+func (r *Ref) Release() {
+	for idx, block := range r.blocks {
+		block.Release()
+		r.blocks[idx] = nil
+	}
+	for idx := range r.bufs {
+		r.bufs[idx] = nil
+	}
+	r.bufs = r.bufs[:0]
+	r.blocks = r.blocks[:0]
+	r.size = 0
+	refPool.Put(r)
+}
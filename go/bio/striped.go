@@ -0,0 +1,282 @@
+package bio
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/chzyer/logex"
+)
+
+var (
+	ErrStripedMemberMissing = logex.Define("striped member is missing")
+	ErrStripedMismatch      = logex.Define("striped member is not in the layout")
+	ErrStripedNotEmpty      = logex.Define("striped member is not empty")
+	ErrStripedInvalidWeight = logex.Define("invalid striped weights")
+)
+
+// the manifest in each member directory
+const StripedManifest = "stripe.json"
+
+type stripedManifest struct {
+	// the same in all members of a volume
+	ID       string   `json:"id"`
+	Index    int      `json:"index"`
+	ChunkBit uint     `json:"chunk_bit"`
+	Dirs     []string `json:"dirs"`
+	Weights  []int    `json:"weights"`
+}
+
+func readStripedManifest(dir string) (*stripedManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, StripedManifest))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrStripedMemberMissing.Trace(dir)
+		}
+		return nil, logex.Trace(err)
+	}
+	m := new(stripedManifest)
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, logex.Trace(err, dir)
+	}
+	return m, nil
+}
+
+func (m *stripedManifest) write(dir string) error {
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return logex.Trace(err)
+	}
+	return logex.Trace(ioutil.WriteFile(filepath.Join(dir, StripedManifest), data, 0644))
+}
+
+// IsStriped reports whether dir is a member of a striped volume.
+func IsStriped(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, StripedManifest))
+	return err == nil
+}
+
+type StripedConfig struct {
+	Dirs []string
+	// the chunks of Dirs[i] in every sum(Weights) chunks, nil means
+	// round-robin
+	Weights []int
+	File    FileConfig
+}
+
+// Striped spreads the chunks over several directories by weight, so a
+// volume can use many disks. the chunk files in each directory are named
+// by their global index as in File.
+type Striped struct {
+	id      string
+	bit     uint
+	dirs    []string
+	members []*File
+	// the member of chunk idx is pattern[idx % len(pattern)]
+	pattern []int
+}
+
+// CreateStriped makes a new layout in cfg.Dirs which must be empty.
+func CreateStriped(cfg *StripedConfig) (*Striped, error) {
+	if err := cfg.File.init(); err != nil {
+		return nil, err
+	}
+	weights := cfg.Weights
+	if weights == nil {
+		weights = make([]int, len(cfg.Dirs))
+		for idx := range weights {
+			weights[idx] = 1
+		}
+	}
+	if len(cfg.Dirs) == 0 || len(weights) != len(cfg.Dirs) {
+		return nil, ErrStripedInvalidWeight.Trace(weights)
+	}
+	for _, w := range weights {
+		if w <= 0 {
+			return nil, ErrStripedInvalidWeight.Trace(weights)
+		}
+	}
+
+	for _, dir := range cfg.Dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, logex.Trace(err)
+		}
+		names, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, logex.Trace(err)
+		}
+		if len(names) > 0 {
+			return nil, ErrStripedNotEmpty.Trace(dir)
+		}
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, logex.Trace(err)
+	}
+	for idx, dir := range cfg.Dirs {
+		m := &stripedManifest{
+			ID:       hex.EncodeToString(id),
+			Index:    idx,
+			ChunkBit: cfg.File.ChunkBit,
+			Dirs:     cfg.Dirs,
+			Weights:  weights,
+		}
+		if err := m.write(dir); err != nil {
+			return nil, err
+		}
+	}
+	return OpenStriped(cfg.Dirs, &cfg.File)
+}
+
+// OpenStriped opens the layout in dirs, which can be in any order. all the
+// members must be there.
+func OpenStriped(dirs []string, cfg *FileConfig) (*Striped, error) {
+	var manifests []*stripedManifest
+	for _, dir := range dirs {
+		m, err := readStripedManifest(dir)
+		if err != nil {
+			return nil, err
+		}
+		manifests = append(manifests, m)
+	}
+	if len(manifests) == 0 {
+		return nil, ErrStripedMemberMissing.Trace()
+	}
+
+	layout := manifests[0]
+	seen := make(map[int]string, len(dirs))
+	for idx, m := range manifests {
+		if m.ID != layout.ID || m.Index < 0 || m.Index >= len(layout.Dirs) {
+			return nil, ErrStripedMismatch.Trace(dirs[idx])
+		}
+		if dir, ok := seen[m.Index]; ok {
+			return nil, ErrStripedMismatch.Trace(dir, dirs[idx])
+		}
+		seen[m.Index] = dirs[idx]
+	}
+	var missing []string
+	for idx, dir := range layout.Dirs {
+		if _, ok := seen[idx]; !ok {
+			missing = append(missing, dir)
+		}
+	}
+	if len(missing) > 0 {
+		return nil, ErrStripedMemberMissing.Trace(missing)
+	}
+
+	fileCfg := *cfg
+	fileCfg.ChunkBit = layout.ChunkBit
+	s := &Striped{
+		id:      layout.ID,
+		bit:     layout.ChunkBit,
+		dirs:    make([]string, len(layout.Dirs)),
+		members: make([]*File, len(layout.Dirs)),
+		pattern: stripedPattern(layout.Weights),
+	}
+	for idx, dir := range seen {
+		s.dirs[idx] = dir
+	}
+	for idx, dir := range s.dirs {
+		f, err := NewFileWithConfig(dir, &fileCfg)
+		if err != nil {
+			s.Close()
+			return nil, logex.Trace(err)
+		}
+		s.members[idx] = f
+	}
+	return s, nil
+}
+
+// interleave the members by smooth weighted round-robin, so the chunks of
+// a member are not adjacent if possible.
+func stripedPattern(weights []int) []int {
+	total := 0
+	for _, w := range weights {
+		total += w
+	}
+	current := make([]int, len(weights))
+	pattern := make([]int, 0, total)
+	for len(pattern) < total {
+		best := 0
+		for idx, w := range weights {
+			current[idx] += w
+			if current[idx] > current[best] {
+				best = idx
+			}
+		}
+		current[best] -= total
+		pattern = append(pattern, best)
+	}
+	return pattern
+}
+
+// the directories of members in the order of the layout
+func (s *Striped) Dirs() []string {
+	return s.dirs
+}
+
+func (s *Striped) member(off int64) *File {
+	idx := (off >> s.bit) % int64(len(s.pattern))
+	return s.members[s.pattern[idx]]
+}
+
+// split [off, off+len(b)) by chunks, and call fn on their members
+func (s *Striped) each(b []byte, off int64, fn func(*File, []byte, int64) (int, error)) (int, error) {
+	if off < 0 {
+		return 0, ErrFileInvalidOffset.Trace()
+	}
+	chunkSize := int64(1) << s.bit
+	n := 0
+	for n < len(b) {
+		cur := off + int64(n)
+		size := int(chunkSize - cur&(chunkSize-1))
+		if size > len(b)-n {
+			size = len(b) - n
+		}
+		written, err := fn(s.member(cur), b[n:n+size], cur)
+		n += written
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+func (s *Striped) ReadAt(b []byte, off int64) (int, error) {
+	return s.each(b, off, (*File).ReadAt)
+}
+
+func (s *Striped) WriteAt(b []byte, off int64) (int, error) {
+	return s.each(b, off, (*File).WriteAt)
+}
+
+// the sum of the stats of members
+func (s *Striped) Stats() FileStats {
+	var ret FileStats
+	for _, f := range s.members {
+		if f == nil {
+			continue
+		}
+		stats := f.Stats()
+		ret.Opens += stats.Opens
+		ret.Closes += stats.Closes
+		ret.Hits += stats.Hits
+		ret.Misses += stats.Misses
+		ret.Evictions += stats.Evictions
+		ret.Preallocs += stats.Preallocs
+	}
+	return ret
+}
+
+func (s *Striped) Close() error {
+	for _, f := range s.members {
+		if f != nil {
+			f.Close()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+package bio
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chzyer/test"
+)
+
+var _ ReadWriterAt = new(Striped)
+
+func testStripedDirs(root string, n int) []string {
+	dirs := make([]string, n)
+	for idx := range dirs {
+		dirs[idx] = filepath.Join(root, fmt.Sprint("disk", idx))
+	}
+	return dirs
+}
+
+// the number of chunk files in each dir
+func testChunkCount(dirs []string) []int {
+	ret := make([]int, len(dirs))
+	for idx, dir := range dirs {
+		infos, err := ioutil.ReadDir(dir)
+		test.Nil(err)
+		for _, info := range infos {
+			if info.Name() != StripedManifest {
+				ret[idx]++
+			}
+		}
+	}
+	return ret
+}
+
+func TestStripedPattern(t *testing.T) {
+	defer test.New(t)
+
+	test.Equal(stripedPattern([]int{1, 1, 1}), []int{0, 1, 2})
+	test.Equal(stripedPattern([]int{2, 1}), []int{0, 1, 0})
+	test.Equal(stripedPattern([]int{3, 1}), []int{0, 0, 1, 0})
+}
+
+func TestStriped(t *testing.T) {
+	defer test.New(t)
+	dirs := testStripedDirs(test.Root(), 3)
+
+	s, err := CreateStriped(&StripedConfig{
+		Dirs:    dirs,
+		Weights: []int{2, 1, 1},
+		File:    FileConfig{ChunkBit: 4},
+	})
+	test.Nil(err)
+	buf := test.SeqBytes(16*8 + 5)
+	test.WriteAt(s, buf, 3)
+	test.Equal(testChunkCount(dirs), []int{5, 2, 2})
+	test.Nil(s.Close())
+
+	_, err = CreateStriped(&StripedConfig{Dirs: dirs})
+	test.Equal(err, ErrStripedNotEmpty)
+
+	// in any order
+	s, err = OpenStriped([]string{dirs[2], dirs[0], dirs[1]}, &FileConfig{})
+	test.Nil(err)
+	test.Equal(s.Dirs(), dirs)
+	got := make([]byte, len(buf))
+	test.ReadAt(s, got, 3)
+	test.EqualBytes(got, buf)
+	_, err = s.ReadAt(got, 16*9)
+	test.Equal(err, io.EOF)
+	test.Nil(s.Close())
+
+	_, err = OpenStriped(dirs[:2], &FileConfig{})
+	test.Equal(err, ErrStripedMemberMissing)
+	_, err = OpenStriped([]string{dirs[0], dirs[0], dirs[1]}, &FileConfig{})
+	test.Equal(err, ErrStripedMismatch)
+
+	{ // from other volume
+		other := testStripedDirs(filepath.Join(dirs[0], "..", "other"), 1)
+		s, err := CreateStriped(&StripedConfig{Dirs: other})
+		test.Nil(err)
+		s.Close()
+		_, err = OpenStriped([]string{dirs[0], dirs[1], other[0]}, &FileConfig{})
+		test.Equal(err, ErrStripedMismatch)
+	}
+
+	test.Nil(os.RemoveAll(dirs[1]))
+	_, err = OpenStriped(dirs, &FileConfig{})
+	test.Equal(err, ErrStripedMemberMissing)
+}
+
+// writers on the different chunks go to the different dirs in parallel
+func TestStripedThroughput(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+
+	const (
+		writers = 8
+		size    = 4 << 20
+	)
+	data := test.RandBytes(64 << 10)
+	run := func(rw ReadWriterAt) time.Duration {
+		now := time.Now()
+		var wg sync.WaitGroup
+		for i := 0; i < writers; i++ {
+			wg.Add(1)
+			go func(base int64) {
+				defer wg.Done()
+				for off := int64(0); off < size; off += int64(len(data)) {
+					test.WriteAt(rw, data, base+off)
+				}
+			}(int64(i) * size)
+		}
+		wg.Wait()
+
+		got := make([]byte, len(data))
+		for off := int64(0); off < writers*size; off += int64(len(data)) {
+			test.ReadAt(rw, got, off)
+			test.EqualBytes(got, data)
+		}
+		return time.Now().Sub(now)
+	}
+
+	f, err := NewFileEx(filepath.Join(root, "single"), 20)
+	test.Nil(err)
+	single := run(f)
+	f.Close()
+
+	dirs := testStripedDirs(filepath.Join(root, "striped"), 4)
+	s, err := CreateStriped(&StripedConfig{
+		Dirs: dirs,
+		File: FileConfig{ChunkBit: 20},
+	})
+	test.Nil(err)
+	striped := run(s)
+	s.Close()
+
+	test.Equal(testChunkCount(dirs), []int{8, 8, 8, 8})
+	mb := float64(writers*size) / (1 << 20)
+	t.Logf("single: %.1fMB/s, striped over %v dirs: %.1fMB/s",
+		mb/single.Seconds(), len(dirs), mb/striped.Seconds())
+}
+
+func BenchmarkFileWrite(b *testing.B) {
+	f, err := NewFileEx(test.Root(), 20)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	benchWrite(b, f)
+}
+
+func BenchmarkStripedWrite(b *testing.B) {
+	s, err := CreateStriped(&StripedConfig{
+		Dirs: testStripedDirs(test.Root(), 4),
+		File: FileConfig{ChunkBit: 20},
+	})
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	benchWrite(b, s)
+}
+
+// parallel writers, each on its own region
+func benchWrite(b *testing.B, rw ReadWriterAt) {
+	data := test.RandBytes(64 << 10)
+	var region int64
+	var m sync.Mutex
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		m.Lock()
+		base := region << 26
+		region++
+		m.Unlock()
+		off := int64(0)
+		for pb.Next() {
+			if _, err := rw.WriteAt(data, base+off); err != nil {
+				b.Fatal(err)
+			}
+			off = (off + int64(len(data))) & (1<<26 - 1)
+		}
+	})
+}
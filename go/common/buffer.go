@@ -0,0 +1,60 @@
+package common
+
+// the buffers in [512B, 4MB] are pooled by the size classes of power of 2
+const (
+	minBufferBit = 9
+	maxBufferBit = 22
+	// the bytes pooled in each size class at most
+	bufferPoolSize = 16 << 20
+)
+
+var bufferPools = newBufferPools()
+
+func newBufferPools() []chan []byte {
+	pools := make([]chan []byte, maxBufferBit-minBufferBit+1)
+	for idx := range pools {
+		n := bufferPoolSize >> uint(idx+minBufferBit)
+		if n > 1024 {
+			n = 1024
+		}
+		pools[idx] = make(chan []byte, n)
+	}
+	return pools
+}
+
+// the index of the size class which can hold n bytes, -1 if not pooled
+func bufferClass(n int) int {
+	for bit := uint(minBufferBit); bit <= maxBufferBit; bit++ {
+		if n <= 1<<bit {
+			return int(bit - minBufferBit)
+		}
+	}
+	return -1
+}
+
+// GetBuffer returns a buffer of n bytes which is not zeroed, it should be
+// given back by PutBuffer after used.
+func GetBuffer(n int) []byte {
+	idx := bufferClass(n)
+	if idx < 0 {
+		return make([]byte, n)
+	}
+	select {
+	case buf := <-bufferPools[idx]:
+		return buf[:n]
+	default:
+		return make([]byte, n, 1<<uint(idx+minBufferBit))
+	}
+}
+
+// PutBuffer gives buf back to the pool, it must not be used after.
+func PutBuffer(buf []byte) {
+	idx := bufferClass(cap(buf))
+	if idx < 0 || cap(buf) != 1<<uint(idx+minBufferBit) {
+		return
+	}
+	select {
+	case bufferPools[idx] <- buf[:0]:
+	default:
+	}
+}
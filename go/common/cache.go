@@ -0,0 +1,321 @@
+package common
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"sync/atomic"
+
+	"github.com/chzyer/logex"
+)
+
+// CacheBlock is the data of the block at offset. it's filled from the
+// reader on demand, so the block at the end of a growing file is extended
+// by the later reads.
+//
+// the block is ref-counted, the cache holds one ref until it's evicted, and
+// the data is given back to the buffer pool after all the refs released.
+type CacheBlock struct {
+	data   []byte
+	size   int
+	offset int64
+	guard  sync.RWMutex
+	refs   int32
+	// 1 if it's loaded by Prefetch and not read yet
+	prefetched int32
+}
+
+func newCacheBlock(off int64, size int) *CacheBlock {
+	return &CacheBlock{
+		data:   GetBuffer(size)[:0],
+		size:   size,
+		offset: off,
+		refs:   1,
+	}
+}
+
+func (b *CacheBlock) Ref() {
+	atomic.AddInt32(&b.refs, 1)
+}
+
+// Release drops a ref, the data got before must not be used after.
+func (b *CacheBlock) Release() {
+	refs := atomic.AddInt32(&b.refs, -1)
+	if refs == 0 {
+		PutBuffer(b.data)
+		b.data = nil
+	} else if refs < 0 {
+		panic("cache block: released too many times")
+	}
+}
+
+func (b *CacheBlock) Get(r io.ReaderAt, off int64, n int) ([]byte, error) {
+	start := off - b.offset
+	end := start + int64(n)
+
+	b.guard.RLock()
+	if int64(len(b.data)) >= end {
+		ret := b.data[start:end:end]
+		b.guard.RUnlock()
+		return ret, nil
+	}
+	b.guard.RUnlock()
+
+	b.guard.Lock()
+	defer b.guard.Unlock()
+	size := int64(len(b.data))
+	if size < end {
+		// read
+		b.data = b.data[:b.size]
+		readbytes, err := r.ReadAt(b.data[size:], b.offset+size)
+		if readbytes > 0 && logex.Equal(err, io.EOF) {
+			err = nil
+		}
+		b.data = b.data[:size+int64(readbytes)]
+		if err != nil {
+			return nil, logex.Trace(err)
+		}
+		if int64(len(b.data)) < end {
+			return nil, logex.Trace(io.EOF)
+		}
+	}
+	return b.data[start:end:end], nil
+}
+
+func (b *CacheBlock) Len() int {
+	b.guard.RLock()
+	ret := len(b.data)
+	b.guard.RUnlock()
+	return ret
+}
+
+// -----------------------------------------------------------------------------
+
+const CacheShards = 16
+
+type CacheStats struct {
+	Hits          int64
+	Misses        int64
+	Evictions     int64
+	Invalidations int64
+	// the blocks loaded by Prefetch, and the ones of them read after
+	Prefetches   int64
+	PrefetchHits int64
+}
+
+// BlockCache is a 2Q cache of the blocks, which is sharded by offset.
+// the blocks seen once are kept in a small FIFO, they go to the LRU only if
+// they are seen again after evicted from the FIFO, so a sequential scan
+// doesn't flush the hot blocks out.
+type BlockCache struct {
+	blkbit uint
+	shards [CacheShards]cacheShard
+	stats  CacheStats
+}
+
+type cacheEntry struct {
+	block *CacheBlock
+	// in Am or A1in
+	am bool
+}
+
+type cacheShard struct {
+	m        sync.Mutex
+	capacity int64
+	// A1in: FIFO of the blocks seen once
+	in      *list.List
+	inBytes int64
+	// Am: LRU of the blocks seen again
+	am      *list.List
+	amBytes int64
+	// A1out: the offsets of blocks evicted from A1in
+	out   *list.List
+	ghost map[int64]*list.Element
+	index map[int64]*list.Element
+}
+
+// capacity is in bytes, and every block costs 1<<blkbit.
+func NewBlockCache(capacity int64, blkbit uint) *BlockCache {
+	c := &BlockCache{blkbit: blkbit}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.in = list.New()
+		s.am = list.New()
+		s.out = list.New()
+		s.ghost = make(map[int64]*list.Element)
+		s.index = make(map[int64]*list.Element)
+	}
+	c.SetCapacity(capacity)
+	return c
+}
+
+func (c *BlockCache) blksize() int64 {
+	return 1 << c.blkbit
+}
+
+func (c *BlockCache) shard(off int64) *cacheShard {
+	return &c.shards[(off>>c.blkbit)&(CacheShards-1)]
+}
+
+// SetCapacity resizes the cache, the blocks over capacity are evicted.
+// capacity <= 0 drops all the blocks, otherwise every shard holds one block
+// at least, or nothing could be cached in it.
+func (c *BlockCache) SetCapacity(capacity int64) {
+	if capacity < 0 {
+		capacity = 0
+	} else if capacity > 0 && capacity < c.MinCapacity() {
+		capacity = c.MinCapacity()
+	}
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.m.Lock()
+		s.capacity = capacity / CacheShards
+		c.reclaimLocked(s)
+		s.m.Unlock()
+	}
+}
+
+// the smallest positive capacity, one block per shard
+func (c *BlockCache) MinCapacity() int64 {
+	return CacheShards * c.blksize()
+}
+
+// Get returns the block at off which is aligned to the block size, a new
+// empty one is returned on missing. the block must be released after used.
+func (c *BlockCache) Get(off int64) *CacheBlock {
+	return c.get(off, false)
+}
+
+// Prefetch returns a new block at off to be loaded, or nil if it's in cache
+// already. the block must be released after loaded.
+func (c *BlockCache) Prefetch(off int64) *CacheBlock {
+	return c.get(off, true)
+}
+
+func (c *BlockCache) get(off int64, prefetch bool) *CacheBlock {
+	s := c.shard(off)
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if elem, ok := s.index[off]; ok {
+		if prefetch {
+			return nil
+		}
+		entry := elem.Value.(*cacheEntry)
+		if entry.am {
+			s.am.MoveToFront(elem)
+		}
+		atomic.AddInt64(&c.stats.Hits, 1)
+		if atomic.CompareAndSwapInt32(&entry.block.prefetched, 1, 0) {
+			atomic.AddInt64(&c.stats.PrefetchHits, 1)
+		}
+		entry.block.Ref()
+		return entry.block
+	}
+
+	entry := &cacheEntry{block: newCacheBlock(off, int(c.blksize()))}
+	entry.block.Ref()
+	if prefetch {
+		entry.block.prefetched = 1
+		atomic.AddInt64(&c.stats.Prefetches, 1)
+	} else {
+		atomic.AddInt64(&c.stats.Misses, 1)
+	}
+	if elem, ok := s.ghost[off]; ok {
+		// seen again
+		s.out.Remove(elem)
+		delete(s.ghost, off)
+		entry.am = true
+		s.index[off] = s.am.PushFront(entry)
+		s.amBytes += c.blksize()
+	} else {
+		s.index[off] = s.in.PushFront(entry)
+		s.inBytes += c.blksize()
+	}
+	c.reclaimLocked(s)
+	return entry.block
+}
+
+func (c *BlockCache) reclaimLocked(s *cacheShard) {
+	// a quarter for A1in, and remember the blocks as many as half of the
+	// capacity in A1out
+	kin := s.capacity / 4
+	kout := int(s.capacity / c.blksize() / 2)
+
+	for s.inBytes+s.amBytes > s.capacity {
+		if s.in.Len() == 0 && s.am.Len() == 0 {
+			break
+		}
+		if s.in.Len() > 0 && (s.inBytes > kin || s.am.Len() == 0) {
+			entry := s.in.Remove(s.in.Back()).(*cacheEntry)
+			off := entry.block.offset
+			delete(s.index, off)
+			entry.block.Release()
+			s.inBytes -= c.blksize()
+			s.ghost[off] = s.out.PushFront(off)
+		} else {
+			entry := s.am.Remove(s.am.Back()).(*cacheEntry)
+			delete(s.index, entry.block.offset)
+			entry.block.Release()
+			s.amBytes -= c.blksize()
+		}
+		atomic.AddInt64(&c.stats.Evictions, 1)
+	}
+	for s.out.Len() > kout {
+		delete(s.ghost, s.out.Remove(s.out.Back()).(int64))
+	}
+}
+
+// Invalidate drops the blocks overlap with [off, off+n), the blocks got
+// before are not changed.
+func (c *BlockCache) Invalidate(off int64, n int) {
+	if n <= 0 {
+		return
+	}
+	end := off + int64(n)
+	for blk := off &^ (c.blksize() - 1); blk < end; blk += c.blksize() {
+		s := c.shard(blk)
+		s.m.Lock()
+		if elem, ok := s.index[blk]; ok {
+			entry := elem.Value.(*cacheEntry)
+			if entry.am {
+				s.am.Remove(elem)
+				s.amBytes -= c.blksize()
+			} else {
+				s.in.Remove(elem)
+				s.inBytes -= c.blksize()
+			}
+			delete(s.index, blk)
+			entry.block.Release()
+			atomic.AddInt64(&c.stats.Invalidations, 1)
+		}
+		if elem, ok := s.ghost[blk]; ok {
+			s.out.Remove(elem)
+			delete(s.ghost, blk)
+		}
+		s.m.Unlock()
+	}
+}
+
+// the bytes of the blocks in cache
+func (c *BlockCache) Size() int64 {
+	var ret int64
+	for i := range c.shards {
+		s := &c.shards[i]
+		s.m.Lock()
+		ret += s.inBytes + s.amBytes
+		s.m.Unlock()
+	}
+	return ret
+}
+
+func (c *BlockCache) Stats() CacheStats {
+	return CacheStats{
+		Hits:          atomic.LoadInt64(&c.stats.Hits),
+		Misses:        atomic.LoadInt64(&c.stats.Misses),
+		Evictions:     atomic.LoadInt64(&c.stats.Evictions),
+		Invalidations: atomic.LoadInt64(&c.stats.Invalidations),
+		Prefetches:    atomic.LoadInt64(&c.stats.Prefetches),
+		PrefetchHits:  atomic.LoadInt64(&c.stats.PrefetchHits),
+	}
+}
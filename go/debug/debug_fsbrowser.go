@@ -1,7 +1,10 @@
 package debug
 
 import (
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
@@ -15,42 +18,95 @@ import (
 )
 
 type FSBrowser struct {
-	Dir string `type:"[0]" desc:"directory"`
+	Dir    string `type:"[0]" desc:"volume directory, or all the directories separated by comma which the volume is striped over"`
+	Exec   string `desc:"run the commands separated by ';' and exit"`
+	Script string `desc:"run the commands in the file, one per line, and exit"`
+	JSON   bool   `name:"json" desc:"json output for every command"`
+}
+
+// Output is where the commands write their results
+type Output struct {
+	io.Writer
+	JSON bool
+}
+
+func (o *Output) json(flag bool) bool {
+	return o.JSON || flag
+}
+
+func (o *Output) WriteJSON(obj interface{}) error {
+	ret, err := json.MarshalIndent(obj, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(o, string(ret))
+	return err
 }
 
 func (cfg *FSBrowser) FlaglyHandle(f *flow.Flow) error {
+	return cfg.Run(f, os.Stdout)
+}
+
+func (cfg *FSBrowser) Run(f *flow.Flow, w io.Writer) error {
 	defer f.Close()
 
 	if cfg.Dir == "" {
 		return fmt.Errorf("error: directory is required")
 	}
 
-	if _, err := os.Stat(cfg.Dir); os.IsNotExist(err) {
-		return err
+	dirs := fs.SplitDirs(cfg.Dir)
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			return err
+		}
+		flock, err := common.NewFlock(dir)
+		if err != nil {
+			return err
+		}
+		defer flock.Unlock()
 	}
 
-	flock, err := common.NewFlock(cfg.Dir)
+	fd, err := fs.OpenVolumeFile(dirs)
 	if err != nil {
 		return err
 	}
-	defer flock.Unlock()
+	defer fd.Close()
 
-	fd, err := bio.NewFile(cfg.Dir)
-	if err != nil {
-		return err
+	// keep going if the volume can't be opened, the raw commands still work
+	out := &Output{Writer: w, JSON: cfg.JSON}
+	raw := &RawVolume{Disk: fd, Geometry: fs.DefaultGeometry()}
+	if raw.Header, err = fs.ReadVolumeHeader(fd); err != nil {
+		println("read volume header:", err.Error())
+		println("assume the default geometry:", raw.Geometry.String())
+		return cfg.handle(filepath.Base(cfg.Dir), nil, raw, out)
 	}
+	raw.Geometry = raw.Header.Geometry()
+
 	vol, err := fs.NewVolume(f, &fs.VolumeConfig{
-		Delegate: bio.NewHybrid(fd, fs.BlockBit),
+		Delegate: bio.NewHybrid(fd, raw.Geometry.BlockBit),
 	})
 	if err != nil {
-		return err
+		println("open volume:", err.Error())
+		return cfg.handle(filepath.Base(cfg.Dir), nil, raw, out)
 	}
 	defer vol.Close()
 
-	return cfg.handle(filepath.Base(cfg.Dir), vol)
+	return cfg.handle(filepath.Base(cfg.Dir), vol, raw, out)
 }
 
-func (cfg *FSBrowser) handle(name string, vol *fs.Volume) error {
+// vol is nil if the volume can't be opened
+func (cfg *FSBrowser) handle(name string, vol *fs.Volume, raw *RawVolume, out *Output) error {
+	switch {
+	case cfg.Script != "":
+		script, err := ioutil.ReadFile(cfg.Script)
+		if err != nil {
+			return err
+		}
+		return cfg.runScript(string(script), vol, raw, out)
+	case cfg.Exec != "":
+		return cfg.runScript(cfg.Exec, vol, raw, out)
+	}
+
 	rl, err := readline.New(name + "> ")
 	if err != nil {
 		return err
@@ -64,16 +120,46 @@ func (cfg *FSBrowser) handle(name string, vol *fs.Volume) error {
 		} else if line.CanContinue() {
 			continue
 		}
-		sp := strings.Fields(line.Line)
-		fs := flagly.New("")
-		fs.Context(vol)
-		if err := fs.Compile(&FSBrowserCmd{}); err != nil {
-			return err
-		}
-		if err := fs.Run(sp); err != nil {
+		if err := cfg.runCommand(line.Line, vol, raw, out); err != nil {
 			println(err.Error())
 			continue
 		}
 	}
 	return nil
 }
+
+// commands are separated by newlines or ';', lines start with '#' are
+// ignored. stop at the first failed command.
+func (cfg *FSBrowser) runScript(script string, vol *fs.Volume, raw *RawVolume, out *Output) error {
+	for lineNo, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, cmd := range strings.Split(line, ";") {
+			if strings.TrimSpace(cmd) == "" {
+				continue
+			}
+			if err := cfg.runCommand(cmd, vol, raw, out); err != nil {
+				return fmt.Errorf("line %v: %v: %v", lineNo+1, strings.TrimSpace(cmd), err)
+			}
+		}
+	}
+	return nil
+}
+
+func (cfg *FSBrowser) runCommand(cmd string, vol *fs.Volume, raw *RawVolume, out *Output) error {
+	sp := strings.Fields(cmd)
+	if len(sp) == 0 {
+		return nil
+	}
+	if vol == nil && !rawCommands[sp[0]] {
+		return fmt.Errorf("volume is not opened, only raw commands are available")
+	}
+	fs := flagly.New("")
+	fs.Context(vol, raw, out)
+	if err := fs.Compile(&FSBrowserCmd{}); err != nil {
+		return err
+	}
+	return fs.Run(sp)
+}
@@ -5,6 +5,8 @@ import (
 	"encoding/hex"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/allmad/madq/go/fs"
 	"github.com/allmad/madq/go/ptrace"
@@ -17,49 +19,137 @@ type FSBrowserCmd struct {
 	Read  *FSBrowserCmdRead  `flagly:"handler"`
 	Write *FSBrowserCmdWrite `flagly:"handler"`
 	Cat   *FSBrowserCmdCat   `flagly:"handler"`
+	Inode *FSBrowserCmdInode `flagly:"handler"`
+	Chain *FSBrowserCmdChain `flagly:"handler"`
+	Imap  *FSBrowserCmdImap  `flagly:"handler"`
+	Log   *FSBrowserCmdLog   `flagly:"handler"`
 }
 
 // -----------------------------------------------------------------------------
 
 type FSBrowserCmdStat struct {
 	Files []string `type:"[]"`
+	JSON  bool     `name:"json"`
 }
 
-func (cfg *FSBrowserCmdStat) FlaglyHandle(vol *fs.Volume) {
+type fileStatView struct {
+	Name  string
+	Mtime time.Time
+	Size  int64
+}
+
+func (cfg *FSBrowserCmdStat) FlaglyHandle(vol *fs.Volume, out *Output) error {
+	if len(cfg.Files) == 0 {
+		return cfg.StatVolume(vol, out)
+	}
+
+	var views []*fileStatView
+	var failed []string
 	for _, f := range cfg.Files {
 		fd, err := vol.Open(f, 0)
 		if err != nil {
-			println(err.Error())
+			failed = append(failed, f)
+			fmt.Fprintln(out, err.Error())
 			continue
 		}
-		cfg.StatFile(vol, fd)
+		v, err := cfg.StatFile(fd)
 		fd.Close()
+		if err != nil {
+			failed = append(failed, f)
+			fmt.Fprintln(out, err.Error())
+			continue
+		}
+		if out.json(cfg.JSON) {
+			views = append(views, v)
+			continue
+		}
+		fmt.Fprintf(out, "name: %v\n", v.Name)
+		fmt.Fprintf(out, "mtime: %v\n", v.Mtime)
+		fmt.Fprintf(out, "size: %v (%v)\n", v.Size, ptrace.Unit(v.Size))
 	}
+	if out.json(cfg.JSON) {
+		if err := out.WriteJSON(views); err != nil {
+			return err
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("stat failed: %v", strings.Join(failed, ", "))
+	}
+	return nil
 }
 
-func (cfg *FSBrowserCmdStat) StatFile(vol *fs.Volume, fd *fs.Handle) {
+// live throughput of the volume
+func (cfg *FSBrowserCmdStat) StatVolume(vol *fs.Volume, out *Output) error {
+	tp := &vol.Stat().Throughput
+	if out.json(cfg.JSON) {
+		return out.WriteJSON(tp)
+	}
+
 	buf := bytes.NewBuffer(nil)
+	for _, m := range []struct {
+		name  string
+		meter *ptrace.Meter
+		bytes bool
+	}{
+		{"flush", &tp.FlushBytes, true},
+		{"append", &tp.Records, false},
+		{"read", &tp.ReadBytes, true},
+	} {
+		fmt.Fprintf(buf, "%v:", m.name)
+		for _, w := range []time.Duration{time.Second, 10 * time.Second, time.Minute} {
+			rate := m.meter.PerSecond(w)
+			if m.bytes {
+				fmt.Fprintf(buf, " %v/S(%v)", ptrace.Size(rate), w)
+			} else {
+				fmt.Fprintf(buf, " %.1f/S(%v)", rate, w)
+			}
+		}
+		fmt.Fprintf(buf, ", total: %v\n", m.meter.Total())
+	}
+	_, err := out.Write(buf.Bytes())
+	return err
+}
+
+func (cfg *FSBrowserCmdStat) StatFile(fd *fs.Handle) (*fileStatView, error) {
 	inode, err := fd.Stat()
 	if err != nil {
-		println(err.Error())
-		return
+		return nil, err
 	}
+	return &fileStatView{
+		Name:  fd.Name(),
+		Mtime: inode.Mtime.Get(),
+		Size:  fd.Size(),
+	}, nil
+}
 
-	fmt.Fprintf(buf, "name: %v\n", fd.Name())
-	fmt.Fprintf(buf, "mtime: %v\n", inode.Mtime.Get())
-	fmt.Fprintf(buf, "size: %v (%v)\n", fd.Size(), ptrace.Unit(fd.Size()))
+// -----------------------------------------------------------------------------
 
-	os.Stderr.Write(buf.Bytes())
+type FSBrowserCmdList struct {
+	JSON bool `name:"json"`
 }
 
-// -----------------------------------------------------------------------------
+type listItemView struct {
+	Name string
+	Ino  int32
+}
 
-type FSBrowserCmdList struct{}
+func (cfg *FSBrowserCmdList) FlaglyHandle(vol *fs.Volume, out *Output) error {
+	if !out.json(cfg.JSON) {
+		for _, n := range vol.List() {
+			fmt.Fprintln(out, n)
+		}
+		return nil
+	}
 
-func (cfg *FSBrowserCmdList) FlaglyHandle(vol *fs.Volume) {
-	for _, n := range vol.List() {
-		println(n)
+	items := []listItemView{}
+	for _, name := range vol.Names() {
+		ino, err := vol.Ino(name)
+		if err != nil {
+			return err
+		}
+		items = append(items, listItemView{name, ino})
 	}
+	return out.WriteJSON(items)
 }
 
 // -----------------------------------------------------------------------------
@@ -68,9 +158,16 @@ type FSBrowserCmdRead struct {
 	Fpath string `type:"[0]"`
 	Off   int64  `type:"[1]"`
 	N     int    `type:"[2]"`
+	JSON  bool   `name:"json"`
+}
+
+type readView struct {
+	Path   string
+	Offset int64
+	Data   []byte
 }
 
-func (cfg *FSBrowserCmdRead) FlaglyHandle(vol *fs.Volume) error {
+func (cfg *FSBrowserCmdRead) FlaglyHandle(vol *fs.Volume, out *Output) error {
 	fd, err := vol.Open(cfg.Fpath, 0)
 	if err != nil {
 		return err
@@ -81,7 +178,10 @@ func (cfg *FSBrowserCmdRead) FlaglyHandle(vol *fs.Volume) error {
 	if _, err := fd.ReadAt(buf, cfg.Off); err != nil {
 		return err
 	}
-	fmt.Println(hex.Dump(buf))
+	if out.json(cfg.JSON) {
+		return out.WriteJSON(&readView{cfg.Fpath, cfg.Off, buf})
+	}
+	fmt.Fprintln(out, hex.Dump(buf))
 	return nil
 }
 
@@ -90,9 +190,15 @@ func (cfg *FSBrowserCmdRead) FlaglyHandle(vol *fs.Volume) error {
 type FSBrowserCmdWrite struct {
 	Fpath   string `type:"[0]"`
 	Content string `type:"[1]"`
+	JSON    bool   `name:"json"`
 }
 
-func (cfg *FSBrowserCmdWrite) FlaglyHandle(vol *fs.Volume) error {
+type writeView struct {
+	Path    string
+	Written int
+}
+
+func (cfg *FSBrowserCmdWrite) FlaglyHandle(vol *fs.Volume, out *Output) error {
 	if cfg.Fpath == "" {
 		return flagly.Errorf("fpath is required")
 	}
@@ -105,11 +211,15 @@ func (cfg *FSBrowserCmdWrite) FlaglyHandle(vol *fs.Volume) error {
 	}
 	defer fd.Close()
 
-	if _, err := fd.Write([]byte(cfg.Content)); err != nil {
+	n, err := fd.Write([]byte(cfg.Content))
+	if err != nil {
 		return err
 	}
 	fd.Sync()
 
+	if out.json(cfg.JSON) {
+		return out.WriteJSON(&writeView{cfg.Fpath, n})
+	}
 	return nil
 }
 
@@ -117,9 +227,10 @@ func (cfg *FSBrowserCmdWrite) FlaglyHandle(vol *fs.Volume) error {
 
 type FSBrowserCmdCat struct {
 	Fpath string `type:"[0]"`
+	JSON  bool   `name:"json"`
 }
 
-func (cfg *FSBrowserCmdCat) FlaglyHandle(vol *fs.Volume) error {
+func (cfg *FSBrowserCmdCat) FlaglyHandle(vol *fs.Volume, out *Output) error {
 	fd, err := vol.Open(cfg.Fpath, 0)
 	if err != nil {
 		return err
@@ -130,5 +241,5 @@ func (cfg *FSBrowserCmdCat) FlaglyHandle(vol *fs.Volume) error {
 	if size > 1024 {
 		return fmt.Errorf("file size exceed 1024, can't use cat: %v", size)
 	}
-	return (&FSBrowserCmdRead{cfg.Fpath, 0, int(size)}).FlaglyHandle(vol)
+	return (&FSBrowserCmdRead{cfg.Fpath, 0, int(size), cfg.JSON}).FlaglyHandle(vol, out)
 }
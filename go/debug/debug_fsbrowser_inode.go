@@ -0,0 +1,185 @@
+package debug
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/allmad/madq/go/fs"
+)
+
+type inodeOffset struct {
+	Idx  int
+	Addr fs.ShortAddr
+}
+
+// the view of fs.Inode for output
+type inodeView struct {
+	Addr      fs.Address
+	Idx       int32
+	Ino       int32
+	Start     int32
+	Size      int32
+	PrevInode [6]fs.Address
+	PrevGroup fs.Address
+	GroupSize int32
+	GroupIdx  int32
+	Mtime     time.Time
+	Index     fs.Address
+	Offsets   []inodeOffset
+}
+
+func newInodeView(ino *fs.Inode) *inodeView {
+	v := &inodeView{
+		Addr:      ino.Addr(),
+		Idx:       ino.Idx(),
+		Ino:       int32(ino.Ino),
+		Start:     int32(ino.Start),
+		Size:      int32(ino.Size),
+		PrevGroup: ino.PrevGroup,
+		GroupSize: int32(ino.GroupSize),
+		GroupIdx:  int32(ino.GroupIdx),
+		Mtime:     ino.Mtime.Get(),
+		Index:     ino.Index,
+	}
+	for idx, p := range ino.PrevInode {
+		v.PrevInode[idx] = *p
+	}
+	for idx, off := range ino.Offsets {
+		if !off.IsEmpty() {
+			v.Offsets = append(v.Offsets, inodeOffset{idx, off})
+		}
+	}
+	return v
+}
+
+func (v *inodeView) WriteTable(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "addr\t%v\n", v.Addr)
+	fmt.Fprintf(tw, "idx\t%v\n", v.Idx)
+	fmt.Fprintf(tw, "ino\t%v\n", v.Ino)
+	fmt.Fprintf(tw, "start\t%v\n", v.Start)
+	fmt.Fprintf(tw, "size\t%v\n", v.Size)
+	for idx, p := range v.PrevInode {
+		fmt.Fprintf(tw, "prev[%v]\t%v\n", 1<<uint(idx), p)
+	}
+	fmt.Fprintf(tw, "prevgroup\t%v\n", v.PrevGroup)
+	fmt.Fprintf(tw, "group\t%v/%v\n", v.GroupIdx, v.GroupSize)
+	fmt.Fprintf(tw, "mtime\t%v\n", v.Mtime)
+	fmt.Fprintf(tw, "index\t%v\n", v.Index)
+	fmt.Fprintf(tw, "offsets\t%v\n", len(v.Offsets))
+	for _, off := range v.Offsets {
+		fmt.Fprintf(tw, "  [%v]\t%v\n", off.Idx, off.Addr)
+	}
+	tw.Flush()
+}
+
+// -----------------------------------------------------------------------------
+
+type FSBrowserCmdInode struct {
+	Fpath string `type:"[0]"`
+	Idx   int    `type:"[1]" default:"-1" desc:"index of the inode, the lastest one by default"`
+	JSON  bool   `name:"json"`
+}
+
+func (cfg *FSBrowserCmdInode) FlaglyHandle(vol *fs.Volume, out *Output) error {
+	fd, err := vol.Open(cfg.Fpath, 0)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	ino, err := fd.InodeAt(int32(cfg.Idx))
+	if err != nil {
+		return err
+	}
+	v := newInodeView(ino)
+	if out.json(cfg.JSON) {
+		return out.WriteJSON(v)
+	}
+	v.WriteTable(out)
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+type FSBrowserCmdChain struct {
+	Fpath string `type:"[0]"`
+	JSON  bool   `name:"json"`
+}
+
+type chainView struct {
+	Inodes []*inodeView
+	Errors []string
+}
+
+func (cfg *FSBrowserCmdChain) FlaglyHandle(vol *fs.Volume, out *Output) error {
+	fd, err := vol.Open(cfg.Fpath, 0)
+	if err != nil {
+		return err
+	}
+	defer fd.Close()
+
+	chain, err := fd.VerifyChain()
+	if err != nil {
+		return err
+	}
+	v := &chainView{Errors: chain.Errors}
+	for _, ino := range chain.Inodes {
+		v.Inodes = append(v.Inodes, newInodeView(ino))
+	}
+	if out.json(cfg.JSON) {
+		if err := out.WriteJSON(v); err != nil {
+			return err
+		}
+	} else {
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "IDX\tADDR\tSTART\tSIZE\tPREV\tINDEX\tOFFSETS\tMTIME")
+		for _, ino := range v.Inodes {
+			fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\t%v\n",
+				ino.Idx, ino.Addr, ino.Start, ino.Size, ino.PrevInode[0],
+				ino.Index, len(ino.Offsets), ino.Mtime.Format(time.RFC3339))
+		}
+		tw.Flush()
+		for _, e := range v.Errors {
+			fmt.Fprintln(out, "error:", e)
+		}
+	}
+	if len(v.Errors) > 0 {
+		return fmt.Errorf("chain of %v is broken: %v errors", cfg.Fpath, len(v.Errors))
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+type FSBrowserCmdImap struct {
+	Ino  int  `type:"[0]"`
+	JSON bool `name:"json"`
+}
+
+func (cfg *FSBrowserCmdImap) FlaglyHandle(vol *fs.Volume, out *Output) error {
+	slot, err := vol.InodeMapSlot(int32(cfg.Ino))
+	if err != nil {
+		return err
+	}
+	if out.json(cfg.JSON) {
+		return out.WriteJSON(slot)
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "ino\t%v\n", slot.Ino)
+	fmt.Fprintf(tw, "page\t%v\n", slot.Page)
+	fmt.Fprintf(tw, "index\t%v\n", slot.Index)
+	fmt.Fprintf(tw, "addr\t%v\n", slot.Addr)
+	for idx, c := range slot.Disk {
+		if !c.Valid {
+			fmt.Fprintf(tw, "slot %v\toffset: %v, invalid: %v\n", idx, c.Offset, c.Error)
+			continue
+		}
+		fmt.Fprintf(tw, "slot %v\toffset: %v, seq: %v, addr: %v\n", idx, c.Offset, c.Seq, c.Addr)
+	}
+	tw.Flush()
+	return nil
+}
@@ -0,0 +1,122 @@
+package debug
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/allmad/madq/go/fs"
+)
+
+// commands which only need the RawVolume
+var rawCommands = map[string]bool{
+	"log": true,
+}
+
+// RawVolume is the volume on disk, it's available even if the volume
+// can't be opened.
+type RawVolume struct {
+	Disk     io.ReaderAt
+	Geometry *fs.Geometry
+	Header   *fs.VolumeHeader // nil if it's broken
+}
+
+// -----------------------------------------------------------------------------
+
+type FSBrowserCmdLog struct {
+	From  int64 `type:"[0]" desc:"the address to start, the start of the log by default"`
+	Limit int   `desc:"stop after limit batches, 0 means no limit"`
+	JSON  bool  `name:"json"`
+}
+
+type logInodeView struct {
+	Addr  fs.Address
+	Ino   int32
+	Name  string `json:",omitempty"`
+	Idx   int32
+	Start int32
+	Size  int32
+}
+
+type logBatchView struct {
+	Addr       fs.Address
+	Length     int64
+	DataLength int64
+	IndexNodes int
+	Inodes     []logInodeView
+	// written after the checkpoint in the header, it's lost on recovery
+	AfterCheckpoint bool `json:",omitempty"`
+}
+
+// vol can be nil, then the names of files are unknown
+func (cfg *FSBrowserCmdLog) FlaglyHandle(vol *fs.Volume, raw *RawVolume, out *Output) error {
+	names := make(map[int32]string)
+	if vol != nil {
+		names[0] = "/"
+		for _, name := range vol.Names() {
+			if ino, err := vol.Ino(name); err == nil {
+				names[ino] = name
+			}
+		}
+	}
+	checkpoint := fs.Address(-1)
+	if raw.Header != nil {
+		checkpoint = raw.Header.Checkpoint
+	}
+
+	var batches []*logBatchView
+	w := fs.NewLogWalker(raw.Disk, raw.Geometry, cfg.From)
+	for cfg.Limit <= 0 || len(batches) < cfg.Limit {
+		batch, err := w.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		v := &logBatchView{
+			Addr:            batch.Addr,
+			Length:          batch.Length,
+			DataLength:      batch.DataLength,
+			IndexNodes:      batch.IndexNodes,
+			AfterCheckpoint: checkpoint >= 0 && batch.End() > checkpoint,
+		}
+		for _, ino := range batch.Inodes {
+			v.Inodes = append(v.Inodes, logInodeView{
+				Addr:  ino.Addr(),
+				Ino:   int32(ino.Ino),
+				Name:  names[int32(ino.Ino)],
+				Idx:   ino.Idx(),
+				Start: int32(ino.Start),
+				Size:  int32(ino.Size),
+			})
+		}
+		batches = append(batches, v)
+		if !out.json(cfg.JSON) {
+			v.WriteText(out)
+		}
+	}
+
+	if out.json(cfg.JSON) {
+		return out.WriteJSON(batches)
+	}
+	fmt.Fprintf(out, "%v batches, end: %v, checkpoint: %v\n", len(batches), w.Offset(), checkpoint)
+	return nil
+}
+
+func (v *logBatchView) WriteText(w io.Writer) {
+	mark := ""
+	if v.AfterCheckpoint {
+		mark = " (after checkpoint)"
+	}
+	fmt.Fprintf(w, "batch %v: length: %v, data: %v, index nodes: %v, inodes: %v%v\n",
+		v.Addr, v.Length, v.DataLength, v.IndexNodes, len(v.Inodes), mark)
+	for _, ino := range v.Inodes {
+		name := ino.Name
+		if name == "" {
+			name = "?"
+		}
+		fmt.Fprintf(w, "  inode %v: ino: %v (%v), idx: %v, start: %v, size: %v\n",
+			ino.Addr, ino.Ino, name, ino.Idx, ino.Start, ino.Size)
+	}
+}
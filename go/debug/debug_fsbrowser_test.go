@@ -0,0 +1,160 @@
+package debug
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/allmad/madq/go/bio"
+	"github.com/allmad/madq/go/fs"
+	"github.com/allmad/madq/go/fs/fstest"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+func testNewVolume(dir string) {
+	os.RemoveAll(dir)
+	vol, closer := fstest.OpenVolume(dir)
+	defer closer()
+	for _, name := range []string{"a", "b"} {
+		fstest.WriteFile(vol, name, []byte("hello "+name))
+	}
+}
+
+func testRunFSBrowser(cfg *FSBrowser) (string, error) {
+	buf := bytes.NewBuffer(nil)
+	err := cfg.Run(flow.New(), buf)
+	return buf.String(), err
+}
+
+func TestFSBrowserExec(t *testing.T) {
+	defer test.New(t)
+	dir := test.Root()
+	testNewVolume(dir)
+	defer os.RemoveAll(dir)
+
+	out, err := testRunFSBrowser(&FSBrowser{
+		Dir:  dir,
+		Exec: "ls; stat a b;cat b ; chain a",
+		JSON: true,
+	})
+	test.Nil(err)
+
+	dec := json.NewDecoder(strings.NewReader(out))
+	var list []listItemView
+	test.Nil(dec.Decode(&list))
+	test.Equal(len(list), 2)
+	test.Equal(list[0].Name, "a")
+
+	var stats []fileStatView
+	test.Nil(dec.Decode(&stats))
+	test.Equal(len(stats), 2)
+	test.Equal(stats[1].Size, int64(len("hello b")))
+
+	var read readView
+	test.Nil(dec.Decode(&read))
+	test.Equal(string(read.Data), "hello b")
+
+	var chain chainView
+	test.Nil(dec.Decode(&chain))
+	test.Equal(len(chain.Inodes), 1)
+	test.Equal(len(chain.Errors), 0)
+	test.False(dec.More())
+
+	// stop at the first error
+	out, err = testRunFSBrowser(&FSBrowser{
+		Dir:  dir,
+		Exec: "cat a; cat nosuchfile; ls",
+	})
+	test.NotNil(err)
+	test.True(strings.Contains(err.Error(), "cat nosuchfile"))
+	test.True(strings.Contains(out, "hello a"))
+	test.False(strings.Contains(out, "\t"))
+
+	_, err = testRunFSBrowser(&FSBrowser{Dir: dir, Exec: "nosuchcmd"})
+	test.NotNil(err)
+}
+
+func TestFSBrowserStriped(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+	os.RemoveAll(root)
+	defer os.RemoveAll(root)
+	dirs := []string{filepath.Join(root, "0"), filepath.Join(root, "1")}
+
+	vol, closer := fstest.OpenVolume(dirs...)
+	fstest.WriteFile(vol, "a", []byte("hello a"))
+	closer()
+
+	// a lone member is refused
+	_, err := testRunFSBrowser(&FSBrowser{Dir: dirs[1], Exec: "ls"})
+	test.Equal(err, bio.ErrStripedMemberMissing)
+
+	out, err := testRunFSBrowser(&FSBrowser{
+		Dir:  strings.Join(dirs, ","),
+		Exec: "cat a",
+		JSON: true,
+	})
+	test.Nil(err)
+	var read readView
+	test.Nil(json.Unmarshal([]byte(out), &read))
+	test.Equal(string(read.Data), "hello a")
+}
+
+func TestFSBrowserScript(t *testing.T) {
+	defer test.New(t)
+	dir := test.Root()
+	testNewVolume(dir)
+	defer os.RemoveAll(dir)
+
+	script := filepath.Join(os.TempDir(), "madq-fsbrowser-script")
+	test.Nil(ioutil.WriteFile(script, []byte(`
+# write then read back
+write c world
+stat c
+inode c 0; imap 1
+log --limit 1
+`), 0644))
+	defer os.Remove(script)
+
+	out, err := testRunFSBrowser(&FSBrowser{Dir: dir, Script: script})
+	test.Nil(err)
+	for _, s := range []string{
+		"name: c",
+		"size: 5 ",
+		"\noffsets ",
+		"\nslot 1 ",
+		"ino: 0 (/)",
+		"1 batches",
+	} {
+		test.True(strings.Contains(out, s))
+	}
+}
+
+func TestFSBrowserBrokenVolume(t *testing.T) {
+	defer test.New(t)
+	dir := test.Root()
+	testNewVolume(dir)
+	defer os.RemoveAll(dir)
+
+	{ // wipe the header
+		fd, err := bio.NewFile(dir)
+		test.Nil(err)
+		test.WriteAt(fd, make([]byte, fs.VolumeHeaderSize), 0)
+		fd.Close()
+	}
+
+	_, err := testRunFSBrowser(&FSBrowser{Dir: dir, Exec: "ls"})
+	test.NotNil(err)
+
+	out, err := testRunFSBrowser(&FSBrowser{Dir: dir, Exec: "log", JSON: true})
+	test.Nil(err)
+	var batches []*logBatchView
+	test.Nil(json.Unmarshal([]byte(out), &batches))
+	test.True(len(batches) >= 2)
+	test.Equal(batches[0].Addr, fs.Address(fs.VolumeHeaderMinCheckpoint))
+}
@@ -22,10 +22,13 @@ type Cobuffer struct {
 	writeChanSent int32
 	writeTime     time.Time
 	waiter        sync.WaitGroup
+
+	stat *GStat
 }
 
-func NewCobuffer(n int, maxSize int) *Cobuffer {
+func NewCobuffer(n int, maxSize int, stat *GStat) *Cobuffer {
 	return &Cobuffer{
+		stat:      stat,
 		buffer:    make([]byte, n),
 		maxSize:   maxSize,
 		flushChan: make(chan struct{}, 1),
@@ -51,7 +54,7 @@ func (c *Cobuffer) grow() bool {
 	c.buffer = append(c.buffer, 0)
 	c.buffer = c.buffer[:cap(c.buffer)]
 	c.rw.Unlock()
-	Stat.Cobuffer.Grow.AddNow(now)
+	c.stat.Cobuffer.Grow.AddNow(now)
 	return true
 }
 
@@ -78,7 +81,7 @@ func (c *Cobuffer) IsFlush() <-chan struct{} {
 func (c *Cobuffer) GetData(buffer []byte) int {
 	now := time.Now()
 	c.rw.Lock()
-	Stat.Cobuffer.GetData.Lock.AddNow(now)
+	c.stat.Cobuffer.GetData.Lock.AddNow(now)
 	n := int(c.offset)
 	if len(buffer) < n {
 		c.rw.Unlock()
@@ -88,10 +91,11 @@ func (c *Cobuffer) GetData(buffer []byte) int {
 	now = time.Now()
 	copy(buffer[:n], c.buffer)
 	c.offset = 0
-	Stat.Cobuffer.GetData.Copy.AddNow(now)
-	Stat.Cobuffer.GetData.Size.Add(int64(n))
+	c.stat.Cobuffer.GetData.Copy.AddNow(now)
+	c.stat.Cobuffer.GetData.Size.Add(int64(n))
+	atomic.AddInt64(&c.stat.Cobuffer.Buffered, -int64(n))
 
-	Stat.Cobuffer.FlushDelay.AddNow(c.wantFlushTime)
+	c.stat.Cobuffer.FlushDelay.AddNow(c.wantFlushTime)
 	c.wantFlushTime = time.Now()
 
 	atomic.StoreInt32(&c.writeChanSent, 0)
@@ -106,7 +110,7 @@ func (c *Cobuffer) WriteData(b []byte) {
 	tryTime := 0
 	for {
 		if c.writeData(b) {
-			Stat.Cobuffer.Trytime.HitN(tryTime)
+			c.stat.Cobuffer.Trytime.HitN(tryTime)
 			return
 		}
 		tryTime++
@@ -134,6 +138,7 @@ func (c *Cobuffer) writeData(b []byte) bool {
 	}
 
 	copy(c.buffer[newOff-int32(len(b)):newOff], b)
+	atomic.AddInt64(&c.stat.Cobuffer.Buffered, int64(len(b)))
 
 	if atomic.CompareAndSwapInt32(&c.writeChanSent, 0, 1) {
 		c.writeTime = time.Now()
@@ -146,16 +151,16 @@ func (c *Cobuffer) writeData(b []byte) bool {
 	if int(newOff) > c.maxSize/2 {
 		if !c.isWantFlush() {
 			// println("cobuffer: need flush")
-			Stat.Cobuffer.NotifyFlushByWrite.Hit()
-			Stat.Cobuffer.FullTime.AddNow(c.writeTime)
+			c.stat.Cobuffer.NotifyFlushByWrite.Hit()
+			c.stat.Cobuffer.FullTime.AddNow(c.writeTime)
 			c.Flush()
 		}
 	} else {
-		Stat.Cobuffer.NotifyFlushByWrite.Miss()
+		c.stat.Cobuffer.NotifyFlushByWrite.Miss()
 	}
 
 	c.rw.RUnlock()
-	Stat.Cobuffer.WriteTime.AddNow(now)
+	c.stat.Cobuffer.WriteTime.AddNow(now)
 	return true
 }
 
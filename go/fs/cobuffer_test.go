@@ -11,7 +11,7 @@ import (
 func BenchmarkCobuffer(b *testing.B) {
 	defer test.New(b)
 
-	buf := NewCobuffer(16<<20, 16<<20)
+	buf := NewCobuffer(16<<20, 16<<20, NewStat())
 	data := test.SeqBytes(200)
 
 	n := int64(b.N)
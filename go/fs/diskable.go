@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 
+	"github.com/allmad/madq/go/common"
 	"github.com/chzyer/logex"
 )
 
@@ -29,8 +30,10 @@ type DiskReadItem interface {
 	ReadDisk([]byte) error
 }
 
+// the buffers are pooled, d must not hold them after ReadDisk/WriteDisk
 func ReadDisk(r io.ReaderAt, d Diskable, addr Address) error {
-	buf := make([]byte, d.DiskSize())
+	buf := common.GetBuffer(d.DiskSize())
+	defer common.PutBuffer(buf)
 	_, err := r.ReadAt(buf, int64(addr))
 	if err != nil {
 		return logex.Trace(err)
@@ -38,9 +41,19 @@ func ReadDisk(r io.ReaderAt, d Diskable, addr Address) error {
 	return logex.Trace(d.ReadDisk(buf))
 }
 
-func WriteDisk(w io.Writer, d Diskable) error {
-	buf := make([]byte, d.DiskSize())
+// a zeroed buffer for d to write, the padding is skipped by d
+func getDiskBuffer(d Diskable) []byte {
+	buf := common.GetBuffer(d.DiskSize())
+	for idx := range buf {
+		buf[idx] = 0
+	}
 	d.WriteDisk(buf)
+	return buf
+}
+
+func WriteDisk(w io.Writer, d Diskable) error {
+	buf := getDiskBuffer(d)
+	defer common.PutBuffer(buf)
 	_, err := w.Write(buf)
 	if err != nil {
 		return logex.Trace(err)
@@ -49,8 +62,8 @@ func WriteDisk(w io.Writer, d Diskable) error {
 }
 
 func WriteDiskAt(w io.WriterAt, d Diskable, addr Address) error {
-	buf := make([]byte, d.DiskSize())
-	d.WriteDisk(buf)
+	buf := getDiskBuffer(d)
+	defer common.PutBuffer(buf)
 	_, err := w.WriteAt(buf, int64(addr))
 	if err != nil {
 		return logex.Trace(err)
@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/allmad/madq/go/bio"
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
 )
@@ -23,6 +24,7 @@ type File struct {
 	inodePool *InodePool
 	flusher   FileFlusher
 	cobuf     *Cobuffer
+	stat      *GStat
 
 	flushWaiter sync.WaitGroup
 	flushChan   chan struct{}
@@ -30,7 +32,8 @@ type File struct {
 
 type FileDelegater interface {
 	InodePoolDelegate
-	ReadData(offset ShortAddr, n int) ([]byte, error)
+	ReadRef(r *bio.Ref, offset ShortAddr, n int) error
+	Prefetch(offset ShortAddr, n int)
 }
 
 type FileFlusher interface {
@@ -40,20 +43,41 @@ type FileFlusher interface {
 
 type FileConfig struct {
 	Ino           int32
+	Geometry      *Geometry
 	Name          string
 	Flags         int
 	Delegate      FileDelegater
 	FlushInterval time.Duration
 	Flusher       FileFlusher
 	FlushSize     int
+	ReadAhead     int
+	IndexCache    int
+	Stat          *GStat
 }
 
 func IsFileCreate(flags int) bool {
 	return flags&os.O_CREATE > 0
 }
 
+func (cfg *FileConfig) init() {
+	if cfg.Geometry == nil {
+		cfg.Geometry = DefaultGeometry()
+	}
+	if cfg.Stat == nil {
+		cfg.Stat = NewStat()
+	}
+	if cfg.ReadAhead == 0 {
+		cfg.ReadAhead = DefaultReadAhead
+	}
+	if cfg.IndexCache == 0 {
+		cfg.IndexCache = DefaultIndexCache
+	}
+}
+
 func NewFile(f *flow.Flow, cfg *FileConfig) (*File, error) {
-	inodePool := NewInodePool(cfg.Ino, cfg.Delegate)
+	cfg.init()
+	inodePool := NewInodePool(cfg.Geometry, cfg.Ino, cfg.Delegate, cfg.Stat)
+	inodePool.SetIndexCache(cfg.IndexCache)
 
 	if _, err := inodePool.GetLastest(); err != nil {
 		if IsFileCreate(cfg.Flags) {
@@ -72,7 +96,8 @@ func NewFile(f *flow.Flow, cfg *FileConfig) (*File, error) {
 		delegate:  cfg.Delegate,
 		inodePool: inodePool,
 		flusher:   cfg.Flusher,
-		cobuf:     NewCobuffer(1<<10, cfg.FlushSize),
+		cobuf:     NewCobuffer(1<<10, cfg.FlushSize, cfg.Stat),
+		stat:      cfg.Stat,
 
 		flushChan: make(chan struct{}, 1),
 	}
@@ -137,22 +162,22 @@ func (f *File) writeLoop() {
 		}
 		timer = nil
 
-		Stat.File.Loop.BufferDuration.AddNow(flushStart)
+		f.stat.File.Loop.BufferDuration.AddNow(flushStart)
 		n := f.cobuf.GetData(buffer)
 		for n > len(buffer) {
-			Stat.File.RegenBuffer.Hit()
+			f.stat.File.RegenBuffer.Hit()
 			buffer = make([]byte, n)
 			n = f.cobuf.GetData(buffer)
 		}
 
 		if n > 0 {
-			Stat.File.FlushSize.AddBuf(buffer[:n])
+			f.stat.File.FlushSize.AddBuf(buffer[:n])
 			f.flusher.WriteByInode(f.inodePool, buffer[:n], flushReply)
 			bufferOps++
 		}
 		if wantFlush {
 			now := time.Now()
-			Stat.File.Flush.WaitSize.HitN(bufferOps)
+			f.stat.File.Flush.WaitSize.HitN(bufferOps)
 			if bufferOps > 0 {
 				// println("file: flush them")
 				f.flusher.Flush(false)
@@ -165,7 +190,7 @@ func (f *File) writeLoop() {
 					logex.Error("write error:", reply.Err)
 				}
 			}
-			Stat.File.Flush.WaitReply.AddNow(now)
+			f.stat.File.Flush.WaitReply.AddNow(now)
 			f.flushWaiter.Done()
 			wantFlush = false
 		}
@@ -197,58 +222,69 @@ func (f *File) Size() int64 {
 	if err != nil {
 		panic(err)
 	}
-	return int64(ino.Start)*BlockSize + int64(ino.Size)
+	return ino.StartOffset() + int64(ino.Size)
+}
+
+func (f *File) ReadAt(b []byte, off int64) (int, error) {
+	r := bio.NewRef()
+	err := f.readRef(r, off, len(b))
+	n := r.CopyTo(b)
+	r.Release()
+	return n, err
+}
+
+// ReadRef returns the views of [off, off+n) in cache without copying, it
+// must be released after used.
+func (f *File) ReadRef(off int64, n int) (*bio.Ref, error) {
+	r := bio.NewRef()
+	if err := f.readRef(r, off, n); err != nil {
+		r.Release()
+		return nil, err
+	}
+	return r, nil
 }
 
-func (f *File) ReadAt(b []byte, off int64) (readBytes int, err error) {
+// append [off, off+n) to r block by block, the data before the error is
+// kept in r.
+func (f *File) readRef(r *bio.Ref, off int64, n int) error {
 	inode, err := f.inodePool.SeekPrev(off)
 	if err != nil {
-		return 0, err
+		return err
 	}
 
-	var nextInode *Inode
-
-getOffset:
-	idx, ok := inode.SeekIdx(off)
-	if !ok {
-		nextInode, err = f.inodePool.SeekNext(inode)
-		if err != nil {
-			return
+	for r.Len() < n {
+		idx, ok := inode.SeekIdx(off)
+		if !ok {
+			nextInode, err := f.inodePool.SeekNext(inode)
+			if err != nil {
+				return err
+			}
+			if inode == nextInode {
+				panic("SeekNext() is not working")
+			}
+			inode = nextInode
+			continue
 		}
-		if inode == nextInode {
-			panic("SeekNext() is not working")
+		if off >= inode.StartOffset()+int64(inode.Size) {
+			return io.EOF
 		}
-		inode = nextInode
-		goto getOffset
-	}
 
-	remainBytes := inode.GetRemainInBlock(off)
-	if remainBytes < len(b)-readBytes {
-		remainBytes = len(b) - readBytes
-	} else {
-		remainBytes = len(b)
-	}
-	readAddr := inode.Offsets[idx] + ShortAddr(off&(BlockSize-1))
-
-	readTime := time.Now()
-	data, err := f.delegate.ReadData(readAddr, remainBytes)
-	Stat.File.DiskRead.AddNow(readTime)
-	if err != nil {
-		return
-	}
-
-	if len(data) != remainBytes {
-		err = io.EOF
-		return
-	}
+		size := inode.GetRemainInBlock(off)
+		if size > n-r.Len() {
+			size = n - r.Len()
+		}
+		readAddr := inode.Offsets[idx] + ShortAddr(f.cfg.Geometry.OffsetInBlock(off))
 
-	readBytes += copy(b[readBytes:], data)
-	if readBytes == len(b) {
-		return
+		readTime := time.Now()
+		err := f.delegate.ReadRef(r, readAddr, size)
+		f.stat.File.DiskRead.AddNow(readTime)
+		if err != nil {
+			return err
+		}
+		f.stat.Throughput.ReadBytes.MarkInt(size)
+		off += int64(size)
 	}
-	off += int64(readBytes)
-
-	goto getOffset
+	return nil
 }
 
 func (f *File) Write(b []byte) (int, error) {
@@ -256,6 +292,7 @@ func (f *File) Write(b []byte) (int, error) {
 		return 0, fmt.Errorf("closed")
 	}
 	f.cobuf.WriteData(b)
+	f.stat.Throughput.Records.Mark(1)
 	return len(b), nil
 }
 
@@ -304,6 +341,62 @@ func (f *File) Close() error {
 
 	f.flow.Close()
 	f.cobuf.Close()
-	Stat.File.CloseTime.AddNow(now)
+	f.stat.File.CloseTime.AddNow(now)
 	return nil
 }
+
+// the data of the blocks in the file on disk
+type blockRange struct {
+	addr ShortAddr
+	size int
+}
+
+// find the data of cnt blocks from the block at off, which are on disk.
+// the adjacent ones are merged. full is the blocks found which are full.
+func (f *File) blockRanges(off int64, cnt int) (ret []blockRange, full int) {
+	inode, err := f.inodePool.SeekPrev(off)
+	if err != nil {
+		return nil, 0
+	}
+	geo := f.cfg.Geometry
+	idx := int((off - inode.StartOffset()) >> geo.BlockBit)
+	for i := 0; i < cnt; i++ {
+		if idx == len(inode.Offsets) {
+			inode, err = f.inodePool.SeekNext(inode)
+			if err != nil {
+				break
+			}
+			idx = 0
+		}
+		if idx > inode.GetSizeIdx() {
+			break
+		}
+		size := inode.GetBlockSize(idx)
+		addr := inode.Offsets[idx]
+		if size == 0 || addr == 0 {
+			break
+		}
+		if n := len(ret); n > 0 && ret[n-1].addr+ShortAddr(ret[n-1].size) == addr {
+			ret[n-1].size += size
+		} else {
+			ret = append(ret, blockRange{addr, size})
+		}
+		if size < geo.BlockSize {
+			break
+		}
+		full++
+		idx++
+	}
+	return ret, full
+}
+
+func (f *File) prefetch(blocks []blockRange, cancel chan struct{}) {
+	for _, b := range blocks {
+		select {
+		case <-cancel:
+			return
+		default:
+		}
+		f.delegate.Prefetch(b.addr, b.size)
+	}
+}
@@ -1,9 +1,11 @@
 package fs
 
 import (
+	"os"
 	"testing"
 
 	"github.com/allmad/madq/go/bio"
+	"github.com/chzyer/flow"
 	"github.com/chzyer/test"
 )
 
@@ -47,3 +49,66 @@ func benchFile(b *testing.B, size int, fd bio.ReadWriterAt) {
 	}
 	f.Sync()
 }
+
+func BenchmarkFileReadAt200b(b *testing.B) {
+	benchFileRead(b, 200, func(fd *Handle, buf []byte, off int64) {
+		if _, err := fd.ReadAt(buf, off); err != nil {
+			b.Fatal(err)
+		}
+	})
+}
+
+func BenchmarkFileReadRef200b(b *testing.B) {
+	benchFileRead(b, 200, func(fd *Handle, buf []byte, off int64) {
+		r, err := fd.ReadRef(off, len(buf))
+		if err != nil {
+			b.Fatal(err)
+		}
+		r.Release()
+	})
+}
+
+// read the messages in sequence from a file of 8MB
+func benchFileRead(b *testing.B, size int, read func(*Handle, []byte, int64)) {
+	defer test.New(b)
+	vol, err := NewVolume(flow.New(), &VolumeConfig{
+		Delegate: bio.NewHybrid(test.NewMemDisk(), DefaultBlockBit),
+	})
+	test.Nil(err)
+	defer vol.Close()
+	fd, err := vol.Open("bench", os.O_CREATE)
+	test.Nil(err)
+	defer fd.Close()
+	data := test.RandBytes(8 << 20)
+	test.Write(fd, data)
+	fd.Sync()
+
+	buf := make([]byte, size)
+	b.ReportAllocs()
+	b.SetBytes(int64(size))
+	b.ResetTimer()
+	off := int64(0)
+	for i := 0; i < b.N; i++ {
+		if off+int64(size) > int64(len(data)) {
+			off = 0
+		}
+		read(fd, buf, off)
+		off += int64(size)
+	}
+}
+
+func BenchmarkReadDiskInode(b *testing.B) {
+	md := test.NewMemDisk()
+	ino := NewInode(DefaultGeometry(), 1)
+	ino.Size = 100
+	if err := WriteDiskAt(md, ino, 0); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := ReadDisk(md, ino, 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -17,13 +17,18 @@ type testFileDelegate struct {
 	md      bio.ReadWriterAt
 }
 
-func (t *testFileDelegate) ReadData(addr ShortAddr, n int) ([]byte, error) {
+func (t *testFileDelegate) ReadRef(r *bio.Ref, addr ShortAddr, n int) error {
 	buf := make([]byte, n)
 	_, err := t.md.ReadAt(buf, int64(addr))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return buf, nil
+	r.Append(buf, nil)
+	return nil
+}
+
+func (t *testFileDelegate) Prefetch(addr ShortAddr, n int) {
+
 }
 
 func (t *testFileDelegate) SaveInode(ino *Inode) {
@@ -40,13 +45,21 @@ func (t *testFileDelegate) GetInode(ino int32) (*Inode, error) {
 }
 
 func (t *testFileDelegate) GetInodeByAddr(addr Address) (*Inode, error) {
-	ino := NewInode(t.ino)
+	ino := NewInode(DefaultGeometry(), t.ino)
 	if err := ReadDisk(t.md, ino, addr); err != nil {
 		return nil, err
 	}
 	return ino, nil
 }
 
+func (t *testFileDelegate) GetIndexNode(addr Address) (*InodeIndexNode, error) {
+	node := new(InodeIndexNode)
+	if err := ReadDisk(t.md, node, addr); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
 func testNewFile(md bio.ReadWriterAt) *File {
 	delegate := &testFileDelegate{md: md}
 	flusherDelegate := &testFlusherDelegate{md}
@@ -77,7 +90,7 @@ func TestFileWrite(t *testing.T) {
 	defer f.Close()
 
 	out := 5
-	testSize := BlockSize + out
+	testSize := DefaultBlockSize + out
 	buf := test.SeqBytes(testSize)
 	testTime := 3
 	for i := 0; i < testTime; i++ {
@@ -87,18 +100,18 @@ func TestFileWrite(t *testing.T) {
 	}
 
 	got := make([]byte, testSize)
-	inodeBuf := make([]byte, InodeSize)
+	inodeBuf := make([]byte, DefaultGeometry().InodeSize)
 	md.SeekRead(1, 0) // 1: offset
 
 	// 1
 	test.Read(md, got)
 	test.EqualBytes(got, buf)
-	ino := NewInode(0)
+	ino := NewInode(DefaultGeometry(), 0)
 	test.Read(md, inodeBuf)
 	test.Nil(ino.ReadDisk(inodeBuf))
 	test.ReadAndCheck(md, MagicEOF)
 	test.True(ino.Offsets[0] == 1)
-	test.True(ino.Offsets[1] == BlockSize+1)
+	test.True(ino.Offsets[1] == DefaultBlockSize+1)
 
 	// 2
 	margin := out
@@ -112,7 +125,7 @@ func TestFileWrite(t *testing.T) {
 		test.Nil(ino.ReadDisk(inodeBuf))
 		test.True(ino.Offsets[0] == 1)
 		test.True(ino.Offsets[1] == ShortAddr(off2))
-		test.True(ino.Offsets[2] == ShortAddr(off2+BlockSize))
+		test.True(ino.Offsets[2] == ShortAddr(off2+DefaultBlockSize))
 		test.ReadAndCheck(md, MagicEOF)
 	}
 
@@ -129,18 +142,18 @@ func TestFileWrite(t *testing.T) {
 		test.True(ino.Offsets[0] == 1)
 		test.True(ino.Offsets[1] == ShortAddr(off2))
 		test.True(ino.Offsets[2] == ShortAddr(off3))
-		test.True(ino.Offsets[3] == ShortAddr(off3+BlockSize))
+		test.True(ino.Offsets[3] == ShortAddr(off3+DefaultBlockSize))
 	}
 }
 
 func TestFileRead(t *testing.T) {
 	defer test.New(t)
 
-	f := testNewFile(bio.NewHybrid(test.NewMemDisk(), BlockBit))
+	f := testNewFile(bio.NewHybrid(test.NewMemDisk(), DefaultBlockBit))
 	defer f.Close()
 
 	out := 5
-	testSize := BlockSize + out
+	testSize := DefaultBlockSize + out
 	buf := test.SeqBytes(testSize)
 	testTime := 10
 	for i := 0; i < testTime; i++ {
@@ -18,6 +18,8 @@ type FlushDelegate interface {
 
 type Flusher struct {
 	flow     *flow.Flow
+	geo      *Geometry
+	stat     *GStat
 	interval time.Duration
 	offset   int64 // point to the start of partial
 	delegate FlushDelegate
@@ -32,10 +34,24 @@ type FlusherConfig struct {
 	Interval time.Duration
 	Delegate FlushDelegate
 	Offset   int64
+	Geometry *Geometry
+	Stat     *GStat
+}
+
+func (cfg *FlusherConfig) init() {
+	if cfg.Geometry == nil {
+		cfg.Geometry = DefaultGeometry()
+	}
+	if cfg.Stat == nil {
+		cfg.Stat = NewStat()
+	}
 }
 
 func NewFlusher(f *flow.Flow, cfg *FlusherConfig) *Flusher {
+	cfg.init()
 	flusher := &Flusher{
+		geo:       cfg.Geometry,
+		stat:      cfg.Stat,
 		interval:  cfg.Interval,
 		opChan:    make(chan *flusherWriteOp, 100),
 		flushChan: make(chan struct{}, 1),
@@ -62,14 +78,14 @@ func (f *Flusher) handleOpInPartialArea(dw *DiskWriter, op *flushItem) error {
 		// ino.Offsets[idx] can't in memory
 		now := time.Now()
 		oldData, err := f.delegate.ReadData(int64(ino.Offsets[idx]), blkSize)
-		Stat.Flusher.ReadTime.AddNow(now)
+		f.stat.Flusher.ReadTime.AddNow(now)
 		if err != nil {
 			return logex.Tracefmt(
 				"error in readdata at %v(%v): %v",
 				ino.Offsets[idx], blkSize, err)
 		}
 		dw.WriteBytes(oldData)
-		Stat.Flusher.BlockCopy.AddInt(len(oldData))
+		f.stat.Flusher.BlockCopy.AddInt(len(oldData))
 	}
 	length := op.data.Len()
 	op.data.WriteData(dw, -1)
@@ -111,30 +127,30 @@ writePayload:
 
 	dataAddr = ShortAddr(f.getAddr(dw.Written()))
 	blkSize := ino.GetBlockSize(idx)
-	if op.data.Len() < BlockSize-blkSize {
+	if op.data.Len() < f.geo.BlockSize-blkSize {
 		goto exit
 	}
 
 	if blkSize > 0 {
 		now := time.Now()
 		oldData, err := f.delegate.ReadData(int64(ino.Offsets[idx]), blkSize)
-		Stat.Flusher.ReadTime.AddNow(now)
+		f.stat.Flusher.ReadTime.AddNow(now)
 
 		if err != nil {
 			return logex.Tracefmt(
 				"error in readdata at %v: %v", ino.Offsets[idx], err)
 		}
 		dw.WriteBytes(oldData)
-		Stat.Flusher.BlockCopy.AddInt(len(oldData))
+		f.stat.Flusher.BlockCopy.AddInt(len(oldData))
 	}
 
 	{
 		now := time.Now()
-		op.data.WriteData(dw, BlockSize-blkSize)
-		Stat.Flusher.HandleOp.DataAreaCopy.AddNow(now)
+		op.data.WriteData(dw, f.geo.BlockSize-blkSize)
+		f.stat.Flusher.HandleOp.DataAreaCopy.AddNow(now)
 	}
 
-	ino.SetOffset(idx, dataAddr, BlockSize-blkSize)
+	ino.SetOffset(idx, dataAddr, f.geo.BlockSize-blkSize)
 	if len(inos) == 0 || inos[len(inos)-1] != ino {
 		inos = append(inos, ino)
 	}
@@ -165,7 +181,7 @@ func (f *Flusher) handleOps(data []byte, ops []*flushItem) int64 {
 			continue
 		}
 	}
-	Stat.Flusher.HandleOp.DataArea.AddNow(n1)
+	f.stat.Flusher.HandleOp.DataArea.AddNow(n1)
 
 	n1 = time.Now()
 	// in partial area
@@ -184,7 +200,7 @@ func (f *Flusher) handleOps(data []byte, ops []*flushItem) int64 {
 			continue
 		}
 	}
-	Stat.Flusher.HandleOp.Partial.AddNow(n1)
+	f.stat.Flusher.HandleOp.Partial.AddNow(n1)
 
 	n1 = time.Now()
 	// write inode
@@ -193,18 +209,26 @@ func (f *Flusher) handleOps(data []byte, ops []*flushItem) int64 {
 			continue
 		}
 		for _, ino := range op.tmpInodes {
+			if err := op.inoPool.BuildIndex(ino, func(node *InodeIndexNode) Address {
+				addr := f.getAddr(dw.Written())
+				dw.WriteItem(node)
+				return addr
+			}); err != nil {
+				// still seekable by PrevInode
+				logex.Error("error in build index:", err)
+			}
 			ino.Mtime.Set(time.Now())
 			inoAddr := f.getAddr(dw.Written())
 			dw.WriteItem(ino)
 			op.inoPool.OnFlush(ino, inoAddr)
 		}
 	}
-	Stat.Flusher.HandleOp.Inode.AddNow(n1)
+	f.stat.Flusher.HandleOp.Inode.AddNow(n1)
 
 	// send reply to ops in flush()
 
 	dw.WriteBytes(MagicEOF)
-	Stat.Flusher.HandleOp.Total.AddNow(now)
+	f.stat.Flusher.HandleOp.Total.AddNow(now)
 	return dw.Written()
 }
 
@@ -212,7 +236,7 @@ func (f *Flusher) flush(fb *flushBuffer) {
 	if len(fb.ops()) == 0 {
 		return
 	}
-	Stat.Flusher.Flush.Count.Add(1)
+	f.stat.Flusher.Flush.Count.Add(1)
 
 	var err error
 	start := time.Now()
@@ -227,7 +251,7 @@ func (f *Flusher) flush(fb *flushBuffer) {
 			now := time.Now()
 			// println("flusher: flush", len(buffer), "ops:", fb.ops()[0].opCnt)
 			_, err = f.delegate.WriteAt(buffer, f.offset)
-			Stat.Flusher.Flush.RawWrite.AddNow(now)
+			f.stat.Flusher.Flush.RawWrite.AddNow(now)
 		}
 
 		if err != nil {
@@ -248,8 +272,9 @@ func (f *Flusher) flush(fb *flushBuffer) {
 		}
 		break
 	}
+	f.stat.Throughput.FlushBytes.MarkInt(len(buffer))
 
-	Stat.Flusher.Flush.Size.AddInt(len(buffer))
+	f.stat.Flusher.Flush.Size.AddInt(len(buffer))
 	f.offset += int64(len(buffer))
 	f.delegate.UpdateCheckpoint(f.offset)
 
@@ -261,7 +286,7 @@ func (f *Flusher) flush(fb *flushBuffer) {
 	}
 
 	fb.reset()
-	Stat.Flusher.Flush.Total.AddNow(start)
+	f.stat.Flusher.Flush.Total.AddNow(start)
 }
 
 func (f *Flusher) loop() {
@@ -271,7 +296,7 @@ func (f *Flusher) loop() {
 		fb    flushBuffer
 		timer <-chan time.Time
 	)
-	fb.init()
+	fb.init(f.geo, f.stat)
 	wantFlush := false
 	wantClose := false
 	_ = timer
@@ -308,8 +333,8 @@ func (f *Flusher) loop() {
 				break
 			}
 
-			Stat.Flusher.Buffering.Size.AddInt(fb.bufferingSize)
-			Stat.Flusher.FlushBuffer.AddNow(now)
+			f.stat.Flusher.Buffering.Size.AddInt(fb.bufferingSize)
+			f.stat.Flusher.FlushBuffer.AddNow(now)
 
 		case <-f.flow.IsClose():
 			wantClose = true
@@ -353,14 +378,14 @@ func (f *Flusher) Close() {
 	// println("flusher closed")
 
 	var fb flushBuffer
-	fb.init()
+	fb.init(f.geo, f.stat)
 	for op := range f.opChan {
 		if !fb.addOp(op) {
 			f.flush(&fb)
 			fb.reset()
 		}
 	}
-	Stat.Flusher.CloseTime.AddNow(now)
+	f.stat.Flusher.CloseTime.AddNow(now)
 }
 
 type flushItem struct {
@@ -376,12 +401,16 @@ func (f *flushItem) sendDone(err error) {
 }
 
 type flushBuffer struct {
+	geo           *Geometry
+	stat          *GStat
 	bufferingOps  []*flushItem
 	bufferingSize int
 	buffer        []byte
 }
 
-func (f *flushBuffer) init() {
+func (f *flushBuffer) init(geo *Geometry, stat *GStat) {
+	f.geo = geo
+	f.stat = stat
 	f.buffer = make([]byte, 4<<20)
 }
 
@@ -419,13 +448,13 @@ func (f *flushBuffer) addOp(op *flusherWriteOp) bool {
 
 	// calculate the copy of partial data
 	f.bufferingSize += len(op.data) +
-		CalNeedInodeCnt(ino, len(op.data))*InodeSize +
-		int(ino.Size)&(BlockSize-1)
+		f.geo.CalNeedInodeCnt(ino, len(op.data))*(f.geo.InodeSize+InodeIndexReserve) +
+		int(ino.Size)&(f.geo.BlockSize-1)
 
 	if f.bufferingSize >= 20<<20 {
 		return false
 	}
-	Stat.Flusher.FlushBufferAddOp.AddNow(now)
+	f.stat.Flusher.FlushBufferAddOp.AddNow(now)
 	return true
 }
 
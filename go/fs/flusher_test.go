@@ -45,7 +45,7 @@ func (t *testInodePoolMemDiskDelegate) GetInode(ino int32) (*Inode, error) {
 }
 
 func (t *testInodePoolMemDiskDelegate) GetInodeByAddr(addr Address) (*Inode, error) {
-	ino := NewInode(0)
+	ino := NewInode(DefaultGeometry(), 0)
 	buf := make([]byte, ino.DiskSize())
 	_, err := t.md.ReadAt(buf, int64(addr))
 	if err != nil {
@@ -57,6 +57,14 @@ func (t *testInodePoolMemDiskDelegate) GetInodeByAddr(addr Address) (*Inode, err
 	return ino, nil
 }
 
+func (t *testInodePoolMemDiskDelegate) GetIndexNode(addr Address) (*InodeIndexNode, error) {
+	node := new(InodeIndexNode)
+	if err := ReadDisk(t.md, node, addr); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
 func TestFlusherBigRW(t *testing.T) {
 	defer test.New(t)
 
@@ -68,10 +76,10 @@ func TestFlusherBigRW(t *testing.T) {
 		Offset:   1,
 	})
 
-	ipool0 := NewInodePool(0, &testInodePoolDelegate{})
+	ipool0 := NewInodePool(DefaultGeometry(), 0, &testInodePoolDelegate{}, NewStat())
 	ipool0.InitInode()
 	done := make(chan *FlusherWriteReply, 1)
-	expect := test.SeqBytes(BlockSize + 5)
+	expect := test.SeqBytes(DefaultBlockSize + 5)
 
 	testTime := 10
 	for i := 0; i < testTime; i++ {
@@ -95,7 +103,7 @@ func BenchmarkFlusher(b *testing.B) {
 		Delegate: flusherDelegate,
 		Offset:   0,
 	})
-	ipool := NewInodePool(0, &testInodePoolDelegate{})
+	ipool := NewInodePool(DefaultGeometry(), 0, &testInodePoolDelegate{}, NewStat())
 	ipool.InitInode()
 	done := make(chan *FlusherWriteReply, 1)
 	go func() {
@@ -122,7 +130,7 @@ func TestFlusher(t *testing.T) {
 		Offset:   1,
 	})
 	{
-		ipool0 := NewInodePool(0, &testInodePoolDelegate{})
+		ipool0 := NewInodePool(DefaultGeometry(), 0, &testInodePoolDelegate{}, NewStat())
 		ipool0.InitInode()
 		done := make(chan *FlusherWriteReply, 1)
 		flusher.WriteByInode(ipool0, []byte("hello"), done)
@@ -135,7 +143,7 @@ func TestFlusher(t *testing.T) {
 			lastestAddr: 5 + 1,
 			md:          flusherDelegate.ReadWriterAt,
 		}
-		ipool0 := NewInodePool(0, delegate)
+		ipool0 := NewInodePool(DefaultGeometry(), 0, delegate, NewStat())
 		inode, err := ipool0.GetLastest()
 		test.Nil(err)
 		test.Equal(inode.Size, Int32(5))
@@ -146,7 +154,7 @@ func TestFlusher(t *testing.T) {
 		<-done
 		test.Equal(inode.Size, Int32((256<<10)+10+5))
 
-		block1 := make([]byte, BlockSize)
+		block1 := make([]byte, DefaultBlockSize)
 		copy(block1, []byte("hello"))
 		n := copy(block1[5:], tmpdata)
 		block2 := make([]byte, len(tmpdata)-n)
@@ -0,0 +1,49 @@
+// Package fstest builds the volumes used by the tests of the tools on top
+// of go/fs.
+package fstest
+
+import (
+	"os"
+
+	"github.com/allmad/madq/go/fs"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+// OpenVolume opens the volume in dirs, it's created if not exists.
+// the returned func closes both of the volume and its source.
+func OpenVolume(dirs ...string) (*fs.Volume, func()) {
+	vs, err := fs.OpenVolumeSource(dirs, nil)
+	test.Nil(err)
+	vol, err := fs.NewVolume(flow.New(), &fs.VolumeConfig{
+		Delegate: vs,
+	})
+	test.Nil(err)
+	return vol, func() {
+		vol.Close()
+		vs.Close()
+	}
+}
+
+// WriteFile appends data to the file name in vol.
+func WriteFile(vol *fs.Volume, name string, data []byte) {
+	fd, err := vol.Open(name, os.O_CREATE)
+	test.Nil(err)
+	test.Write(fd, data)
+	fd.Sync()
+	fd.Close()
+}
+
+// WriteFiles appends test.SeqBytes(size) to each of the files.
+func WriteFiles(vol *fs.Volume, files map[string]int) {
+	for name, size := range files {
+		WriteFile(vol, name, test.SeqBytes(size))
+	}
+}
+
+// WriteVolume writes files to the volume in dirs, and closes it.
+func WriteVolume(dirs []string, files map[string]int) {
+	vol, closer := OpenVolume(dirs...)
+	defer closer()
+	WriteFiles(vol, files)
+}
@@ -2,60 +2,119 @@ package fs
 
 import (
 	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
 
 	"github.com/allmad/madq/go/bio"
 	"github.com/allmad/madq/go/common"
+	"github.com/allmad/madq/go/ptrace"
+	"github.com/chzyer/logex"
 )
 
+var ErrInvalidGeometry = logex.Define("invalid geometry")
+
 const (
-	BlockBit  = 18
-	BlockSize = 1 << BlockBit
+	DefaultBlockBit      = 18
+	DefaultBlockSize     = 1 << DefaultBlockBit
+	DefaultInodeBlockCnt = 150
+
+	MinBlockBit = 12
+	MaxBlockBit = 22
 )
 
-func MakeRoom(b []byte, n int) []byte {
-	if n <= cap(b)-len(b) {
-		return b[:n]
+// Geometry describes how a volume lays out file data, it's decided when
+// the volume is created and persisted in VolumeHeader.
+type Geometry struct {
+	BlockBit      uint
+	BlockSize     int
+	InodeBlockCnt int
+
+	// data size which one inode can hold
+	InodeCap int
+	// disk size of one inode
+	InodeSize int
+}
+
+func NewGeometry(blockBit uint, inodeBlockCnt int) (*Geometry, error) {
+	if blockBit < MinBlockBit || blockBit > MaxBlockBit {
+		return nil, ErrInvalidGeometry.Trace("blockbit:", blockBit)
+	}
+	if inodeBlockCnt <= 0 || int64(inodeBlockCnt)<<blockBit > math.MaxInt32 {
+		return nil, ErrInvalidGeometry.Trace("inode block count:", inodeBlockCnt)
+	}
+	return &Geometry{
+		BlockBit:      blockBit,
+		BlockSize:     1 << blockBit,
+		InodeBlockCnt: inodeBlockCnt,
+		InodeCap:      inodeBlockCnt << blockBit,
+		InodeSize:     InodeHeaderSize + inodeBlockCnt*ShortAddr(0).DiskSize(),
+	}, nil
+}
+
+func DefaultGeometry() *Geometry {
+	g, err := NewGeometry(DefaultBlockBit, DefaultInodeBlockCnt)
+	if err != nil {
+		panic(err)
 	}
+	return g
+}
 
-	newBuf := make([]byte, n+1, 2*n)
-	copy(newBuf, b)
-	return newBuf
+func (g *Geometry) String() string {
+	return fmt.Sprintf("{block: %v, inode: %v blocks}",
+		ptrace.Unit(int64(g.BlockSize)), g.InodeBlockCnt)
+}
+
+// offset in the block which the global offset belongs to
+func (g *Geometry) OffsetInBlock(offset int64) int64 {
+	return offset & int64(g.BlockSize-1)
 }
 
-func GetInodeIdx(offset int64) int32 {
-	blkOff := offset >> BlockBit
-	return int32(blkOff / InodeBlockCnt)
+func (g *Geometry) GetInodeIdx(offset int64) int32 {
+	blkOff := offset >> g.BlockBit
+	return int32(blkOff / int64(g.InodeBlockCnt))
 }
 
-func GetBlockIdx(offset int64) int32 {
-	return int32(offset >> BlockBit)
+func (g *Geometry) GetBlockIdx(offset int64) int32 {
+	return int32(offset >> g.BlockBit)
 }
 
 // n: append data size
-func CalNeedInodeCnt(ino *Inode, n int) int {
-	if int(InodeCap-ino.Size) > n {
+func (g *Geometry) CalNeedInodeCnt(ino *Inode, n int) int {
+	if g.InodeCap-int(ino.Size) > n {
 		return 1
 	}
-	n -= int(InodeCap - ino.Size)
-	if n%InodeCap == 0 {
-		return (n / InodeCap) + 1
+	n -= g.InodeCap - int(ino.Size)
+	if n%g.InodeCap == 0 {
+		return (n / g.InodeCap) + 1
 	}
-	return (n / InodeCap) + 2
+	return (n / g.InodeCap) + 2
 }
 
-func GetBlockCnt(n int) int {
-	ret := n >> BlockBit
-	if n&(BlockSize-1) == 0 {
+func (g *Geometry) GetBlockCnt(n int) int {
+	ret := n >> g.BlockBit
+	if n&(g.BlockSize-1) == 0 {
 		return ret
 	}
 	return ret + 1
 }
 
-func FloorBlk(n int) int {
-	if n&(BlockSize-1) == 0 {
+func (g *Geometry) FloorBlk(n int) int {
+	if n&(g.BlockSize-1) == 0 {
 		return n
 	}
-	return ((n >> BlockBit) + 1) << BlockBit
+	return ((n >> g.BlockBit) + 1) << g.BlockBit
+}
+
+func MakeRoom(b []byte, n int) []byte {
+	if n <= cap(b)-len(b) {
+		return b[:n]
+	}
+
+	newBuf := make([]byte, n+1, 2*n)
+	copy(newBuf, b)
+	return newBuf
 }
 
 func initOffsetIdx() (ret [32]int) {
@@ -75,30 +134,202 @@ func initOffsetIdx() (ret [32]int) {
 // -----------------------------------------------------------------------------
 
 type VolumeSource struct {
-	flock *common.Flock
-	file  *bio.File
+	flocks []*common.Flock
+	file   VolumeFile
+	geo    *Geometry
 	*bio.Hybrid
 }
 
+// bio.File or bio.Striped
+type VolumeFile interface {
+	bio.ReadWriterAt
+	Stats() bio.FileStats
+	Close() error
+}
+
+var ErrVolumeSourceNoDir = logex.Define("volume directory is required")
+
+// SplitDirs splits the directories separated by comma.
+func SplitDirs(s string) []string {
+	var dirs []string
+	for _, dir := range strings.Split(s, ",") {
+		if dir = strings.TrimSpace(dir); dir != "" {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// open the file of a volume in dirs, it's striped over dirs if there are
+// more than one or the only one is a member of a striped volume.
+func openVolumeFile(dirs []string, cfg *bio.FileConfig, create bool) (VolumeFile, error) {
+	if len(dirs) == 1 && !bio.IsStriped(dirs[0]) {
+		return bio.NewFileWithConfig(dirs[0], cfg)
+	}
+	if !create {
+		return bio.OpenStriped(dirs, cfg)
+	}
+	for _, dir := range dirs {
+		if bio.IsStriped(dir) {
+			return bio.OpenStriped(dirs, cfg)
+		}
+	}
+	return bio.CreateStriped(&bio.StripedConfig{Dirs: dirs, File: *cfg})
+}
+
+// OpenVolumeFile opens the raw file of the volume in dirs without reading
+// its header, for the tools inspecting a broken volume. it's striped as in
+// OpenVolumeSource, and a lone member of a striped volume is refused.
+func OpenVolumeFile(dirs []string) (VolumeFile, error) {
+	if len(dirs) == 0 {
+		return nil, ErrVolumeSourceNoDir.Trace()
+	}
+	return openVolumeFile(dirs, &bio.FileConfig{}, false)
+}
+
+func (v *VolumeSource) Geometry() *Geometry {
+	return v.geo
+}
+
+// the descriptor cache stats of the files in volume
+func (v *VolumeSource) FileStats() bio.FileStats {
+	return v.file.Stats()
+}
+
+// HeaderGeneration reads the generation of the volume header on disk, it's
+// increased every time the header is flushed. the cache of Hybrid is not
+// used, so the flushes of the other processes are seen.
+func (v *VolumeSource) HeaderGeneration() (int64, error) {
+	vh, err := readVolumeHeaderSlots(v.file)
+	if err != nil {
+		return 0, err
+	}
+	return int64(vh.Generation), nil
+}
+
+func (v *VolumeSource) unlock() {
+	for _, flock := range v.flocks {
+		flock.Unlock()
+	}
+}
+
 func (v *VolumeSource) Close() {
-	v.flock.Unlock()
+	v.unlock()
 	v.file.Close()
 }
 
-func NewVolumeSource(dir string) (*VolumeSource, error) {
-	flock, err := common.LockDir(dir)
+// the chunk of the write head is preallocated, the log is append only
+var volumeFileConfig = bio.FileConfig{Preallocate: true}
+
+// NewVolumeSource makes a fresh volume in dirs, anything in them is removed.
+// the volume is striped over dirs if there are more than one.
+// geo: the geometry of the volume, nil means the default one
+func NewVolumeSource(dirs []string, geo *Geometry) (*VolumeSource, error) {
+	if len(dirs) == 0 {
+		return nil, ErrVolumeSourceNoDir.Trace()
+	}
+	if geo == nil {
+		geo = DefaultGeometry()
+	}
+
+	vs := &VolumeSource{geo: geo}
+	for _, dir := range dirs {
+		flock, err := common.LockDir(dir)
+		if err != nil {
+			vs.unlock()
+			return nil, err
+		}
+		vs.flocks = append(vs.flocks, flock)
+	}
+
+	cfg := volumeFileConfig
+	file, err := openVolumeFile(dirs, &cfg, true)
 	if err != nil {
-		return nil, err
+		vs.unlock()
+		return nil, logex.Trace(err)
 	}
+	vs.file = file
+	vs.Hybrid = bio.NewHybrid(file, geo.BlockBit)
+	return vs, nil
+}
 
-	file, err := bio.NewFile(dir)
+// OpenVolumeSource opens the volume in dirs, it will be created with geo
+// if not exists. an existing volume always uses the geometry in its header,
+// and a striped one can't be opened if any of its members is missing.
+func OpenVolumeSource(dirs []string, geo *Geometry) (*VolumeSource, error) {
+	if len(dirs) == 0 {
+		return nil, ErrVolumeSourceNoDir.Trace()
+	}
+	if geo == nil {
+		geo = DefaultGeometry()
+	}
+
+	vs := &VolumeSource{}
+	for _, dir := range dirs {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			vs.unlock()
+			return nil, logex.Trace(err)
+		}
+		flock, err := common.NewFlock(dir)
+		if err != nil {
+			vs.unlock()
+			return nil, err
+		}
+		vs.flocks = append(vs.flocks, flock)
+	}
+
+	cfg := volumeFileConfig
+	file, err := openVolumeFile(dirs, &cfg, true)
 	if err != nil {
-		return nil, fmt.Errorf("open volume: ", err)
+		vs.unlock()
+		return nil, logex.Trace(err)
+	}
+
+	if g, err := ReadVolumeGeometry(file); err == nil {
+		geo = g
+	} else if !logex.Equal(err, io.EOF) {
+		file.Close()
+		vs.unlock()
+		return nil, logex.Trace(err)
+	}
+
+	vs.file = file
+	vs.geo = geo
+	vs.Hybrid = bio.NewHybrid(file, geo.BlockBit)
+	return vs, nil
+}
+
+// OpenVolumeSourceReadOnly opens an existing volume in dirs without locking
+// it, so it can be inspected while a server is running on it.
+// it's only used with VolumeConfig.ReadOnly, and what it sees is the
+// state last persisted by the owner.
+func OpenVolumeSourceReadOnly(dirs []string) (*VolumeSource, error) {
+	if len(dirs) == 0 {
+		return nil, ErrVolumeSourceNoDir.Trace()
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); err != nil {
+			return nil, logex.Trace(err)
+		}
+	}
+
+	file, err := openVolumeFile(dirs, &bio.FileConfig{}, false)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+
+	geo, err := ReadVolumeGeometry(file)
+	if err != nil {
+		file.Close()
+		if logex.Equal(err, io.EOF) {
+			return nil, ErrVolumeReadOnly.Trace("volume is not exists")
+		}
+		return nil, logex.Trace(err)
 	}
 
 	return &VolumeSource{
-		flock:  flock,
 		file:   file,
-		Hybrid: bio.NewHybrid(file, BlockBit),
+		geo:    geo,
+		Hybrid: bio.NewHybrid(file, geo.BlockBit),
 	}, nil
 }
@@ -1,19 +1,116 @@
 package fs
 
+import (
+	"io"
+
+	"github.com/chzyer/logex"
+)
+
+var (
+	ErrHandleInvalidSeek = logex.Define("invalid seek")
+)
+
+const (
+	DefaultReadAhead = 4
+	// the sequential reads in a row before prefetching
+	ReadAheadMinSeq = 2
+)
+
 type Handle struct {
 	*File
 	offset int64
+
+	// the offset of the next read if it's sequential
+	seqOff int64
+	seqCnt int
+	// [raStart, raEnd) of the file is prefetched
+	raStart  int64
+	raEnd    int64
+	raCancel chan struct{}
 }
 
 func NewHandle(f *File, off int64) *Handle {
 	return &Handle{
 		File:   f,
 		offset: off,
+		seqOff: off,
 	}
 }
 
 func (f *Handle) Read(b []byte) (int, error) {
+	f.readAhead(len(b))
 	n, err := f.File.ReadAt(b, f.offset)
 	f.offset += int64(n)
+	f.seqOff = f.offset
 	return n, err
 }
+
+func (f *Handle) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.Size()
+	default:
+		return f.offset, ErrHandleInvalidSeek.Trace(whence)
+	}
+	if offset < 0 {
+		return f.offset, ErrHandleInvalidSeek.Trace(offset)
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *Handle) cancelReadAhead() {
+	if f.raCancel != nil {
+		close(f.raCancel)
+		f.raCancel = nil
+		f.stat.File.Prefetch.Cancel.Add(1)
+	}
+	f.raStart, f.raEnd = f.offset, f.offset
+}
+
+// prefetch the blocks after the read of n bytes at offset, if the reads
+// are sequential. the prefetching is cancelled on random access.
+func (f *Handle) readAhead(n int) {
+	window := f.cfg.ReadAhead
+	if window <= 0 {
+		return
+	}
+	if f.offset != f.seqOff {
+		f.seqCnt = 0
+		f.cancelReadAhead()
+	}
+	f.seqCnt++
+	if f.seqCnt < ReadAheadMinSeq {
+		return
+	}
+
+	end := f.offset + int64(n)
+	f.stat.File.Prefetch.Hit.HitIf(f.offset >= f.raStart && end <= f.raEnd)
+
+	// keep window blocks ahead, prefetch again if half of them are read
+	geo := f.cfg.Geometry
+	ahead := int64(window) << geo.BlockBit
+	if f.raEnd-end > ahead/2 {
+		return
+	}
+	from := f.raEnd
+	if from < f.offset {
+		from = f.offset
+		f.raStart = f.offset
+	}
+	from &^= int64(geo.BlockSize - 1)
+
+	blocks, full := f.File.blockRanges(from, int((end+ahead-from)>>geo.BlockBit))
+	if len(blocks) == 0 {
+		return
+	}
+	f.raEnd = from + int64(full)<<geo.BlockBit
+	f.stat.File.Prefetch.Blocks.Add(int64(full))
+	if f.raCancel == nil {
+		f.raCancel = make(chan struct{})
+	}
+	go f.File.prefetch(blocks, f.raCancel)
+}
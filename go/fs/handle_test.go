@@ -0,0 +1,102 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/allmad/madq/go/bio"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+func TestHandleReadAhead(t *testing.T) {
+	defer test.New(t)
+
+	geo, err := NewGeometry(MinBlockBit, 4)
+	test.Nil(err)
+	disk, err := bio.NewFile(test.Root())
+	test.Nil(err)
+	defer disk.Close()
+	h := bio.NewHybrid(disk, geo.BlockBit)
+
+	vol, err := NewVolume(flow.New(), &VolumeConfig{
+		Delegate:      h,
+		FlushInterval: time.Second,
+		Geometry:      geo,
+	})
+	test.Nil(err)
+	defer vol.Close()
+
+	// spread over many inodes
+	buf := test.SeqBytes(geo.InodeCap*4 + geo.BlockSize/2)
+	fd, err := vol.Open("hello", os.O_CREATE)
+	test.Nil(err)
+	defer fd.Close()
+	test.Write(fd, buf)
+	fd.Sync()
+
+	stat := vol.Stat()
+	got := make([]byte, 1<<10)
+	for off := 0; off < len(buf); off += len(got) {
+		n, err := fd.Read(got)
+		if err != nil {
+			test.Equal(err, io.EOF)
+		}
+		test.EqualBytes(got[:n], buf[off:off+n])
+		// let the prefetching go ahead
+		time.Sleep(time.Millisecond)
+	}
+	test.True(stat.File.Prefetch.Blocks > 0)
+	test.True(stat.File.Prefetch.Hit.Value > 0)
+	test.True(h.CacheStats().PrefetchHits > 0)
+	test.Equal(int64(stat.File.Prefetch.Cancel), int64(0))
+
+	// random access
+	off, err := fd.Seek(int64(geo.BlockSize*3+5), io.SeekStart)
+	test.Nil(err)
+	test.Read(fd, got)
+	test.EqualBytes(got, buf[off:off+int64(len(got))])
+	test.Equal(int64(stat.File.Prefetch.Cancel), int64(1))
+
+	_, err = fd.Seek(-1, io.SeekStart)
+	test.Equal(err, ErrHandleInvalidSeek)
+}
+
+func TestFileReadRef(t *testing.T) {
+	defer test.New(t)
+
+	geo, err := NewGeometry(MinBlockBit, 4)
+	test.Nil(err)
+	vol, err := NewVolume(flow.New(), &VolumeConfig{
+		Delegate:      bio.NewHybrid(test.NewMemDisk(), geo.BlockBit),
+		FlushInterval: time.Second,
+		Geometry:      geo,
+	})
+	test.Nil(err)
+	defer vol.Close()
+
+	buf := test.SeqBytes(geo.InodeCap*2 + geo.BlockSize/2)
+	fd, err := vol.Open("hello", os.O_CREATE)
+	test.Nil(err)
+	defer fd.Close()
+	test.Write(fd, buf)
+	fd.Sync()
+
+	// across the blocks and the inodes
+	off := int64(geo.InodeCap - 100)
+	r, err := fd.ReadRef(off, geo.BlockSize*2)
+	test.Nil(err)
+	test.True(len(r.Bytes()) > 1)
+	got := make([]byte, r.Len())
+	r.CopyTo(got)
+	r.Release()
+	test.EqualBytes(got, buf[off:off+int64(len(got))])
+
+	_, err = fd.ReadRef(int64(len(buf)-10), 20)
+	test.Equal(err, io.EOF)
+	n, err := fd.ReadAt(got, int64(len(buf)-10))
+	test.Equal(err, io.EOF)
+	test.EqualBytes(got[:n], buf[len(buf)-10:])
+}
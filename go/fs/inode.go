@@ -3,19 +3,14 @@ package fs
 import "github.com/chzyer/logex"
 
 var _ Diskable = new(Inode)
-var emptyPrevs = [6]*Address{
-	new(Address), new(Address), new(Address), new(Address), new(Address), new(Address),
-}
 
 const (
-	InodePadding  = 36
-	InodeSize     = 1024
-	InodeBlockCnt = 150
-	InodeCap      = InodeBlockCnt * BlockSize
+	InodePadding    = 28
+	InodeHeaderSize = MagicSize + 92 + InodePadding
 )
 
-// size: 1kB
-// one inode can store 37.5MB
+// size: InodeHeaderSize + 6 * Geometry.InodeBlockCnt
+// by default, one inode is 1kB and can store 37.5MB
 type Inode struct {
 	// Magic 4
 	Ino   Int32
@@ -29,26 +24,44 @@ type Inode struct {
 	GroupSize Int32
 	GroupIdx  Int32 // 8
 
-	Mtime Time // 4
+	Mtime Time // 8
+
+	// root of the InodeIndex for the inodes before
+	Index Address // 8
 
-	// total: 84
+	// total: 92
 
-	// padding : 1024 - (150*6) - 84 - Magic(4) = 36
+	// padding : 28
 
-	Offsets [InodeBlockCnt]ShortAddr
+	Offsets []ShortAddr // Geometry.InodeBlockCnt
 
+	geo  *Geometry
 	addr Address // my addr in disk/mem
 }
 
-func NewInode(ino int32) *Inode {
+func NewInode(geo *Geometry, ino int32) *Inode {
 	return &Inode{
 		Ino:       Int32(ino),
-		PrevInode: emptyPrevs,
+		PrevInode: newPrevInode(),
+		Offsets:   make([]ShortAddr, geo.InodeBlockCnt),
+		geo:       geo,
 	}
 }
 
+func newPrevInode() (ret [6]*Address) {
+	for idx := range ret {
+		ret[idx] = new(Address)
+	}
+	return
+}
+
 func (i *Inode) IsFull() bool {
-	return int(i.Size) == BlockSize*len(i.Offsets)
+	return int(i.Size) == i.geo.InodeCap
+}
+
+// global offset of the first byte in this inode
+func (i *Inode) StartOffset() int64 {
+	return int64(i.Start) << i.geo.BlockBit
 }
 
 // off: global offset
@@ -56,7 +69,7 @@ func (i *Inode) IsFull() bool {
 func (i *Inode) GetRemainInBlock(off int64) int {
 	// off in block
 	idx := i.GetBlockIdx(off)
-	offBlk := int(off & (BlockSize - 1))
+	offBlk := int(i.geo.OffsetInBlock(off))
 	remain := i.GetBlockSize(idx) - offBlk
 	if remain < 0 {
 		panic("off not in inode")
@@ -65,24 +78,24 @@ func (i *Inode) GetRemainInBlock(off int64) int {
 }
 
 func (i *Inode) GetBlockIdx(off int64) int {
-	offInInode := off - (int64(i.Start) * BlockSize)
+	offInInode := off - i.StartOffset()
 	if offInInode > int64(i.Size) {
 		panic("off not in inode")
 	}
-	return int(offInInode) >> BlockBit
+	return int(offInInode) >> i.geo.BlockBit
 }
 
 func (i *Inode) GetBlockSize(idx int) int {
 	lastIdx := i.GetSizeIdx()
 	if idx == lastIdx {
-		return int(i.Size % BlockSize)
+		return int(i.Size) & (i.geo.BlockSize - 1)
 	}
-	return BlockSize
+	return i.geo.BlockSize
 }
 
 func (i *Inode) GetSizeIdx() int {
-	// 256k per Offset
-	return int(i.Size) >> BlockBit
+	// one block per Offset
+	return int(i.Size) >> i.geo.BlockBit
 }
 
 func (i *Inode) SetOffset(idx int, addr ShortAddr, size int) {
@@ -90,7 +103,7 @@ func (i *Inode) SetOffset(idx int, addr ShortAddr, size int) {
 	i.Size += Int32(size)
 }
 
-func (i *Inode) DiskSize() int { return 1024 }
+func (i *Inode) DiskSize() int { return i.geo.InodeSize }
 
 func (i *Inode) Magic() Magic {
 	return MagicInode
@@ -113,6 +126,7 @@ func (i *Inode) WriteDisk(b []byte) {
 	dw.WriteItem(i.GroupIdx)
 
 	dw.WriteItem(i.Mtime)
+	dw.WriteItem(i.Index)
 
 	// padding
 	dw.Skip(InodePadding)
@@ -138,7 +152,7 @@ func (i *Inode) ReadDisk(b []byte) error {
 		i.PrevInode[4], i.PrevInode[5], &i.PrevGroup,
 
 		&i.GroupSize, &i.GroupIdx,
-		&i.Mtime,
+		&i.Mtime, &i.Index,
 	}); err != nil {
 		return logex.Trace(err)
 	}
@@ -158,9 +172,9 @@ func (i *Inode) ReadDisk(b []byte) error {
 }
 
 func (i *Inode) SeekIdx(offset int64) (int, bool) {
-	if offset > int64(i.Start*BlockSize+InodeCap) {
+	if offset >= i.StartOffset()+int64(i.geo.InodeCap) {
 		return -1, false
 	}
 
-	return int((offset % InodeCap) >> BlockBit), true
+	return int((offset % int64(i.geo.InodeCap)) >> i.geo.BlockBit), true
 }
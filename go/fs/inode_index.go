@@ -0,0 +1,274 @@
+package fs
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/chzyer/logex"
+)
+
+const (
+	InodeIndexFanoutBit = 8
+	InodeIndexFanout    = 1 << InodeIndexFanoutBit
+	InodeIndexNodeSize  = MagicSize + 8 + 6*InodeIndexFanout
+
+	// int32 inode idx needs at most 4 levels
+	InodeIndexMaxHeight = 4
+	// the most disk size written for indexing one inode
+	InodeIndexReserve = InodeIndexMaxHeight * InodeIndexNodeSize
+
+	// the nodes cached by each file, enough for the paths of 16 seeks
+	DefaultIndexCache = 16 * InodeIndexMaxHeight
+)
+
+type InodeIndexDelegate interface {
+	GetIndexNode(addr Address) (*InodeIndexNode, error)
+}
+
+// InodeIndex is a persistent radix tree maps the inode idx of one file to
+// the address of that inode.
+//
+// every inode(except the first one) carries the root of the tree which
+// covers all the inodes before it, so seeking in a file takes at most
+// InodeIndexMaxHeight reads. nodes are copy-on-write and written right
+// before the inode which refers to them.
+//
+// the nodes read or written are kept in an LRU, the old versions left by
+// copy-on-write are evicted as the others.
+type InodeIndex struct {
+	ino      int32
+	delegate InodeIndexDelegate
+	stat     *GStat
+	m        sync.Mutex
+
+	cacheSize int
+	lru       *list.List
+	nodes     map[Address]*list.Element
+}
+
+type indexCacheEntry struct {
+	addr Address
+	node *InodeIndexNode
+}
+
+func NewInodeIndex(ino int32, delegate InodeIndexDelegate, stat *GStat) *InodeIndex {
+	return &InodeIndex{
+		ino:       ino,
+		stat:      stat,
+		delegate:  delegate,
+		cacheSize: DefaultIndexCache,
+		lru:       list.New(),
+		nodes:     make(map[Address]*list.Element, DefaultIndexCache),
+	}
+}
+
+// SetCacheSize resizes the LRU of the nodes, size <= 0 disables the cache.
+func (x *InodeIndex) SetCacheSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	x.m.Lock()
+	x.cacheSize = size
+	x.reclaimLocked()
+	x.m.Unlock()
+}
+
+func (x *InodeIndex) CacheLen() int {
+	x.m.Lock()
+	ret := x.lru.Len()
+	x.m.Unlock()
+	return ret
+}
+
+func (x *InodeIndex) getCache(addr Address) *InodeIndexNode {
+	x.m.Lock()
+	defer x.m.Unlock()
+	elem := x.nodes[addr]
+	if elem == nil {
+		return nil
+	}
+	x.lru.MoveToFront(elem)
+	return elem.Value.(*indexCacheEntry).node
+}
+
+func (x *InodeIndex) addCache(addr Address, node *InodeIndexNode) {
+	x.m.Lock()
+	defer x.m.Unlock()
+	if elem := x.nodes[addr]; elem != nil {
+		elem.Value.(*indexCacheEntry).node = node
+		x.lru.MoveToFront(elem)
+		return
+	}
+	x.nodes[addr] = x.lru.PushFront(&indexCacheEntry{addr, node})
+	x.reclaimLocked()
+}
+
+func (x *InodeIndex) reclaimLocked() {
+	for x.lru.Len() > x.cacheSize {
+		entry := x.lru.Remove(x.lru.Back()).(*indexCacheEntry)
+		delete(x.nodes, entry.addr)
+	}
+}
+
+func (x *InodeIndex) getNode(addr Address) (*InodeIndexNode, error) {
+	node := x.getCache(addr)
+	x.stat.Inode.Index.CacheHit.HitIf(node != nil)
+	if node != nil {
+		return node, nil
+	}
+
+	x.stat.Inode.Index.ReadDisk.Add(1)
+	node, err := x.delegate.GetIndexNode(addr)
+	if err != nil {
+		return nil, logex.Trace(err, addr)
+	}
+	if int32(node.Ino) != x.ino {
+		return nil, logex.NewError("index node belongs to other file:", node.Ino)
+	}
+	x.addCache(addr, node)
+	return node, nil
+}
+
+// get the address of inode at inoIdx in the tree of root,
+// return empty address if not found.
+func (x *InodeIndex) Get(root Address, inoIdx int32) (Address, error) {
+	if root.IsEmpty() || inoIdx < 0 {
+		return 0, nil
+	}
+	node, err := x.getNode(root)
+	if err != nil {
+		return 0, err
+	}
+	if int64(inoIdx) >= node.Cap() {
+		return 0, nil
+	}
+
+	for {
+		addr := Address(node.Addr[node.Slot(inoIdx)])
+		if node.Height == 0 || addr.IsEmpty() {
+			return addr, nil
+		}
+		node, err = x.getNode(addr)
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// put the address of inode at inoIdx into the tree of root,
+// changed nodes are passed to write() from the leaf up, which returns
+// the address where the node is written to.
+// return the address of the new root.
+func (x *InodeIndex) Put(root Address, inoIdx int32, addr Address,
+	write func(*InodeIndexNode) Address) (Address, error) {
+
+	top := &InodeIndexNode{Ino: Int32(x.ino)}
+	if !root.IsEmpty() {
+		node, err := x.getNode(root)
+		if err != nil {
+			return 0, err
+		}
+		top = node.clone()
+	}
+	for int64(inoIdx) >= top.Cap() {
+		// the grown root is still in memory
+		if root.IsEmpty() && top.Height > 0 && !top.Addr[0].IsEmpty() {
+			root = x.writeNode(top, write)
+		}
+		parent := &InodeIndexNode{Ino: top.Ino, Height: top.Height + 1}
+		parent.Addr[0] = ShortAddr(root)
+		top, root = parent, 0
+	}
+
+	path := []*InodeIndexNode{top}
+	for node := top; node.Height > 0; {
+		var child *InodeIndexNode
+		if childAddr := Address(node.Addr[node.Slot(inoIdx)]); childAddr.IsEmpty() {
+			child = &InodeIndexNode{Ino: node.Ino, Height: node.Height - 1}
+		} else {
+			c, err := x.getNode(childAddr)
+			if err != nil {
+				return 0, err
+			}
+			child = c.clone()
+		}
+		path = append(path, child)
+		node = child
+	}
+
+	for i := len(path) - 1; i >= 0; i-- {
+		node := path[i]
+		node.Addr[node.Slot(inoIdx)] = ShortAddr(addr)
+		addr = x.writeNode(node, write)
+	}
+	return addr, nil
+}
+
+func (x *InodeIndex) writeNode(node *InodeIndexNode,
+	write func(*InodeIndexNode) Address) Address {
+
+	addr := write(node)
+	x.addCache(addr, node)
+	return addr
+}
+
+// -----------------------------------------------------------------------------
+
+var _ Diskable = new(InodeIndexNode)
+
+type InodeIndexNode struct {
+	// Magic 4
+	Ino    Int32
+	Height Int32 // 0 means leaf
+
+	// children in the upper levels, inodes in the leaf
+	Addr [InodeIndexFanout]ShortAddr
+}
+
+func (n *InodeIndexNode) clone() *InodeIndexNode {
+	ret := *n
+	return &ret
+}
+
+// the number of inodes can be covered
+func (n *InodeIndexNode) Cap() int64 {
+	return 1 << (uint(n.Height+1) * InodeIndexFanoutBit)
+}
+
+func (n *InodeIndexNode) Slot(inoIdx int32) int {
+	return int(inoIdx>>(uint(n.Height)*InodeIndexFanoutBit)) & (InodeIndexFanout - 1)
+}
+
+func (n *InodeIndexNode) Magic() Magic {
+	return MagicInodeIndex
+}
+
+func (n *InodeIndexNode) DiskSize() int {
+	return InodeIndexNodeSize
+}
+
+func (n *InodeIndexNode) WriteDisk(b []byte) {
+	dw := NewDiskWriter(b)
+	dw.WriteMagic(n)
+	dw.WriteItem(n.Ino)
+	dw.WriteItem(n.Height)
+	for idx := range n.Addr {
+		dw.WriteItem(n.Addr[idx])
+	}
+}
+
+func (n *InodeIndexNode) ReadDisk(b []byte) error {
+	dr := NewDiskReader(b)
+	if err := dr.ReadMagic(n); err != nil {
+		return logex.Trace(err)
+	}
+	if err := dr.ReadItems([]DiskReadItem{&n.Ino, &n.Height}); err != nil {
+		return logex.Trace(err)
+	}
+	for idx := range n.Addr {
+		if err := dr.ReadItem(&n.Addr[idx]); err != nil {
+			return logex.Trace(err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,282 @@
+package fs
+
+import (
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/allmad/madq/go/ptrace"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+type testIndexDisk struct {
+	md     *test.MemDisk
+	offset int64
+}
+
+func (t *testIndexDisk) GetIndexNode(addr Address) (*InodeIndexNode, error) {
+	node := new(InodeIndexNode)
+	if err := ReadDisk(t.md, node, addr); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (t *testIndexDisk) write(node *InodeIndexNode) Address {
+	addr := Address(t.offset)
+	test.Nil(WriteDiskAt(t.md, node, addr))
+	t.offset += int64(node.DiskSize())
+	return addr
+}
+
+func TestInodeIndex(t *testing.T) {
+	defer test.New(t)
+	disk := &testIndexDisk{md: test.NewMemDisk(), offset: 1}
+	x := NewInodeIndex(1, disk, NewStat())
+
+	var root Address
+	var err error
+	n := int32(InodeIndexFanout*2 + 3)
+	for i := int32(0); i < n; i++ {
+		root, err = x.Put(root, i, Address(100+i), disk.write)
+		test.Nil(err)
+	}
+
+	{ // far away from the current cap
+		far := int32(InodeIndexFanout*InodeIndexFanout + 1)
+		farRoot, err := x.Put(root, far, 99, disk.write)
+		test.Nil(err)
+		x = NewInodeIndex(1, disk, NewStat())
+		addr, err := x.Get(farRoot, far)
+		test.Nil(err)
+		test.Equal(addr, Address(99))
+		addr, err = x.Get(farRoot, n-1)
+		test.Nil(err)
+		test.Equal(addr, Address(100+n-1))
+	}
+
+	x = NewInodeIndex(1, disk, NewStat())
+	for i := int32(0); i < n; i++ {
+		addr, err := x.Get(root, i)
+		test.Nil(err)
+		test.Equal(addr, Address(100+i))
+	}
+	addr, err := x.Get(root, n)
+	test.Nil(err)
+	test.True(addr.IsEmpty())
+
+	_, err = NewInodeIndex(2, disk, NewStat()).Get(root, 0)
+	test.NotNil(err)
+}
+
+func TestInodeIndexCache(t *testing.T) {
+	defer test.New(t)
+	disk := &testIndexDisk{md: test.NewMemDisk(), offset: 1}
+	x := NewInodeIndex(1, disk, NewStat())
+	x.SetCacheSize(4)
+
+	var root Address
+	var err error
+	n := int32(InodeIndexFanout * 4)
+	for i := int32(0); i < n; i++ {
+		root, err = x.Put(root, i, Address(100+i), disk.write)
+		test.Nil(err)
+		// the old versions are not kept forever
+		test.True(x.CacheLen() <= 4)
+	}
+	for i := int32(0); i < n; i++ {
+		addr, err := x.Get(root, i)
+		test.Nil(err)
+		test.Equal(addr, Address(100+i))
+	}
+
+	x.SetCacheSize(0)
+	test.Equal(x.CacheLen(), 0)
+	addr, err := x.Get(root, n-1)
+	test.Nil(err)
+	test.Equal(addr, Address(100+n-1))
+}
+
+// -----------------------------------------------------------------------------
+
+type testSeekDelegate struct {
+	testFileDelegate
+	geo     *Geometry
+	lastest Address
+	reads   int
+}
+
+func (t *testSeekDelegate) SaveInode(ino *Inode) {
+	t.lastest = ino.addr
+}
+
+func (t *testSeekDelegate) GetInode(ino int32) (*Inode, error) {
+	if t.lastest.IsEmpty() {
+		return nil, os.ErrNotExist
+	}
+	return t.GetInodeByAddr(t.lastest)
+}
+
+func (t *testSeekDelegate) GetInodeByAddr(addr Address) (*Inode, error) {
+	t.reads++
+	ino := NewInode(t.geo, 0)
+	if err := ReadDisk(t.md, ino, addr); err != nil {
+		return nil, err
+	}
+	ino.addr = addr
+	return ino, nil
+}
+
+func (t *testSeekDelegate) GetIndexNode(addr Address) (*InodeIndexNode, error) {
+	t.reads++
+	return t.testFileDelegate.GetIndexNode(addr)
+}
+
+// write a file which has n inodes
+func testNewSeekFile(n int) *testSeekDelegate {
+	geo, err := NewGeometry(MinBlockBit, 1)
+	test.Nil(err)
+	md := test.NewMemDisk()
+	delegate := &testSeekDelegate{
+		testFileDelegate: testFileDelegate{md: md},
+		geo:              geo,
+	}
+
+	flusher := NewFlusher(flow.New(), &FlusherConfig{
+		Interval: time.Second,
+		Offset:   1,
+		Delegate: &testFlusherDelegate{md},
+		Geometry: geo,
+	})
+	defer flusher.Close()
+	f, err := NewFile(flow.New(), &FileConfig{
+		Flags:         os.O_CREATE,
+		Geometry:      geo,
+		Delegate:      delegate,
+		FlushInterval: time.Second,
+		FlushSize:     20 << 20,
+		Flusher:       flusher,
+	})
+	test.Nil(err)
+	test.Write(f, test.SeqBytes(n*geo.InodeCap-1))
+	f.Sync()
+	f.Close()
+	return delegate
+}
+
+func TestInodePoolSeekIndex(t *testing.T) {
+	defer test.New(t)
+	n := InodeIndexFanout + 10
+	delegate := testNewSeekFile(n)
+	geo := delegate.geo
+
+	for _, idx := range []int{0, 1, InodeIndexFanout - 1, InodeIndexFanout, n - 2, n - 1} {
+		off := int64(idx*geo.InodeCap) + 1
+		delegate.reads = 0
+		ip := NewInodePool(geo, 0, delegate, NewStat())
+		ino, err := ip.SeekPrev(off)
+		test.Nil(err)
+		test.Equal(int(ino.Start), idx*geo.InodeBlockCnt)
+		// lastest + index nodes + target
+		test.True(delegate.reads <= InodeIndexMaxHeight+2)
+
+		// same as walking the skip pointers
+		lastest, err := NewInodePool(geo, 0, delegate, NewStat()).GetLastest()
+		test.Nil(err)
+		ino2, err := ip.seekInode(lastest, int32(idx))
+		test.Nil(err)
+		test.Equal(ino2.Start, ino.Start)
+	}
+
+	ip := NewInodePool(geo, 0, delegate, NewStat())
+	_, err := ip.SeekPrev(int64(n * geo.InodeCap))
+	test.NotNil(err)
+}
+
+func TestInodePoolBuildIndexRestart(t *testing.T) {
+	defer test.New(t)
+	geo, err := NewGeometry(MinBlockBit, 1)
+	test.Nil(err)
+	md := test.NewMemDisk()
+	delegate := &testSeekDelegate{
+		testFileDelegate: testFileDelegate{md: md},
+		geo:              geo,
+	}
+	nodes := &testIndexDisk{md: md, offset: 1 << 20}
+
+	// inode 1 is not indexed, as if its index is failed to be built
+	var addrs [3]Address
+	for i := range addrs {
+		ino := NewInode(geo, 0)
+		ino.Start = Int32(i * geo.InodeBlockCnt)
+		if i > 0 {
+			*ino.PrevInode[0] = addrs[i-1]
+		}
+		addrs[i] = Address(1 + i*geo.InodeSize)
+		test.Nil(WriteDiskAt(md, ino, addrs[i]))
+	}
+
+	stat := NewStat()
+	ip := NewInodePool(geo, 0, delegate, stat)
+	ino2, err := ip.GetByAddr(addrs[2])
+	test.Nil(err)
+	test.Nil(ip.BuildIndex(ino2, nodes.write))
+	test.False(ino2.Index.IsEmpty())
+	test.Equal(stat.Inode.Index.Restart, ptrace.Int(1))
+
+	addr, err := ip.index.Get(ino2.Index, 1)
+	test.Nil(err)
+	test.Equal(addr, addrs[1])
+	// before the restart
+	addr, err = ip.index.Get(ino2.Index, 0)
+	test.Nil(err)
+	test.True(addr.IsEmpty())
+
+	// the later inodes are indexed as usual
+	ino3 := NewInode(geo, 0)
+	ino3.Start = Int32(3 * geo.InodeBlockCnt)
+	*ino3.PrevInode[0] = Address(nodes.offset)
+	test.Nil(WriteDiskAt(md, ino2, Address(nodes.offset)))
+	nodes.offset += int64(geo.InodeSize)
+	test.Nil(ip.BuildIndex(ino3, nodes.write))
+	test.Equal(stat.Inode.Index.Restart, ptrace.Int(1))
+	for idx, want := range map[int32]Address{1: addrs[1], 2: *ino3.PrevInode[0]} {
+		addr, err := ip.index.Get(ino3.Index, idx)
+		test.Nil(err)
+		test.Equal(addr, want)
+	}
+}
+
+func benchmarkSeek(b *testing.B, seek func(ip *InodePool, lastest *Inode, idx int32) (*Inode, error)) {
+	n := 4 << 10
+	delegate := testNewSeekFile(n)
+	b.ResetTimer()
+
+	delegate.reads = 0
+	for i := 0; i < b.N; i++ {
+		ip := NewInodePool(delegate.geo, 0, delegate, NewStat())
+		lastest, err := ip.GetLastest()
+		test.Nil(err)
+		idx := int32(rand.Intn(n))
+		ino, err := seek(ip, lastest, idx)
+		test.Nil(err)
+		test.Equal(ip.getInoIdx(ino), idx)
+	}
+	b.ReportMetric(float64(delegate.reads)/float64(b.N), "reads/op")
+}
+
+func BenchmarkSeekIndex(b *testing.B) {
+	defer test.New(b)
+	benchmarkSeek(b, func(ip *InodePool, lastest *Inode, idx int32) (*Inode, error) {
+		return ip.seekIndex(lastest, idx)
+	})
+}
+
+func BenchmarkSeekPrevInode(b *testing.B) {
+	defer test.New(b)
+	benchmarkSeek(b, func(ip *InodePool, lastest *Inode, idx int32) (*Inode, error) {
+		return ip.seekInode(lastest, idx)
+	})
+}
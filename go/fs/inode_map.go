@@ -1,7 +1,10 @@
 package fs
 
 import (
+	"bytes"
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"sync"
 
@@ -9,15 +12,38 @@ import (
 	"github.com/chzyer/logex"
 )
 
+var (
+	ErrInodeMapChecksum = logex.Define("inodemap page checksum mismatch")
+	// the file is created, but no inode is flushed yet
+	ErrInodeNotFound = logex.Define("inode not found")
+)
+
 const (
-	InodeMapCap  = 1 << 20
-	InodeMapSize = 6 * InodeMapCap
+	InodeMapPageCap    = 1 << 10
+	InodeMapPageHeader = 16
+	InodeMapPageSize   = InodeMapPageHeader + 6*InodeMapPageCap
+	// 2M inodes, twice of the old flat map. the space of all the pages is
+	// reserved in front of the log (about 25MB), so it's not made larger.
+	InodeMapMaxPage = 1 << 11
+	InodeMapCap     = InodeMapPageCap * InodeMapMaxPage
+
+	// every page got two slots(A/B) on disk
+	InodeMapSize = 2 * InodeMapPageSize * InodeMapMaxPage
 )
 
+// InodeMap maps ino to the address of its lastest inode.
+// it is splited into pages, and every page is stored in two slots in
+// turn, so a torn write only hurts the newer copy.
+//
+// pages are always allocated continuously, loading stops at the first
+// page which is never written. a page which both slots are corrupted
+// fails the loading, or the inodes in it would be lost silently.
 type InodeMap struct {
-	offset   ShortAddr
+	geo      *Geometry
+	offset   int64
 	delegate InodeMapDelegate
-	InoMap   []byte
+	pages    []*InodeMapPage
+	dirty    []int32
 	m        sync.Mutex
 }
 
@@ -25,60 +51,146 @@ type InodeMapDelegate interface {
 	bio.ReadWriterAt
 }
 
-func NewInodeMap(offset int64, delegate InodeMapDelegate, create bool) (*InodeMap, error) {
+func NewInodeMap(geo *Geometry, offset int64, delegate InodeMapDelegate) (*InodeMap, error) {
 	m := &InodeMap{
-		offset:   ShortAddr(offset),
+		geo:      geo,
+		offset:   offset,
 		delegate: delegate,
-		InoMap:   make([]byte, InodeMapSize),
 	}
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
 
-	n, err := delegate.ReadAt(m.InoMap, offset)
-	if err != nil {
-		if !logex.Equal(err, io.EOF) || !create {
-			return nil, logex.Trace(err)
+func (m *InodeMap) getPageAddr(idx int32, slot int32) int64 {
+	return m.offset + int64(2*idx+slot)*InodeMapPageSize
+}
+
+func (m *InodeMap) load() error {
+	buf := make([]byte, 2*InodeMapPageSize)
+	for idx := int32(0); idx < InodeMapMaxPage; idx++ {
+		n, err := m.delegate.ReadAt(buf, m.getPageAddr(idx, 0))
+		if err != nil && !logex.Equal(err, io.EOF) {
+			return logex.Trace(err)
+		}
+
+		page, err := readInodeMapPage(idx, buf[:n])
+		if err != nil {
+			return logex.Trace(err)
 		}
-		return m, nil
+		if page == nil {
+			break
+		}
+		m.pages = append(m.pages, page)
 	}
-	if n != len(m.InoMap) {
-		return nil, fmt.Errorf("read inodemap: short read")
+	return nil
+}
+
+// pick the newest valid one in the two slots, return nil if the page is
+// never written. a slot without the magic is never written, so a torn
+// first write of a page is taken as not written.
+func readInodeMapPage(idx int32, b []byte) (*InodeMapPage, error) {
+	var (
+		ret     *InodeMapPage
+		slotErr error
+		written int
+	)
+	for slot := 0; slot < 2; slot++ {
+		if len(b) < (slot+1)*InodeMapPageSize {
+			break
+		}
+		sb := b[slot*InodeMapPageSize : (slot+1)*InodeMapPageSize]
+		if !bytes.Equal(sb[:MagicSize], MagicInodeMap) {
+			continue
+		}
+		written++
+		page := new(InodeMapPage)
+		if err := page.ReadDisk(sb); err != nil {
+			slotErr = err
+			continue
+		}
+		if page.Idx != Int32(idx) {
+			slotErr = logex.NewError("inodemap page idx mismatch:", page.Idx, idx)
+			continue
+		}
+		if ret == nil || page.Seq > ret.Seq {
+			ret = page
+		}
 	}
-	return m, nil
+	if ret == nil && written == 2 {
+		return nil, logex.Trace(slotErr, "page:", idx)
+	}
+	return ret, nil
 }
 
-func (m *InodeMap) getData(ino int32) ([]byte, error) {
-	if int(ino) >= InodeMapCap {
+// must hold the lock
+func (m *InodeMap) getPage(ino int32, alloc bool) (*InodeMapPage, error) {
+	if ino < 0 || ino >= InodeMapCap {
 		return nil, fmt.Errorf("invalid inode number: %v", ino)
 	}
+	idx := ino / InodeMapPageCap
+	if int(idx) < len(m.pages) {
+		return m.pages[idx], nil
+	}
+	if !alloc {
+		return nil, nil
+	}
 
-	return m.InoMap[ino*6 : (ino+1)*6], nil
+	// fill the gap to keep pages continuous on disk
+	for int32(len(m.pages)) <= idx {
+		page := &InodeMapPage{Idx: Int32(len(m.pages))}
+		m.pages = append(m.pages, page)
+		m.markDirty(page)
+	}
+	return m.pages[idx], nil
+}
+
+func (m *InodeMap) markDirty(page *InodeMapPage) {
+	if page.dirty {
+		return
+	}
+	page.dirty = true
+	m.dirty = append(m.dirty, int32(page.Idx))
 }
 
 func (m *InodeMap) GetInodeByAddr(addr Address) (*Inode, error) {
-	inode := NewInode(-1)
+	inode := NewInode(m.geo, -1)
 	if err := ReadDisk(m.delegate, inode, addr); err != nil {
 		return nil, err
 	}
+	inode.addr = addr
 	return inode, nil
 }
 
-func (m *InodeMap) GetInode(ino int32) (*Inode, error) {
+func (m *InodeMap) GetInodeAddr(ino int32) (ShortAddr, error) {
 	m.m.Lock()
 	defer m.m.Unlock()
 
-	addrData, err := m.getData(ino)
+	page, err := m.getPage(ino, false)
+	if err != nil {
+		return 0, err
+	}
+	if page == nil {
+		return 0, nil
+	}
+	return page.Addr[ino%InodeMapPageCap], nil
+}
+
+func (m *InodeMap) GetInode(ino int32) (*Inode, error) {
+	addr, err := m.GetInodeAddr(ino)
 	if err != nil {
 		return nil, err
 	}
-	var addr ShortAddr
-	_ = addr.ReadDisk(addrData)
 	if addr.IsEmpty() {
-		return nil, fmt.Errorf("fild not found: (ino: %v)", ino)
+		return nil, ErrInodeNotFound.Trace("ino:", ino)
 	}
 
-	inode := NewInode(ino)
+	inode := NewInode(m.geo, ino)
 	if err := ReadDisk(m.delegate, inode, Address(addr)); err != nil {
 		return nil, err
 	}
+	inode.addr = Address(addr)
 	return inode, nil
 }
 
@@ -88,22 +200,111 @@ func (m *InodeMap) SaveInode(inode *Inode) {
 	}
 
 	m.m.Lock()
-	addrData, err := m.getData(int32(inode.Ino))
+	page, err := m.getPage(int32(inode.Ino), true)
 	if err != nil {
 		panic("inode number exceed cap")
 	}
-	ShortAddr(inode.addr).WriteDisk(addrData)
+	page.Addr[inode.Ino%InodeMapPageCap] = ShortAddr(inode.addr)
+	m.markDirty(page)
 	m.m.Unlock()
 }
 
+func (m *InodeMap) PageCnt() int {
+	m.m.Lock()
+	ret := len(m.pages)
+	m.m.Unlock()
+	return ret
+}
+
+func (m *InodeMap) DirtyCnt() int {
+	m.m.Lock()
+	ret := len(m.dirty)
+	m.m.Unlock()
+	return ret
+}
+
 func (m *InodeMap) DiskSize() int {
-	return 6 * (1 << 30)
+	return InodeMapSize
 }
 
-// TODO(chzyer): add replica
+// only dirty pages are written, into the slot which is not holding the
+// newest copy.
 func (m *InodeMap) Flush() error {
 	m.m.Lock()
-	_, err := m.delegate.WriteAt(m.InoMap, int64(m.offset))
-	m.m.Unlock()
-	return err
+	defer m.m.Unlock()
+
+	buf := make([]byte, InodeMapPageSize)
+	for len(m.dirty) > 0 {
+		page := m.pages[m.dirty[0]]
+		page.Seq++
+		page.WriteDisk(buf)
+		addr := m.getPageAddr(int32(page.Idx), int32(page.Seq&1))
+		if _, err := m.delegate.WriteAt(buf, addr); err != nil {
+			page.Seq--
+			return logex.Trace(err)
+		}
+		page.dirty = false
+		m.dirty = m.dirty[1:]
+	}
+	m.dirty = nil
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+var _ Diskable = new(InodeMapPage)
+
+type InodeMapPage struct {
+	// Magic 4
+	Idx Int32
+	Seq Int32
+	// Checksum 4
+
+	Addr [InodeMapPageCap]ShortAddr
+
+	dirty bool
+}
+
+func (p *InodeMapPage) Magic() Magic {
+	return MagicInodeMap
+}
+
+func (p *InodeMapPage) DiskSize() int {
+	return InodeMapPageSize
+}
+
+func (p *InodeMapPage) checksum(b []byte) uint32 {
+	crc := crc32.ChecksumIEEE(b[:InodeMapPageHeader-4])
+	return crc32.Update(crc, crc32.IEEETable, b[InodeMapPageHeader:InodeMapPageSize])
+}
+
+func (p *InodeMapPage) WriteDisk(b []byte) {
+	dw := NewDiskWriter(b)
+	dw.WriteMagic(p)
+	dw.WriteItem(p.Idx)
+	dw.WriteItem(p.Seq)
+	dw.Skip(4)
+	for idx := range p.Addr {
+		dw.WriteItem(p.Addr[idx])
+	}
+	binary.BigEndian.PutUint32(b[InodeMapPageHeader-4:], p.checksum(b))
+}
+
+func (p *InodeMapPage) ReadDisk(b []byte) error {
+	dr := NewDiskReader(b)
+	if err := dr.ReadMagic(p); err != nil {
+		return logex.Trace(err)
+	}
+	if err := dr.ReadItems([]DiskReadItem{&p.Idx, &p.Seq}); err != nil {
+		return logex.Trace(err)
+	}
+	if binary.BigEndian.Uint32(dr.ReadBytes(4)) != p.checksum(b) {
+		return ErrInodeMapChecksum.Trace(p.Idx, p.Seq)
+	}
+	for idx := range p.Addr {
+		if err := dr.ReadItem(&p.Addr[idx]); err != nil {
+			return logex.Trace(err)
+		}
+	}
+	return nil
 }
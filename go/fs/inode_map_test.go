@@ -0,0 +1,126 @@
+package fs
+
+import (
+	"testing"
+
+	"github.com/allmad/madq/go/bio"
+	"github.com/chzyer/logex"
+	"github.com/chzyer/test"
+)
+
+type testInodeMapDisk struct {
+	bio.ReadWriterAt
+	writeCnt int
+}
+
+func (t *testInodeMapDisk) WriteAt(b []byte, off int64) (int, error) {
+	t.writeCnt++
+	return t.ReadWriterAt.WriteAt(b, off)
+}
+
+func testSaveInode(m *InodeMap, ino int32, addr Address) {
+	inode := NewInode(m.geo, ino)
+	inode.addr = addr
+	m.SaveInode(inode)
+}
+
+func TestInodeMap(t *testing.T) {
+	defer test.New(t)
+	md := &testInodeMapDisk{ReadWriterAt: test.NewMemDisk()}
+
+	m, err := NewInodeMap(DefaultGeometry(), 0, md)
+	test.Nil(err)
+	test.Equal(m.PageCnt(), 0)
+
+	testSaveInode(m, 1, 100)
+	testSaveInode(m, InodeMapPageCap*2+1, 200)
+	// grow beyond 1M inodes
+	bigIno := int32(1<<20 + 5)
+	testSaveInode(m, bigIno, 300)
+	test.Equal(m.PageCnt(), int(bigIno/InodeMapPageCap)+1)
+	test.Nil(m.Flush())
+	test.Equal(m.DirtyCnt(), 0)
+
+	{ // only dirty pages are written
+		md.writeCnt = 0
+		testSaveInode(m, 2, 101)
+		testSaveInode(m, 3, 102)
+		test.Nil(m.Flush())
+		test.Equal(md.writeCnt, 1)
+	}
+
+	m, err = NewInodeMap(DefaultGeometry(), 0, md)
+	test.Nil(err)
+	test.Equal(m.PageCnt(), int(bigIno/InodeMapPageCap)+1)
+	for _, item := range []struct {
+		ino  int32
+		addr ShortAddr
+	}{
+		{1, 100}, {2, 101}, {3, 102},
+		{InodeMapPageCap*2 + 1, 200},
+		{bigIno, 300},
+		{InodeMapPageCap, 0},
+		{bigIno + InodeMapPageCap, 0},
+	} {
+		addr, err := m.GetInodeAddr(item.ino)
+		test.Nil(err)
+		test.Equal(addr, item.addr)
+	}
+
+	_, err = m.GetInodeAddr(InodeMapCap)
+	test.NotNil(err)
+}
+
+func TestInodeMapTornWrite(t *testing.T) {
+	defer test.New(t)
+	md := test.NewMemDisk()
+
+	m, err := NewInodeMap(DefaultGeometry(), 0, md)
+	test.Nil(err)
+	testSaveInode(m, 1, 100)
+	test.Nil(m.Flush()) // seq 1, slot B
+	testSaveInode(m, 1, 200)
+	test.Nil(m.Flush()) // seq 2, slot A
+
+	// broken the newest copy
+	test.WriteAt(md, []byte("torn"), InodeMapPageSize/2)
+
+	m, err = NewInodeMap(DefaultGeometry(), 0, md)
+	test.Nil(err)
+	addr, err := m.GetInodeAddr(1)
+	test.Nil(err)
+	test.Equal(addr, ShortAddr(100))
+
+	// next write must not overwrite the only valid copy
+	testSaveInode(m, 1, 300)
+	test.Nil(m.Flush())
+	m, err = NewInodeMap(DefaultGeometry(), 0, md)
+	test.Nil(err)
+	addr, err = m.GetInodeAddr(1)
+	test.Nil(err)
+	test.Equal(addr, ShortAddr(300))
+}
+
+func TestInodeMapCorrupted(t *testing.T) {
+	defer test.New(t)
+	md := test.NewMemDisk()
+
+	m, err := NewInodeMap(DefaultGeometry(), 0, md)
+	test.Nil(err)
+	testSaveInode(m, 1, 100)
+	test.Nil(m.Flush())
+	testSaveInode(m, 1, 200)
+	test.Nil(m.Flush())
+
+	// a torn first write of the next page is taken as not written
+	test.WriteAt(md, MagicInodeMap, 2*InodeMapPageSize)
+	m, err = NewInodeMap(DefaultGeometry(), 0, md)
+	test.Nil(err)
+	test.Equal(m.PageCnt(), 1)
+
+	// both copies of page 0 are broken
+	test.WriteAt(md, []byte("torn"), InodeMapPageSize/2)
+	test.WriteAt(md, []byte("torn"), InodeMapPageSize+InodeMapPageSize/2)
+	_, err = NewInodeMap(DefaultGeometry(), 0, md)
+	test.True(logex.Equal(err, ErrInodeMapChecksum))
+}
@@ -2,12 +2,14 @@ package fs
 
 import (
 	"fmt"
+	"io"
 	"unsafe"
 
 	"github.com/chzyer/logex"
 )
 
 type InodePoolDelegate interface {
+	InodeIndexDelegate
 	GetInode(ino int32) (*Inode, error)
 	GetInodeByAddr(addr Address) (*Inode, error)
 	SaveInode(inode *Inode)
@@ -17,9 +19,12 @@ var inodeOffsetIdx = initOffsetIdx()
 
 // Pool for one file
 type InodePool struct {
-	ino int32
+	ino  int32
+	geo  *Geometry
+	stat *GStat
 
 	scatter InodeScatter
+	index   *InodeIndex
 
 	delegate InodePoolDelegate
 	pool     map[Address]*Inode
@@ -29,9 +34,12 @@ type InodePool struct {
 	nextInode   map[Address]*Inode
 }
 
-func NewInodePool(ino int32, delegate InodePoolDelegate) *InodePool {
+func NewInodePool(geo *Geometry, ino int32, delegate InodePoolDelegate, stat *GStat) *InodePool {
 	p := &InodePool{
 		ino:      ino,
+		geo:      geo,
+		stat:     stat,
+		index:    NewInodeIndex(ino, delegate, stat),
 		delegate: delegate,
 	}
 	p.ResetCache()
@@ -48,7 +56,7 @@ func (p *InodePool) SeekNext(inode *Inode) (*Inode, error) {
 	if next := p.getNextInCache(inode); next != nil {
 		return next, nil
 	}
-	return p.seekPrev(int64(inode.Start*BlockSize + InodeCap))
+	return p.seekPrev(inode.StartOffset() + int64(p.geo.InodeCap))
 }
 
 func (p *InodePool) SeekPrev(offset int64) (*Inode, error) {
@@ -57,12 +65,12 @@ func (p *InodePool) SeekPrev(offset int64) (*Inode, error) {
 
 func (p *InodePool) getInoIdxInCache(inoIdx int32) *Inode {
 	ino := p.offsetInode[inoIdx]
-	Stat.Inode.Cache.InoIdxHit.HitIf(ino != nil)
+	p.stat.Inode.Cache.InoIdxHit.HitIf(ino != nil)
 	return ino
 }
 
 func (p *InodePool) seekPrev(offset int64) (*Inode, error) {
-	inoIdx := GetInodeIdx(offset)
+	inoIdx := p.geo.GetInodeIdx(offset)
 	if inode := p.getInoIdxInCache(inoIdx); inode != nil {
 		return inode, nil
 	}
@@ -71,14 +79,104 @@ func (p *InodePool) seekPrev(offset int64) (*Inode, error) {
 	if err != nil {
 		return nil, logex.Trace(err)
 	}
+	if inoIdx > p.getInoIdx(lastest) {
+		return nil, io.EOF
+	}
+
+	ino, err := p.seekIndex(lastest, inoIdx)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	if ino != nil {
+		return ino, nil
+	}
 
-	ino, err := p.seekInode(lastest, inoIdx)
+	ino, err = p.seekInode(lastest, inoIdx)
 	if err != nil {
 		return nil, logex.Trace(err)
 	}
 	return ino, nil
 }
 
+// find in the InodeIndex of lastest, return nil if not indexed.
+func (p *InodePool) seekIndex(lastest *Inode, inoIdx int32) (*Inode, error) {
+	if inoIdx == p.getInoIdx(lastest) {
+		return lastest, nil
+	}
+	addr, err := p.index.Get(lastest.Index, inoIdx)
+	if err != nil {
+		return nil, err
+	}
+	p.stat.Inode.Index.Seek.HitIf(!addr.IsEmpty())
+	if addr.IsEmpty() {
+		return nil, nil
+	}
+	return p.GetByAddr(addr)
+}
+
+// build the InodeIndex of ino if it is the first time to be written,
+// write() returns the address of the node is written to.
+//
+// the index is built on the nearest inode before ino which is on disk. if
+// that one is not indexed (a read error, or an old volume), a new tree is
+// started from it, so only the inodes before it fall back to PrevInode,
+// instead of all the later ones.
+func (p *InodePool) BuildIndex(ino *Inode, write func(*InodeIndexNode) Address) error {
+	if ino.Start == 0 || !ino.Index.IsEmpty() {
+		return nil
+	}
+	prev, prevAddr, err := p.getPrevOnDisk(ino)
+	if err != nil {
+		return logex.Trace(err)
+	}
+	if prev == nil {
+		return nil
+	}
+	if *ino.PrevInode[0] != prevAddr {
+		// the inodes still in memory are skipped
+		p.stat.Inode.Index.Skip.Add(1)
+	}
+	if prev.Start > 0 && prev.Index.IsEmpty() {
+		p.stat.Inode.Index.Restart.Add(1)
+	}
+
+	root, err := p.index.Put(prev.Index, p.getInoIdx(prev), prevAddr, write)
+	if err != nil {
+		return logex.Trace(err)
+	}
+	ino.Index = root
+	return nil
+}
+
+// walk back by PrevInode[0] until the inode is on disk,
+// return nil if there is none.
+func (p *InodePool) getPrevOnDisk(ino *Inode) (*Inode, Address, error) {
+	for ino.Start > 0 {
+		addr := *ino.PrevInode[0]
+		if addr.IsEmpty() {
+			return nil, 0, nil
+		}
+		if !addr.IsInMem() {
+			prev, err := p.GetByAddr(addr)
+			if err != nil {
+				return nil, 0, err
+			}
+			return prev, addr, nil
+		}
+		prev := p.pool[addr]
+		if prev == nil {
+			return nil, 0, nil
+		}
+		ino = prev
+	}
+	return nil, 0, nil
+}
+
+// resize the LRU of the InodeIndex nodes, see InodeIndex.SetCacheSize
+func (p *InodePool) SetIndexCache(size int) {
+	p.index.SetCacheSize(size)
+}
+
 func (p *InodePool) getPrevInode(ino *Inode, n int32) (*Inode, error) {
 	if n == 0 {
 		return ino, nil
@@ -87,7 +185,11 @@ func (p *InodePool) getPrevInode(ino *Inode, n int32) (*Inode, error) {
 		panic(fmt.Sprint("distance < 0: ", n))
 	}
 
-	addr := *ino.PrevInode[inodeOffsetIdx[n]]
+	k := len(ino.PrevInode) - 1
+	if int(n) < len(inodeOffsetIdx) {
+		k = inodeOffsetIdx[n]
+	}
+	addr := *ino.PrevInode[k]
 	if ino := p.pool[addr]; ino != nil {
 		return ino, nil
 	}
@@ -98,7 +200,7 @@ func (p *InodePool) getPrevInode(ino *Inode, n int32) (*Inode, error) {
 		panic("addr is empty")
 	}
 
-	Stat.Inode.ReadDisk.Add(1)
+	p.stat.Inode.ReadDisk.Add(1)
 	ino, err := p.delegate.GetInodeByAddr(addr)
 	if err != nil {
 		return nil, err
@@ -108,27 +210,22 @@ func (p *InodePool) getPrevInode(ino *Inode, n int32) (*Inode, error) {
 	return ino, nil
 }
 
+// walk back by the skip pointers in PrevInode
 func (p *InodePool) seekInode(base *Inode, inoIdx int32) (*Inode, error) {
-	start := int32(base.Start / InodeBlockCnt)
-	distance := inoIdx - start
-	if distance == 0 {
-		return base, nil
-	}
-
 	// TODO: try to found in scatter
 
 	for tryTime := 0; ; tryTime++ {
+		distance := p.getInoIdx(base) - inoIdx
+		if distance == 0 {
+			p.stat.Inode.PrevSeekCnt.HitN(tryTime)
+			return base, nil
+		}
 		newIno, err := p.getPrevInode(base, distance)
 		if err != nil {
 			return nil, err
 		}
-		if int32(newIno.Start) == inoIdx {
-			Stat.Inode.PrevSeekCnt.HitN(tryTime)
-			return newIno, nil
-		}
 		base = newIno
 	}
-
 }
 
 func (p *InodePool) CleanCache() {
@@ -191,7 +288,7 @@ func (p *InodePool) updateInodeAddr(ino *Inode, addr Address) {
 }
 
 func (p *InodePool) InitInode() *Inode {
-	ret := NewInode(p.ino)
+	ret := NewInode(p.geo, p.ino)
 	ret.addr.SetMem(unsafe.Pointer(ret))
 	p.addCache(ret)
 	p.scatter.Push(ret)
@@ -199,11 +296,8 @@ func (p *InodePool) InitInode() *Inode {
 }
 
 func (p *InodePool) next(lastest *Inode) *Inode {
-	ret := &Inode{
-		Ino:       lastest.Ino,
-		Start:     lastest.Start + Int32(len(lastest.Offsets)),
-		PrevInode: emptyPrevs,
-	}
+	ret := NewInode(p.geo, int32(lastest.Ino))
+	ret.Start = lastest.Start + Int32(len(lastest.Offsets))
 	p.setPrevs(ret)
 
 	ret.addr.SetMem(unsafe.Pointer(ret))
@@ -233,13 +327,17 @@ func (i *InodePool) GetByAddr(addr Address) (*Inode, error) {
 
 func (p *InodePool) getNextInCache(i *Inode) *Inode {
 	ino := p.nextInode[i.addr]
-	Stat.Inode.Cache.NextHit.HitIf(ino != nil)
+	p.stat.Inode.Cache.NextHit.HitIf(ino != nil)
 	return ino
 }
 
+func (p *InodePool) getInoIdx(i *Inode) int32 {
+	return int32(i.Start) / int32(p.geo.InodeBlockCnt)
+}
+
 func (p *InodePool) addCache(i *Inode) {
 	p.pool[i.addr] = i
-	p.offsetInode[int32(i.Start/InodeBlockCnt)] = i
+	p.offsetInode[p.getInoIdx(i)] = i
 	p.nextInode[*i.PrevInode[0]] = i
 }
 
@@ -252,7 +350,7 @@ func (i *InodePool) getInScatter(n int) (*Inode, error) {
 	}
 
 	k := len(i.scatter) - 1
-	for ; k <= len(i.scatter)-1-n; k-- {
+	for ; k >= len(i.scatter)-1-n; k-- {
 		if i.scatter[k] == nil {
 			// lastest one
 			if k == len(i.scatter)-1 {
@@ -29,6 +29,10 @@ func (t *testInodePoolDelegate) GetInodeByAddr(addr Address) (*Inode, error) {
 	return nil, io.EOF
 }
 
+func (t *testInodePoolDelegate) GetIndexNode(addr Address) (*InodeIndexNode, error) {
+	return nil, io.EOF
+}
+
 func checkInoOffset(ino *Inode, start ShortAddr, n int) {
 	val := start
 	for i := 0; i < n; i++ {
@@ -42,7 +46,7 @@ func TestInodePool(t *testing.T) {
 	delegate := &testInodePoolDelegate{
 		data: make(map[Address]*Inode),
 	}
-	ip := NewInodePool(0, delegate)
+	ip := NewInodePool(DefaultGeometry(), 0, delegate, NewStat())
 	ip.InitInode()
 
 	lastest, err := ip.GetLastest()
@@ -54,7 +58,7 @@ func TestInodePool(t *testing.T) {
 	for i := 0; i < n; i++ {
 		inode, idx, err := ip.RefPayloadBlock()
 		test.Nil(err)
-		inode.SetOffset(idx, ShortAddr(i), BlockSize)
+		inode.SetOffset(idx, ShortAddr(i), DefaultBlockSize)
 	}
 
 	nextN := func() ShortAddr {
@@ -67,16 +71,16 @@ func TestInodePool(t *testing.T) {
 	ino0, err := ip.getInScatter(1)
 	test.Nil(err)
 	// Offsets: [0, ..., 149]
-	checkInoOffset(ino0, 0, InodeBlockCnt)
+	checkInoOffset(ino0, 0, DefaultInodeBlockCnt)
 	test.True(ino0.Start == 0)
-	test.True(ino0.Size == InodeCap)
+	test.True(int(ino0.Size) == ip.geo.InodeCap)
 
 	// start: 1
 	ino1, err := ip.getInScatter(0)
 	test.Nil(err)
-	test.True(ino1.Start == InodeBlockCnt)
-	test.True(ino1.Size == BlockSize)
-	checkInoOffset(ino1, InodeBlockCnt, 1)
+	test.True(ino1.Start == DefaultInodeBlockCnt)
+	test.True(ino1.Size == DefaultBlockSize)
+	checkInoOffset(ino1, DefaultInodeBlockCnt, 1)
 	test.True(ino0.addr == *ino1.PrevInode[0])
 
 	// flush to disk
@@ -93,7 +97,7 @@ func TestInodePool(t *testing.T) {
 	inode, idx, err := ip.RefPayloadBlock()
 	test.Nil(err)
 	inode.SetOffset(idx, nextN(), 5)
-	checkInoOffset(ino1, InodeBlockCnt, 2)
+	checkInoOffset(ino1, DefaultInodeBlockCnt, 2)
 	test.Equal(inode, ino1)
 
 	ip.OnFlush(ino1, 3)
@@ -104,13 +108,13 @@ func TestInodePool(t *testing.T) {
 	delegate.data = ip.pool
 	delegate.lastest = ino1
 
-	ip = NewInodePool(0, delegate)
+	ip = NewInodePool(DefaultGeometry(), 0, delegate, NewStat())
 	// write to ino1
 	{
 		inode, idx, err := ip.RefPayloadBlock()
 		test.Nil(err)
 		test.Equal(idx, 1)
-		inode.SetOffset(idx, nextN(), BlockSize-5)
+		inode.SetOffset(idx, nextN(), DefaultBlockSize-5)
 		inode, idx, err = ip.RefPayloadBlock()
 		test.Nil(err)
 		test.Equal(idx, 2)
@@ -125,10 +129,10 @@ func TestInodePool(t *testing.T) {
 		inode, err := ip.getInScatter(0)
 		test.Nil(err)
 		test.Equal(inode, ino1)
-		test.True(inode.Offsets[0] == InodeBlockCnt)
-		test.True(inode.Offsets[1] == InodeBlockCnt+2)
-		test.True(inode.Offsets[2] == InodeBlockCnt+3)
-		test.True(inode.Size == BlockSize*2+5)
+		test.True(inode.Offsets[0] == DefaultInodeBlockCnt)
+		test.True(inode.Offsets[1] == DefaultInodeBlockCnt+2)
+		test.True(inode.Offsets[2] == DefaultInodeBlockCnt+3)
+		test.True(inode.Size == DefaultBlockSize*2+5)
 	}
 }
 
@@ -138,7 +142,7 @@ func TestInodePoolSeek(t *testing.T) {
 	delegate := &testInodePoolDelegate{
 		data: make(map[Address]*Inode),
 	}
-	ip := NewInodePool(0, delegate)
+	ip := NewInodePool(DefaultGeometry(), 0, delegate, NewStat())
 	ip.InitInode()
 
 	ip.RefPayloadBlock()
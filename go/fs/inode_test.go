@@ -9,7 +9,7 @@ import (
 
 func TestInode(t *testing.T) {
 	defer test.New(t)
-	ino := NewInode(0)
+	ino := NewInode(DefaultGeometry(), 0)
 
 	ino.Size = 12
 	for i := range ino.Offsets {
@@ -20,8 +20,33 @@ func TestInode(t *testing.T) {
 	rw := NewDiskBuffer(buf)
 	rw.WriteItem(ino)
 
-	newIno := NewInode(0)
+	newIno := NewInode(DefaultGeometry(), 0)
 	err := rw.ReadItem(newIno)
 	test.Nil(err)
 	test.Equal(ino, newIno)
 }
+
+func TestInodeSeekIdx(t *testing.T) {
+	defer test.New(t)
+	geo := DefaultGeometry()
+	inodeCap := int64(geo.InodeCap)
+
+	for _, start := range []Int32{0, Int32(geo.InodeBlockCnt)} {
+		ino := NewInode(geo, 0)
+		ino.Start = start
+		base := ino.StartOffset()
+
+		idx, ok := ino.SeekIdx(base)
+		test.True(ok)
+		test.Equal(idx, 0)
+
+		idx, ok = ino.SeekIdx(base + inodeCap - 1)
+		test.True(ok)
+		test.Equal(idx, geo.InodeBlockCnt-1)
+
+		// the first byte of the next inode must not wrap to block 0
+		idx, ok = ino.SeekIdx(base + inodeCap)
+		test.False(ok)
+		test.Equal(idx, -1)
+	}
+}
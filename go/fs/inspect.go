@@ -0,0 +1,200 @@
+package fs
+
+import (
+	"fmt"
+
+	"github.com/chzyer/logex"
+)
+
+// helpers for inspecting the metadata on disk, used by debug tools.
+
+// the address of the inode, negative if it's still in memory
+func (i *Inode) Addr() Address {
+	return i.addr
+}
+
+// the index of this inode in the file
+func (i *Inode) Idx() int32 {
+	return int32(i.Start) / int32(i.geo.InodeBlockCnt)
+}
+
+// the ino of the file named name
+func (v *Volume) Ino(name string) (int32, error) {
+	ino, err := v.nameMap.GetIno(name)
+	if err != nil {
+		return -1, logex.Trace(err)
+	}
+	if ino < 0 {
+		return -1, ErrFileNotExist.Trace(name)
+	}
+	return ino, nil
+}
+
+func (v *Volume) InodeMapSlot(ino int32) (*InodeMapSlot, error) {
+	return v.header.InodeMap.Slot(ino)
+}
+
+// -----------------------------------------------------------------------------
+
+// InodeMapSlot is where an ino is stored in the InodeMap, with both
+// copies of its page on disk.
+type InodeMapSlot struct {
+	Ino   int32
+	Page  int32
+	Index int32
+	// in memory, may not be flushed yet
+	Addr ShortAddr
+	Disk [2]InodeMapSlotCopy
+}
+
+type InodeMapSlotCopy struct {
+	Offset int64 // of the page
+	Valid  bool
+	Error  string `json:",omitempty"`
+	Seq    int32
+	Addr   ShortAddr
+}
+
+func (m *InodeMap) Slot(ino int32) (*InodeMapSlot, error) {
+	if ino < 0 || ino >= InodeMapCap {
+		return nil, fmt.Errorf("invalid inode number: %v", ino)
+	}
+	addr, err := m.GetInodeAddr(ino)
+	if err != nil {
+		return nil, err
+	}
+	ret := &InodeMapSlot{
+		Ino:   ino,
+		Page:  ino / InodeMapPageCap,
+		Index: ino % InodeMapPageCap,
+		Addr:  addr,
+	}
+
+	buf := make([]byte, InodeMapPageSize)
+	for slot := range ret.Disk {
+		c := &ret.Disk[slot]
+		c.Offset = m.getPageAddr(ret.Page, int32(slot))
+		if _, err := m.delegate.ReadAt(buf, c.Offset); err != nil {
+			c.Error = err.Error()
+			continue
+		}
+		page := new(InodeMapPage)
+		if err := page.ReadDisk(buf); err != nil {
+			c.Error = err.Error()
+			continue
+		}
+		if page.Idx != Int32(ret.Page) {
+			c.Error = fmt.Sprintf("page idx mismatch: %v", page.Idx)
+			continue
+		}
+		c.Valid = true
+		c.Seq = int32(page.Seq)
+		c.Addr = page.Addr[ret.Index]
+	}
+	return ret, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// the inode at inoIdx of the file, the lastest one if inoIdx < 0
+func (f *File) InodeAt(inoIdx int32) (*Inode, error) {
+	if inoIdx < 0 {
+		return f.inodePool.GetLastest()
+	}
+	return f.inodePool.SeekPrev(int64(inoIdx) * int64(f.cfg.Geometry.InodeCap))
+}
+
+// InodeChain is all the inodes of a file, walked back from the lastest one
+// by PrevInode[0].
+type InodeChain struct {
+	Inodes []*Inode // ordered by idx
+	Errors []string
+}
+
+func (c *InodeChain) errorf(format string, obj ...interface{}) {
+	c.Errors = append(c.Errors, fmt.Sprintf(format, obj...))
+}
+
+// VerifyChain walks every inode back to Start 0 and validates the skip
+// pointers and the InodeIndex against the addresses seen in the walk.
+// inodes are read from the disk unless they are not flushed yet.
+func (f *File) VerifyChain() (*InodeChain, error) {
+	return f.inodePool.verifyChain()
+}
+
+func (p *InodePool) verifyChain() (*InodeChain, error) {
+	lastest, err := p.GetLastest()
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	n := lastest.Idx()
+	chain := &InodeChain{Inodes: make([]*Inode, n+1)}
+
+	cur := lastest
+	for idx := n; ; idx-- {
+		if int32(cur.Ino) != p.ino {
+			chain.errorf("inode %v: ino is %v, want %v", idx, cur.Ino, p.ino)
+		}
+		if cur.Idx() != idx {
+			chain.errorf("inode %v: start is %v, want %v", idx, cur.Start,
+				idx*int32(p.geo.InodeBlockCnt))
+			chain.Inodes = chain.Inodes[idx+1:]
+			return chain, nil
+		}
+		if idx < n && !cur.IsFull() {
+			chain.errorf("inode %v: not full, size: %v", idx, cur.Size)
+		}
+		chain.Inodes[idx] = cur
+		if idx == 0 {
+			break
+		}
+
+		addr := *cur.PrevInode[0]
+		if addr.IsEmpty() {
+			chain.errorf("inode %v: chain is broken, prev is empty", idx)
+			chain.Inodes = chain.Inodes[idx:]
+			return chain, nil
+		}
+		var prev *Inode
+		if addr.IsInMem() {
+			prev = p.getInPool(addr)
+			if prev == nil {
+				err = fmt.Errorf("not found in memory")
+			}
+		} else {
+			prev, err = p.delegate.GetInodeByAddr(addr)
+		}
+		if err != nil {
+			chain.errorf("inode %v: read prev at %v: %v", idx, addr, err)
+			chain.Inodes = chain.Inodes[idx:]
+			return chain, nil
+		}
+		cur = prev
+	}
+
+	for idx, ino := range chain.Inodes {
+		for k, ptr := range ino.PrevInode {
+			want := Address(0)
+			if target := idx - 1<<uint(k); target >= 0 {
+				want = chain.Inodes[target].addr
+			}
+			if *ptr != want {
+				chain.errorf("inode %v: PrevInode[%v] is %v, want %v", idx, k, *ptr, want)
+			}
+		}
+	}
+
+	if !lastest.Index.IsEmpty() {
+		for idx := int32(0); idx < n; idx++ {
+			addr, err := p.index.Get(lastest.Index, idx)
+			if err != nil {
+				chain.errorf("index: get %v: %v", idx, err)
+				break
+			}
+			if !addr.IsEmpty() && addr != chain.Inodes[idx].addr {
+				chain.errorf("index: inode %v is %v, want %v", idx, addr, chain.Inodes[idx].addr)
+			}
+		}
+	}
+	return chain, nil
+}
@@ -0,0 +1,80 @@
+package fs
+
+import (
+	"os"
+	"testing"
+
+	"github.com/allmad/madq/go/bio"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+func TestInspect(t *testing.T) {
+	defer test.New(t)
+	geo, err := NewGeometry(MinBlockBit, 1)
+	test.Nil(err)
+	md := test.NewMemDisk()
+	newVolume := func() *Volume {
+		vol, err := NewVolume(flow.New(), &VolumeConfig{
+			Delegate: bio.NewHybrid(md, geo.BlockBit),
+			Geometry: geo,
+		})
+		test.Nil(err)
+		return vol
+	}
+
+	n := 40
+	vol := newVolume()
+	fd, err := vol.Open("hello", os.O_CREATE)
+	test.Nil(err)
+	test.Write(fd, test.SeqBytes(n*geo.InodeCap-1))
+	fd.Sync()
+
+	chain, err := fd.VerifyChain()
+	test.Nil(err)
+	test.Equal(len(chain.Errors), 0)
+	test.Equal(len(chain.Inodes), n)
+	for idx, ino := range chain.Inodes {
+		test.Equal(ino.Idx(), int32(idx))
+	}
+
+	ino, err := fd.InodeAt(3)
+	test.Nil(err)
+	test.Equal(ino.Idx(), int32(3))
+	test.Equal(ino.Addr(), chain.Inodes[3].Addr())
+	ino, err = fd.InodeAt(-1)
+	test.Nil(err)
+	test.Equal(ino.Idx(), int32(n-1))
+	fd.Close()
+	vol.Close()
+
+	vol = newVolume()
+	i, err := vol.Ino("hello")
+	test.Nil(err)
+	_, err = vol.Ino("hello2")
+	test.Equal(err, ErrFileNotExist)
+
+	slot, err := vol.InodeMapSlot(i)
+	test.Nil(err)
+	test.Equal(slot.Addr, ShortAddr(chain.Inodes[n-1].Addr()))
+	valid := 0
+	for _, c := range slot.Disk {
+		if c.Valid && c.Addr == slot.Addr {
+			valid++
+		}
+	}
+	test.True(valid >= 1)
+	vol.Close()
+
+	// break the magic of inode 5
+	test.WriteAt(md, []byte{0, 0, 0, 0}, int64(chain.Inodes[5].Addr()))
+	vol = newVolume()
+	defer vol.Close()
+	fd, err = vol.Open("hello", 0)
+	test.Nil(err)
+	defer fd.Close()
+	chain, err = fd.VerifyChain()
+	test.Nil(err)
+	test.True(len(chain.Errors) > 0)
+	test.Equal(chain.Inodes[0].Idx(), int32(6))
+}
@@ -0,0 +1,123 @@
+package fs
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/chzyer/logex"
+)
+
+const logWalkChunk = 1 << 20
+
+// LogBatch is what one flush wrote into the log:
+// | data area + partial area | index nodes + inodes | MagicEOF |
+type LogBatch struct {
+	Addr       Address
+	Length     int64 // including MagicEOF
+	DataLength int64
+	IndexNodes int
+	Inodes     []*Inode
+}
+
+func (b *LogBatch) End() Address {
+	return b.Addr + Address(b.Length)
+}
+
+// LogWalker walks the physical log batch by batch without the volume
+// metadata, so it works on a volume which can't be opened.
+//
+// a batch ends with a MagicEOF which is right after an inode, or the batch
+// is empty. the records before MagicEOF are recognized by their magic.
+type LogWalker struct {
+	r   io.ReaderAt
+	geo *Geometry
+	off int64
+	// the chunks are read into it, and the batches found don't refer to it
+	buf []byte
+}
+
+// from: the address of the first batch, VolumeHeaderMinCheckpoint is the
+// start of the log.
+func NewLogWalker(r io.ReaderAt, geo *Geometry, from int64) *LogWalker {
+	if from < VolumeHeaderMinCheckpoint {
+		from = VolumeHeaderMinCheckpoint
+	}
+	return &LogWalker{
+		r:   r,
+		geo: geo,
+		off: from,
+		buf: make([]byte, logWalkChunk+MagicSize-1),
+	}
+}
+
+// the address of the next batch
+func (w *LogWalker) Offset() int64 {
+	return w.off
+}
+
+// Next returns the next batch, io.EOF if no more batch is found.
+func (w *LogWalker) Next() (*LogBatch, error) {
+	buf := w.buf
+	pos := w.off
+	for {
+		n, err := w.r.ReadAt(buf, pos)
+		if err != nil && !logex.Equal(err, io.EOF) {
+			return nil, logex.Trace(err)
+		}
+		for i := 0; i+MagicSize <= n; {
+			idx := bytes.Index(buf[i:n], MagicEOF)
+			if idx < 0 {
+				break
+			}
+			i += idx
+			if batch := w.tryBatch(Address(w.off), Address(pos+int64(i))); batch != nil {
+				w.off = int64(batch.End())
+				return batch, nil
+			}
+			i++
+		}
+		if n < len(buf) {
+			return nil, io.EOF
+		}
+		pos += int64(n - MagicSize + 1)
+	}
+}
+
+// check the records before the MagicEOF at eof
+func (w *LogWalker) tryBatch(start, eof Address) *LogBatch {
+	batch := &LogBatch{
+		Addr:   start,
+		Length: int64(eof-start) + MagicSize,
+	}
+
+	metaStart := eof
+	for metaStart > start {
+		if addr := metaStart - Address(w.geo.InodeSize); addr >= start {
+			ino := NewInode(w.geo, -1)
+			if ReadDisk(w.r, ino, addr) == nil {
+				ino.addr = addr
+				batch.Inodes = append(batch.Inodes, ino)
+				metaStart = addr
+				continue
+			}
+		}
+		if addr := metaStart - InodeIndexNodeSize; addr >= start {
+			if ReadDisk(w.r, new(InodeIndexNode), addr) == nil {
+				batch.IndexNodes++
+				metaStart = addr
+				continue
+			}
+		}
+		break
+	}
+	if len(batch.Inodes) == 0 && eof != start {
+		return nil
+	}
+
+	// walked backward
+	for i, j := 0, len(batch.Inodes)-1; i < j; i, j = i+1, j-1 {
+		batch.Inodes[i], batch.Inodes[j] = batch.Inodes[j], batch.Inodes[i]
+	}
+	batch.DataLength = int64(metaStart - start)
+	return batch
+}
@@ -0,0 +1,76 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"testing"
+
+	"github.com/allmad/madq/go/bio"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+func TestLogWalker(t *testing.T) {
+	defer test.New(t)
+	geo, err := NewGeometry(MinBlockBit, 4)
+	test.Nil(err)
+	md := test.NewMemDisk()
+	vol, err := NewVolume(flow.New(), &VolumeConfig{
+		Delegate: bio.NewHybrid(md, geo.BlockBit),
+		Geometry: geo,
+	})
+	test.Nil(err)
+
+	inos := make(map[int32]bool)
+	for _, name := range []string{"a", "b"} {
+		fd, err := vol.Open(name, os.O_CREATE)
+		test.Nil(err)
+		inos[fd.Ino()] = true
+		for i := 0; i < 3; i++ {
+			test.Write(fd, test.SeqBytes(geo.BlockSize*3+100))
+			fd.Sync()
+		}
+		fd.Close()
+	}
+	vol.Close()
+
+	vh, err := ReadVolumeHeader(md)
+	test.Nil(err)
+
+	// corrupt the header, walking doesn't need it
+	test.WriteAt(md, make([]byte, VolumeHeaderSize), 0)
+	_, err = ReadVolumeHeader(md)
+	test.NotNil(err)
+
+	w := NewLogWalker(md, geo, 0)
+	end := Address(VolumeHeaderMinCheckpoint)
+	batches := 0
+	seen := make(map[int32]bool)
+	for {
+		batch, err := w.Next()
+		if err == io.EOF {
+			break
+		}
+		test.Nil(err)
+		test.Equal(batch.Addr, end)
+		test.True(batch.DataLength >= 0)
+		for _, ino := range batch.Inodes {
+			test.True(ino.Addr() >= batch.Addr+Address(batch.DataLength))
+			test.True(ino.Addr() < batch.End())
+			seen[int32(ino.Ino)] = true
+		}
+		end = batch.End()
+		batches++
+	}
+	test.Equal(end, vh.Checkpoint)
+	test.True(batches >= 6)
+	for ino := range inos {
+		test.True(seen[ino])
+	}
+
+	// start from the middle of a batch
+	w = NewLogWalker(md, geo, VolumeHeaderMinCheckpoint+1)
+	batch, err := w.Next()
+	test.Nil(err)
+	test.True(batch.Length > 0)
+}
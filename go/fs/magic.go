@@ -17,6 +17,8 @@ func (m Magic) String() string {
 		{MagicEOF, "EOF"},
 		{MagicVolume, "Volume"},
 		{MagicInode, "Inode"},
+		{MagicInodeMap, "InodeMap"},
+		{MagicInodeIndex, "InodeIndex"},
 	}
 
 	for _, i := range items {
@@ -29,7 +31,9 @@ func (m Magic) String() string {
 }
 
 var (
-	MagicEOF    = Magic{0x8a, 0x9b, 0x0, 0x1}
-	MagicVolume = Magic{0x8a, 0x9b, 0x0, 0x2}
-	MagicInode  = Magic{0x8a, 0x9b, 0x0, 0x3}
+	MagicEOF        = Magic{0x8a, 0x9b, 0x0, 0x1}
+	MagicVolume     = Magic{0x8a, 0x9b, 0x0, 0x2}
+	MagicInode      = Magic{0x8a, 0x9b, 0x0, 0x3}
+	MagicInodeMap   = Magic{0x8a, 0x9b, 0x0, 0x4}
+	MagicInodeIndex = Magic{0x8a, 0x9b, 0x0, 0x5}
 )
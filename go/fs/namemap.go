@@ -2,7 +2,9 @@ package fs
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 )
 
 const FileNameSize = 28
@@ -20,6 +22,7 @@ type NameMap struct {
 	cache   map[FileName]int32
 	useIno  map[int32]struct{}
 	freeIno int32
+	m       sync.Mutex
 }
 
 func NewNameMap(fh *Handle, start int32) (*NameMap, error) {
@@ -41,6 +44,8 @@ func NewNameMap(fh *Handle, start int32) (*NameMap, error) {
 }
 
 func (n *NameMap) List() []string {
+	n.m.Lock()
+	defer n.m.Unlock()
 	list := make([]string, 0, len(n.cache))
 	for k, ino := range n.cache {
 		list = append(list, fmt.Sprintf("%v\t%v", k.String(), ino))
@@ -48,6 +53,28 @@ func (n *NameMap) List() []string {
 	return list
 }
 
+func (n *NameMap) Names() []string {
+	n.m.Lock()
+	list := make([]string, 0, len(n.cache))
+	for k := range n.cache {
+		list = append(list, k.String())
+	}
+	n.m.Unlock()
+	sort.Strings(list)
+	return list
+}
+
+// name => ino of all the files
+func (n *NameMap) Inos() map[string]int32 {
+	n.m.Lock()
+	ret := make(map[string]int32, len(n.cache))
+	for k, ino := range n.cache {
+		ret[k.String()] = ino
+	}
+	n.m.Unlock()
+	return ret
+}
+
 func (n *NameMap) checkIno(ino int32) {
 	if ino == n.freeIno {
 		n.freeIno++
@@ -60,6 +87,8 @@ func (n *NameMap) checkIno(ino int32) {
 }
 
 func (n *NameMap) GetFreeIno() (int32, error) {
+	n.m.Lock()
+	defer n.m.Unlock()
 	ino := n.freeIno
 	if ino < 0 {
 		return -1, fmt.Errorf("not free ino")
@@ -94,12 +123,15 @@ func (n *NameMap) AddIno(name string, ino int32) error {
 		return err
 	}
 
+	n.m.Lock()
 	if _, ok := n.cache[fn]; ok {
+		n.m.Unlock()
 		return fmt.Errorf("file is already exists")
 	}
 
 	n.cache[fn] = ino
 	n.useIno[ino] = struct{}{}
+	n.m.Unlock()
 	buf := make([]byte, NameMapItemSize)
 	(&NameMapItem{fn, Int32(ino)}).WriteDisk(buf)
 
@@ -117,7 +149,9 @@ func (n *NameMap) GetIno(name string) (ino int32, err error) {
 		return -1, err
 	}
 
+	n.m.Lock()
 	ino, ok := n.cache[fn]
+	n.m.Unlock()
 	if !ok {
 		ino = -1
 	}
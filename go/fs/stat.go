@@ -6,13 +6,15 @@ import (
 	"github.com/allmad/madq/go/ptrace"
 )
 
-var Stat GStat
-
-func ResetStat() {
-	Stat = GStat{}
-}
-
+// GStat is owned by a Volume and shared by all the components in it,
+// all counters are safe to be updated concurrently.
 type GStat struct {
+	// live throughput
+	Throughput struct {
+		FlushBytes ptrace.Meter
+		Records    ptrace.Meter
+		ReadBytes  ptrace.Meter
+	}
 	Volume struct {
 		CloseTime ptrace.RatioTime
 	}
@@ -35,7 +37,7 @@ type GStat struct {
 			Total    ptrace.RatioTime
 			Count    ptrace.Int
 			Size     ptrace.RatioSize
-			RawWrite ptrace.RatioTime
+			RawWrite ptrace.Histogram
 		}
 		CloseTime        ptrace.RatioTime
 		FlushBufferAddOp ptrace.RatioTime
@@ -50,6 +52,16 @@ type GStat struct {
 
 		ReadDisk    ptrace.Int
 		PrevSeekCnt ptrace.Ratio
+		Index       struct {
+			// the misses fall back to the walk by PrevInode
+			Seek     ptrace.Ratio
+			CacheHit ptrace.Ratio
+			ReadDisk ptrace.Int
+			// trees started over since the inode before is not indexed
+			Restart ptrace.Int
+			// inodes indexed without the ones before still in memory
+			Skip ptrace.Int
+		}
 	}
 	File struct {
 		FlushSize   ptrace.RatioSize
@@ -60,11 +72,19 @@ type GStat struct {
 		}
 		Flush struct {
 			WaitSize  ptrace.Ratio
-			WaitReply ptrace.RatioTime
+			WaitReply ptrace.Histogram
+		}
+		DiskRead ptrace.Histogram
+		Prefetch struct {
+			Blocks ptrace.Int
+			Cancel ptrace.Int
+			// the sequential reads in the prefetched range
+			Hit ptrace.Ratio
 		}
-		DiskRead ptrace.RatioTime
 	}
 	Cobuffer struct {
+		// bytes waiting in the cobuffers of all the files, it's a gauge
+		Buffered           int64
 		Trytime            ptrace.Ratio
 		NotifyFlushByWrite ptrace.Ratio
 		GetData            struct {
@@ -75,11 +95,34 @@ type GStat struct {
 		Grow       ptrace.RatioTime
 		FlushDelay ptrace.RatioTime
 		FullTime   ptrace.RatioTime
-		WriteTime  ptrace.RatioTime
+		WriteTime  ptrace.Histogram
 	}
 }
 
+func NewStat() *GStat {
+	return new(GStat)
+}
+
+// Stat is the compatibility view of the old package-level stats: the
+// volumes opened with VolumeConfig.Stat set to it report into one place.
+// others should read Volume.Stat().
+var Stat = NewStat()
+
+// copy of current stats
+func (c *GStat) Snapshot() *GStat {
+	ret := NewStat()
+	ptrace.Snapshot(ret, c)
+	return ret
+}
+
+// the changes since prev, prev is a Snapshot() before
+func (c *GStat) Delta(prev *GStat) *GStat {
+	ret := NewStat()
+	ptrace.Delta(ret, c, prev)
+	return ret
+}
+
 func (c *GStat) String() string {
-	ret, _ := json.MarshalIndent(c, "", "\t")
+	ret, _ := json.MarshalIndent(c.Snapshot(), "", "\t")
 	return string(ret)
 }
@@ -1,7 +1,114 @@
 package fs
 
-import "testing"
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/allmad/madq/go/bio"
+	"github.com/allmad/madq/go/ptrace"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
 
 func TestStat(t *testing.T) {
-	Stat.String()
+	_ = NewStat().String()
+}
+
+func TestStatDelta(t *testing.T) {
+	defer test.New(t)
+	stat := NewStat()
+	stat.Flusher.Flush.Count.Add(2)
+	stat.Flusher.Flush.Size.AddInt(10)
+	stat.Flusher.Flush.RawWrite.Add(time.Millisecond)
+	stat.Inode.Cache.NextHit.Hit()
+
+	prev := stat.Snapshot()
+	stat.Flusher.Flush.Count.Add(3)
+	stat.Flusher.Flush.Size.AddInt(20)
+	stat.Flusher.Flush.RawWrite.Add(2 * time.Millisecond)
+	stat.Inode.Cache.NextHit.Miss()
+
+	delta := stat.Delta(prev)
+	test.Equal(delta.Flusher.Flush.Count, ptrace.Int(3))
+	test.Equal(delta.Flusher.Flush.Size.Size, ptrace.Size(20))
+	test.Equal(delta.Flusher.Flush.Size.Count, ptrace.Int(1))
+	test.Equal(delta.Flusher.Flush.RawWrite.Count(), int64(1))
+	test.Equal(delta.Flusher.Flush.RawWrite.Sum(), 2*time.Millisecond)
+	test.Equal(delta.Inode.Cache.NextHit.Value, ptrace.Int(0))
+	test.Equal(delta.Inode.Cache.NextHit.Count, ptrace.Int(1))
+
+	// snapshot is not changed
+	test.Equal(prev.Flusher.Flush.Count, ptrace.Int(2))
+	test.Equal(prev.Flusher.Flush.RawWrite.Count(), int64(1))
+}
+
+func TestStatPerVolume(t *testing.T) {
+	defer test.New(t)
+	var vols [2]*Volume
+	for idx := range vols {
+		vol, err := NewVolume(flow.New(), &VolumeConfig{
+			Delegate: bio.NewHybrid(test.NewMemDisk(), DefaultBlockBit),
+		})
+		test.Nil(err)
+		defer vol.Close()
+		vols[idx] = vol
+	}
+
+	fd, err := vols[0].Open("hello", os.O_CREATE)
+	test.Nil(err)
+	test.Write(fd, []byte("hello"))
+	fd.Sync()
+	test.ReadAt(fd, []byte("hello"), 0)
+	fd.Close()
+
+	test.True(vols[0].Stat().Flusher.Flush.Count > 0)
+	test.True(vols[0].Stat().Throughput.Records.Total() >= 1)
+	test.Equal(vols[0].Stat().Throughput.ReadBytes.Total(), int64(5))
+	test.True(vols[0].Stat().Throughput.FlushBytes.Total() > 5)
+	test.Equal(vols[1].Stat().Throughput.FlushBytes.Total(), int64(0))
+	test.Equal(vols[1].Stat().Flusher.Flush.Count, ptrace.Int(0))
+}
+
+func TestStatCompat(t *testing.T) {
+	defer test.New(t)
+	before := Stat.Flusher.Flush.Count.Load()
+	vol, err := NewVolume(flow.New(), &VolumeConfig{
+		Delegate: bio.NewHybrid(test.NewMemDisk(), DefaultBlockBit),
+		Stat:     Stat,
+	})
+	test.Nil(err)
+	test.True(vol.Stat() == Stat)
+
+	fd, err := vol.Open("hello", os.O_CREATE)
+	test.Nil(err)
+	test.Write(fd, []byte("hello"))
+	fd.Sync()
+	fd.Close()
+	vol.Close()
+	test.True(Stat.Flusher.Flush.Count.Load() > before)
+	test.True(strings.Contains(Stat.String(), "RawWrite"))
+}
+
+func TestStatOpenMetrics(t *testing.T) {
+	defer test.New(t)
+	stat := NewStat()
+	stat.Flusher.Flush.RawWrite.Add(time.Millisecond)
+
+	buf := bytes.NewBuffer(nil)
+	test.Nil(ptrace.WriteOpenMetrics(buf, "madq_fs", stat))
+	for _, name := range []string{
+		"madq_fs_flusher_flush_raw_write_seconds_count ",
+		"madq_fs_flusher_flush_count_total ",
+		"madq_fs_inode_cache_next_hit_hits_total ",
+		"madq_fs_file_flush_size_bytes_sum ",
+		"madq_fs_volume_close_time_seconds_count ",
+		"madq_fs_throughput_flush_bytes_total ",
+		`madq_fs_throughput_read_bytes_rate{window="10s"} `,
+	} {
+		test.True(strings.Contains(buf.String(), "\n"+name))
+	}
+	test.True(strings.HasSuffix(buf.String(), "# EOF\n"))
 }
@@ -27,6 +27,26 @@ func (v Int32) WriteDisk(w []byte) {
 
 // -----------------------------------------------------------------------------
 
+var _ DiskItem = new(Int64)
+
+type Int64 int64
+
+func (Int64) DiskSize() int {
+	return 8
+}
+
+func (v *Int64) ReadDisk(w []byte) error {
+	n := binary.BigEndian.Uint64(w)
+	*v = Int64(n)
+	return nil
+}
+
+func (v Int64) WriteDisk(w []byte) {
+	binary.BigEndian.PutUint64(w, uint64(v))
+}
+
+// -----------------------------------------------------------------------------
+
 type Time int64
 
 func (Time) DiskSize() int { return 8 }
@@ -1,9 +1,13 @@
 package fs
 
 import (
+	"encoding/binary"
 	"fmt"
+	"hash/crc32"
 	"io"
 	"os"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/allmad/madq/go/bio"
@@ -11,14 +15,21 @@ import (
 	"github.com/chzyer/logex"
 )
 
-var ErrFileNotExist = logex.Define("file is not exists")
+var (
+	ErrFileNotExist   = logex.Define("file is not exists")
+	ErrVolumeReadOnly = logex.Define("volume is read-only")
+)
 
 type Volume struct {
 	cfg       *VolumeConfig
 	flow      *flow.Flow
+	geo       *Geometry
+	stat      *GStat
 	header    *VolumeHeader
 	delegate  VolumeDelegate
 	fileCache map[string]*File
+	cacheLock sync.Mutex
+	sizes     *fileSizes
 
 	// init
 	flusher *Flusher
@@ -28,12 +39,33 @@ type Volume struct {
 type VolumeDelegate interface {
 	bio.ReadWriterAt
 	ReadData(off int64, n int) ([]byte, error)
+	ReadRef(r *bio.Ref, off int64, n int) error
+	// load [off, off+n) into the cache in advance
+	Prefetch(off int64, n int)
 }
 
 type VolumeConfig struct {
 	Delegate      VolumeDelegate
 	FlushInterval time.Duration
 	FlushSize     int
+
+	// only used on creating, the geometry of an existing volume is
+	// always read from its header
+	Geometry *Geometry
+
+	// nothing will be written to the delegate, the volume must exist
+	ReadOnly bool
+
+	// the blocks prefetched for the sequential reads of a Handle, 0 is
+	// DefaultReadAhead and -1 disables it
+	ReadAhead int
+
+	// the InodeIndex nodes cached by each file, 0 is DefaultIndexCache and
+	// -1 disables it
+	IndexCache int
+
+	// the stats to update, a new one is made for the volume if nil
+	Stat *GStat
 }
 
 func (v *VolumeConfig) init() error {
@@ -46,6 +78,9 @@ func (v *VolumeConfig) init() error {
 	if v.FlushSize == 0 {
 		v.FlushSize = 10 << 20
 	}
+	if v.Geometry == nil {
+		v.Geometry = DefaultGeometry()
+	}
 	return nil
 }
 
@@ -59,19 +94,30 @@ func NewVolume(f *flow.Flow, cfg *VolumeConfig) (*Volume, error) {
 		if !logex.Equal(err, io.EOF) {
 			return nil, logex.Trace(err)
 		}
+		if cfg.ReadOnly {
+			return nil, ErrVolumeReadOnly.Trace("volume is not exists")
+		}
 
 		// make a new one
-		vh, err = GenNewVolumeHeader(cfg.Delegate)
+		vh, err = GenNewVolumeHeader(cfg.Delegate, cfg.Geometry)
 		if err != nil {
 			return nil, logex.Trace(err)
 		}
 	}
 
+	stat := cfg.Stat
+	if stat == nil {
+		stat = NewStat()
+	}
+
 	vol := &Volume{
 		cfg:       cfg,
+		geo:       vh.Geometry(),
+		stat:      stat,
 		header:    vh,
 		delegate:  cfg.Delegate,
 		fileCache: make(map[string]*File, 16),
+		sizes:     newFileSizes(),
 	}
 	f.ForkTo(&vol.flow, vol.Close)
 
@@ -102,6 +148,8 @@ func (v *Volume) initFlusher() *Flusher {
 		Offset:   int64(v.header.Checkpoint),
 		Interval: v.cfg.FlushInterval,
 		Delegate: &volumeFlusherDelegate{v.header, v.delegate},
+		Geometry: v.geo,
+		Stat:     v.stat,
 	})
 
 	return f
@@ -138,12 +186,16 @@ func (v *Volume) getFileInCache(name string) *Handle {
 func (v *Volume) inoOpen(ino int32, name string, flags int) (*File, error) {
 	fd, err := NewFile(v.flow, &FileConfig{
 		Ino:           ino,
+		Geometry:      v.geo,
 		Flags:         flags,
 		Name:          name,
-		Delegate:      &volumeFileDelegate{v.delegate, v.header.InodeMap},
+		Delegate:      &volumeFileDelegate{v.delegate, v.header.InodeMap, v.sizes},
 		FlushInterval: v.cfg.FlushInterval,
 		FlushSize:     v.cfg.FlushSize,
+		ReadAhead:     v.cfg.ReadAhead,
+		IndexCache:    v.cfg.IndexCache,
 		Flusher:       v.flusher,
+		Stat:          v.stat,
 	})
 	if err != nil {
 		return nil, err
@@ -153,6 +205,9 @@ func (v *Volume) inoOpen(ino int32, name string, flags int) (*File, error) {
 }
 
 func (v *Volume) Open(name string, flags int) (*Handle, error) {
+	v.cacheLock.Lock()
+	defer v.cacheLock.Unlock()
+
 	if fd := v.getFileInCache(name); fd != nil {
 		return fd, nil
 	}
@@ -162,6 +217,10 @@ func (v *Volume) Open(name string, flags int) (*Handle, error) {
 		return nil, logex.Trace(err)
 	}
 
+	if v.cfg.ReadOnly && flags&(os.O_CREATE|os.O_WRONLY|os.O_RDWR) != 0 {
+		return nil, ErrVolumeReadOnly.Trace(name)
+	}
+
 	if ino < 0 && !IsFileCreate(flags) {
 		return nil, ErrFileNotExist.Trace()
 	}
@@ -188,12 +247,62 @@ func (v *Volume) Open(name string, flags int) (*Handle, error) {
 	return NewHandle(fd, 0), nil
 }
 
+// stats of all the components in this volume
+func (v *Volume) Stat() *GStat {
+	return v.stat
+}
+
+// the end of the log, all the data before it is flushed
+func (v *Volume) Checkpoint() int64 {
+	return atomic.LoadInt64((*int64)(&v.header.Checkpoint))
+}
+
+func (v *Volume) Geometry() *Geometry {
+	return v.geo
+}
+
 func (v *Volume) List() []string {
 	return v.nameMap.List()
 }
 
+// the names of all the files, sorted
+func (v *Volume) Names() []string {
+	return v.nameMap.Names()
+}
+
+// the size of every file as of its lastest flushed inode, the data still
+// buffered in the files is not counted. no file is opened, so it is safe
+// to be called by other goroutines while the volume is written.
+func (v *Volume) FileSizes() map[string]int64 {
+	inos := v.nameMap.Inos()
+	ret := make(map[string]int64, len(inos))
+	for name, ino := range inos {
+		size, ok := v.sizes.Get(ino)
+		if !ok {
+			size = v.loadFileSize(ino)
+		}
+		ret[name] = size
+	}
+	return ret
+}
+
+// read the size from the InodeMap once, later flushes keep it updated
+func (v *Volume) loadFileSize(ino int32) int64 {
+	var size int64
+	inode, err := v.header.InodeMap.GetInode(ino)
+	if err == nil {
+		size = inode.StartOffset() + int64(inode.Size)
+	} else if !logex.Equal(err, ErrInodeNotFound) {
+		// try again next time
+		return 0
+	}
+	return v.sizes.Init(ino, size)
+}
+
 func (v *Volume) CleanCache() {
+	v.cacheLock.Lock()
 	v.fileCache = make(map[string]*File)
+	v.cacheLock.Unlock()
 }
 
 func (v *Volume) Close() {
@@ -201,10 +310,12 @@ func (v *Volume) Close() {
 	v.nameMap.Close()
 	v.flusher.Close()
 	v.flow.Close()
-	if err := v.header.Flush(v.delegate); err != nil {
+	if v.cfg.ReadOnly {
+		// nothing is changed
+	} else if err := v.header.Flush(v.delegate); err != nil {
 		println("volume header flush error:", err.Error())
 	}
-	Stat.Volume.CloseTime.AddNow(now)
+	v.stat.Volume.CloseTime.AddNow(now)
 }
 
 // -----------------------------------------------------------------------------
@@ -217,7 +328,7 @@ type volumeFlusherDelegate struct {
 }
 
 func (v *volumeFlusherDelegate) UpdateCheckpoint(cp int64) {
-	v.header.Checkpoint.Set(Address(cp))
+	atomic.StoreInt64((*int64)(&v.header.Checkpoint), cp)
 }
 
 // -----------------------------------------------------------------------------
@@ -225,8 +336,9 @@ func (v *volumeFlusherDelegate) UpdateCheckpoint(cp int64) {
 var _ FileDelegater = new(volumeFileDelegate)
 
 type volumeFileDelegate struct {
-	v    VolumeDelegate
-	imap *InodeMap
+	v     VolumeDelegate
+	imap  *InodeMap
+	sizes *fileSizes
 }
 
 func (v *volumeFileDelegate) GetInode(ino int32) (*Inode, error) {
@@ -237,36 +349,165 @@ func (v *volumeFileDelegate) GetInodeByAddr(addr Address) (*Inode, error) {
 	return v.imap.GetInodeByAddr(addr)
 }
 
-func (v *volumeFileDelegate) ReadData(addr ShortAddr, n int) ([]byte, error) {
-	return v.v.ReadData(int64(addr), n)
+func (v *volumeFileDelegate) GetIndexNode(addr Address) (*InodeIndexNode, error) {
+	node := new(InodeIndexNode)
+	if err := ReadDisk(v.v, node, addr); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
+func (v *volumeFileDelegate) ReadRef(r *bio.Ref, addr ShortAddr, n int) error {
+	return v.v.ReadRef(r, int64(addr), n)
+}
+
+func (v *volumeFileDelegate) Prefetch(addr ShortAddr, n int) {
+	v.v.Prefetch(int64(addr), n)
 }
 
 func (v *volumeFileDelegate) SaveInode(ino *Inode) {
 	v.imap.SaveInode(ino)
+	v.sizes.Set(int32(ino.Ino), ino.StartOffset()+int64(ino.Size))
 }
 
 // -----------------------------------------------------------------------------
 
+// fileSizes is the snapshot of the file sizes in a volume, it is updated
+// whenever the lastest inode of a file is flushed.
+type fileSizes struct {
+	m     sync.Mutex
+	sizes map[int32]int64
+}
+
+func newFileSizes() *fileSizes {
+	return &fileSizes{sizes: make(map[int32]int64, 16)}
+}
+
+func (f *fileSizes) Get(ino int32) (int64, bool) {
+	f.m.Lock()
+	size, ok := f.sizes[ino]
+	f.m.Unlock()
+	return size, ok
+}
+
+func (f *fileSizes) Set(ino int32, size int64) {
+	f.m.Lock()
+	f.sizes[ino] = size
+	f.m.Unlock()
+}
+
+// set the size only if it is unknown, a flush may happen while the
+// size is read from disk, and it must win.
+func (f *fileSizes) Init(ino int32, size int64) int64 {
+	f.m.Lock()
+	if old, ok := f.sizes[ino]; ok {
+		size = old
+	} else {
+		f.sizes[ino] = size
+	}
+	f.m.Unlock()
+	return size
+}
+
+// -----------------------------------------------------------------------------
+
+var (
+	ErrVolumeVersion         = logex.Define("volume version is not supported")
+	ErrVolumeFeature         = logex.Define("volume features are not supported")
+	ErrVolumeHeaderChecksum  = logex.Define("volume header checksum mismatch")
+	ErrVolumeHeaderCorrupted = logex.Define("no valid volume header found")
+)
+
 const (
-	VolumeHeaderSize          = 16
+	VolumeVersion = 3
+
+	// two slots written in turn, the one with the newest generation wins
+	VolumeHeaderSlotSize      = 64
+	VolumeHeaderSize          = 2 * VolumeHeaderSlotSize
 	VolumeHeaderMinCheckpoint = VolumeHeaderSize + InodeMapSize
 )
 
+const (
+	CodecRaw Int32 = iota
+)
+
+const (
+	ChecksumCRC32IEEE Int32 = iota + 1
+)
+
+// what the data on disk looks like,
+// reader must refuse the volume if any of them is not supported.
+type VolumeFeatures struct {
+	BlockBit      Int32
+	InodeBlockCnt Int32
+	Codec         Int32
+	Checksum      Int32
+}
+
+func NewVolumeFeatures(geo *Geometry) VolumeFeatures {
+	return VolumeFeatures{
+		BlockBit:      Int32(geo.BlockBit),
+		InodeBlockCnt: Int32(geo.InodeBlockCnt),
+		Codec:         CodecRaw,
+		Checksum:      ChecksumCRC32IEEE,
+	}
+}
+
+func (f VolumeFeatures) String() string {
+	return fmt.Sprintf("{blockbit: %v, inodeblk: %v, codec: %v, checksum: %v}",
+		f.BlockBit, f.InodeBlockCnt, f.Codec, f.Checksum)
+}
+
+func (f VolumeFeatures) Geometry() (*Geometry, error) {
+	if f.BlockBit < 0 {
+		return nil, ErrInvalidGeometry.Trace("blockbit:", f.BlockBit)
+	}
+	return NewGeometry(uint(f.BlockBit), int(f.InodeBlockCnt))
+}
+
+func (f VolumeFeatures) Check() error {
+	if f.Codec != CodecRaw || f.Checksum != ChecksumCRC32IEEE {
+		return ErrVolumeFeature.Trace(f.String())
+	}
+	if _, err := f.Geometry(); err != nil {
+		return ErrVolumeFeature.Trace(f.String(), err)
+	}
+	return nil
+}
+
 type VolumeHeader struct {
 	Version    Int32
+	Generation Int64
 	Checkpoint Address
+	Features   VolumeFeatures
 	InodeMap   *InodeMap
+
+	geo *Geometry
+}
+
+func (v *VolumeHeader) Geometry() *Geometry {
+	return v.geo
 }
 
 func (v *VolumeHeader) DiskSize() int {
-	return VolumeHeaderSize
+	return VolumeHeaderSlotSize
+}
+
+func (v *VolumeHeader) checksum(b []byte) uint32 {
+	return crc32.ChecksumIEEE(b[:VolumeHeaderSlotSize-4])
 }
 
 func (v *VolumeHeader) WriteDisk(b []byte) {
 	dw := NewDiskWriter(b)
 	dw.WriteMagic(v)
 	dw.WriteItem(v.Version)
+	dw.WriteItem(v.Generation)
 	dw.WriteItem(v.Checkpoint)
+	dw.WriteItem(v.Features.BlockBit)
+	dw.WriteItem(v.Features.InodeBlockCnt)
+	dw.WriteItem(v.Features.Codec)
+	dw.WriteItem(v.Features.Checksum)
+	binary.BigEndian.PutUint32(b[VolumeHeaderSlotSize-4:], v.checksum(b))
 }
 
 func (v *VolumeHeader) ReadDisk(b []byte) error {
@@ -277,18 +518,33 @@ func (v *VolumeHeader) ReadDisk(b []byte) error {
 	if err := dr.ReadItem(&v.Version); err != nil {
 		return err
 	}
-	if err := dr.ReadItem(&v.Checkpoint); err != nil {
-		return err
+	if v.Version != VolumeVersion {
+		return ErrVolumeVersion.Trace(fmt.Sprintf(
+			"got %v, want %v", v.Version, VolumeVersion))
 	}
-	return nil
+	if binary.BigEndian.Uint32(b[VolumeHeaderSlotSize-4:]) != v.checksum(b) {
+		return ErrVolumeHeaderChecksum.Trace()
+	}
+	return dr.ReadItems([]DiskReadItem{
+		&v.Generation, &v.Checkpoint,
+		&v.Features.BlockBit, &v.Features.InodeBlockCnt,
+		&v.Features.Codec, &v.Features.Checksum,
+	})
 }
 
 func (v *VolumeHeader) Magic() Magic {
 	return MagicVolume
 }
 
+func (v *VolumeHeader) slotAddr() Address {
+	return Address(v.Generation&1) * VolumeHeaderSlotSize
+}
+
+// write to the slot which is not holding the newest header
 func (v *VolumeHeader) Flush(w io.WriterAt) error {
-	if err := WriteDiskAt(w, v, 0); err != nil {
+	v.Generation++
+	if err := WriteDiskAt(w, v, v.slotAddr()); err != nil {
+		v.Generation--
 		return err
 	}
 	if err := v.InodeMap.Flush(); err != nil {
@@ -297,14 +553,17 @@ func (v *VolumeHeader) Flush(w io.WriterAt) error {
 	return nil
 }
 
-func GenNewVolumeHeader(rw bio.ReadWriterAt) (*VolumeHeader, error) {
+func GenNewVolumeHeader(rw bio.ReadWriterAt, geo *Geometry) (*VolumeHeader, error) {
 	vh := new(VolumeHeader)
-	vh.Version = 1
+	vh.Version = VolumeVersion
+	vh.Generation = 1
 	vh.Checkpoint = VolumeHeaderMinCheckpoint
-	if err := WriteDiskAt(rw, vh, 0); err != nil {
+	vh.Features = NewVolumeFeatures(geo)
+	vh.geo = geo
+	if err := WriteDiskAt(rw, vh, vh.slotAddr()); err != nil {
 		return nil, logex.Trace(err)
 	}
-	imap, err := NewInodeMap(VolumeHeaderSize, rw, true)
+	imap, err := NewInodeMap(geo, VolumeHeaderSize, rw)
 	if err != nil {
 		return nil, err
 	}
@@ -312,19 +571,67 @@ func GenNewVolumeHeader(rw bio.ReadWriterAt) (*VolumeHeader, error) {
 	return vh, nil
 }
 
+// return io.EOF if the volume is empty
 func ReadVolumeHeader(rw bio.ReadWriterAt) (*VolumeHeader, error) {
-	vh := new(VolumeHeader)
-	if err := ReadDisk(rw, vh, 0); err != nil {
-		return nil, logex.Trace(err)
-	}
-	if vh.Checkpoint < VolumeHeaderMinCheckpoint {
-		return nil, logex.NewError("invalid checkpoint:", vh.Checkpoint)
+	vh, err := readVolumeHeaderSlots(rw)
+	if err != nil {
+		return nil, err
 	}
 
-	imap, err := NewInodeMap(VolumeHeaderSize, rw, false)
+	imap, err := NewInodeMap(vh.geo, VolumeHeaderSize, rw)
 	if err != nil {
 		return nil, err
 	}
 	vh.InodeMap = imap
 	return vh, nil
 }
+
+// read the geometry of a volume without loading its InodeMap,
+// return io.EOF if the volume is empty
+func ReadVolumeGeometry(r io.ReaderAt) (*Geometry, error) {
+	vh, err := readVolumeHeaderSlots(r)
+	if err != nil {
+		return nil, err
+	}
+	return vh.Geometry(), nil
+}
+
+func readVolumeHeaderSlots(r io.ReaderAt) (*VolumeHeader, error) {
+	buf := make([]byte, VolumeHeaderSize)
+	n, err := r.ReadAt(buf, 0)
+	if err != nil && (n == 0 || !logex.Equal(err, io.EOF)) {
+		return nil, logex.Trace(err)
+	}
+
+	var (
+		vh      *VolumeHeader
+		slotErr error
+	)
+	for slot := 0; (slot+1)*VolumeHeaderSlotSize <= n; slot++ {
+		h := new(VolumeHeader)
+		if err := h.ReadDisk(buf[slot*VolumeHeaderSlotSize:]); err != nil {
+			if slotErr == nil || logex.Equal(err, ErrVolumeVersion) {
+				slotErr = err
+			}
+			continue
+		}
+		if vh == nil || h.Generation > vh.Generation {
+			vh = h
+		}
+	}
+	if vh == nil {
+		if logex.Equal(slotErr, ErrVolumeVersion) {
+			return nil, logex.Trace(slotErr)
+		}
+		return nil, ErrVolumeHeaderCorrupted.Trace(slotErr)
+	}
+
+	if err := vh.Features.Check(); err != nil {
+		return nil, logex.Trace(err)
+	}
+	if vh.Checkpoint < VolumeHeaderMinCheckpoint {
+		return nil, logex.NewError("invalid checkpoint:", vh.Checkpoint)
+	}
+	vh.geo, _ = vh.Features.Geometry()
+	return vh, nil
+}
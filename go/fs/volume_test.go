@@ -1,7 +1,9 @@
 package fs
 
 import (
+	"io"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -13,7 +15,7 @@ import (
 func TestVolume(t *testing.T) {
 	defer test.New(t)
 
-	delegate := bio.NewHybrid(test.NewMemDisk(), BlockBit)
+	delegate := bio.NewHybrid(test.NewMemDisk(), DefaultBlockBit)
 	vol, err := NewVolume(flow.New(), &VolumeConfig{
 		Delegate:      delegate,
 		FlushInterval: time.Second,
@@ -45,3 +47,229 @@ func TestVolume(t *testing.T) {
 
 	_ = vol
 }
+
+func TestVolumeFileSizes(t *testing.T) {
+	defer test.New(t)
+
+	md := test.NewMemDisk()
+	vol, err := NewVolume(flow.New(), &VolumeConfig{
+		Delegate: bio.NewHybrid(md, DefaultBlockBit),
+	})
+	test.Nil(err)
+
+	fd, err := vol.Open("a", os.O_CREATE)
+	test.Nil(err)
+	fd.Write(test.SeqBytes(100))
+	fd.Sync()
+	test.Equal(vol.FileSizes(), map[string]int64{"a": 100})
+
+	fd.Write(test.SeqBytes(50))
+	fd.Sync()
+	fd.Close()
+	test.Equal(vol.FileSizes()["a"], int64(150))
+	vol.Close()
+
+	// loaded from the InodeMap
+	vol, err = NewVolume(flow.New(), &VolumeConfig{
+		Delegate: bio.NewHybrid(md, DefaultBlockBit),
+		ReadOnly: true,
+	})
+	test.Nil(err)
+	defer vol.Close()
+	test.Equal(vol.FileSizes(), map[string]int64{"a": 150})
+}
+
+func TestVolumeHeader(t *testing.T) {
+	defer test.New(t)
+	md := test.NewMemDisk()
+
+	_, err := ReadVolumeHeader(md)
+	test.Equal(err, io.EOF)
+
+	vh, err := GenNewVolumeHeader(md, DefaultGeometry())
+	test.Nil(err)
+	vh.Checkpoint = VolumeHeaderMinCheckpoint + 1
+	test.Nil(vh.Flush(md)) // generation 2, slot A
+	vh.Checkpoint = VolumeHeaderMinCheckpoint + 2
+	test.Nil(vh.Flush(md)) // generation 3, slot B
+
+	vh2, err := ReadVolumeHeader(md)
+	test.Nil(err)
+	test.Equal(vh2.Generation, Int64(3))
+	test.Equal(vh2.Checkpoint, Address(VolumeHeaderMinCheckpoint+2))
+	test.Equal(vh2.Features, NewVolumeFeatures(DefaultGeometry()))
+
+	{ // torn write on the newest slot
+		test.WriteAt(md, []byte("torn"), VolumeHeaderSlotSize+20)
+		vh2, err := ReadVolumeHeader(md)
+		test.Nil(err)
+		test.Equal(vh2.Generation, Int64(2))
+		test.Equal(vh2.Checkpoint, Address(VolumeHeaderMinCheckpoint+1))
+
+		// the broken slot is the next one to write
+		test.Nil(vh2.Flush(md))
+		vh2, err = ReadVolumeHeader(md)
+		test.Nil(err)
+		test.Equal(vh2.Generation, Int64(3))
+	}
+
+	{ // unknown features
+		vh.Features.Codec++
+		test.Nil(vh.Flush(md))
+		_, err := ReadVolumeHeader(md)
+		test.Equal(err, ErrVolumeFeature)
+	}
+
+	{ // unsupported version
+		vh.Version = VolumeVersion + 1
+		test.Nil(vh.Flush(md))
+		test.Nil(vh.Flush(md))
+		_, err := ReadVolumeHeader(md)
+		test.Equal(err, ErrVolumeVersion)
+	}
+}
+
+func TestVolumeGeometry(t *testing.T) {
+	defer test.New(t)
+
+	geo, err := NewGeometry(MinBlockBit, 4)
+	test.Nil(err)
+	_, err = NewGeometry(MaxBlockBit+1, 4)
+	test.Equal(err, ErrInvalidGeometry)
+
+	md := test.NewMemDisk()
+	newVolume := func() *Volume {
+		vol, err := NewVolume(flow.New(), &VolumeConfig{
+			Delegate:      bio.NewHybrid(md, geo.BlockBit),
+			FlushInterval: time.Second,
+			Geometry:      geo,
+		})
+		test.Nil(err)
+		return vol
+	}
+
+	// spread over many inodes
+	buf := test.SeqBytes(geo.InodeCap*3 + geo.BlockSize/2)
+	{
+		vol := newVolume()
+		fd, err := vol.Open("hello", os.O_CREATE)
+		test.Nil(err)
+		test.Write(fd, buf)
+		fd.Sync()
+		test.Equal(fd.Size(), int64(len(buf)))
+		fd.Close()
+		vol.Close()
+	}
+
+	vol, err := NewVolume(flow.New(), &VolumeConfig{
+		Delegate: bio.NewHybrid(md, geo.BlockBit),
+	})
+	test.Nil(err)
+	defer vol.Close()
+	test.Equal(vol.Geometry(), geo)
+
+	geo2, err := ReadVolumeGeometry(md)
+	test.Nil(err)
+	test.Equal(geo2, geo)
+
+	fd, err := vol.Open("hello", 0)
+	test.Nil(err)
+	defer fd.Close()
+	test.Equal(fd.Size(), int64(len(buf)))
+	got := make([]byte, len(buf))
+	test.ReadAt(fd, got, 0)
+	test.EqualBytes(got, buf)
+}
+
+func TestVolumeReadOnly(t *testing.T) {
+	defer test.New(t)
+
+	md := test.NewMemDisk()
+	_, err := NewVolume(flow.New(), &VolumeConfig{
+		Delegate: bio.NewHybrid(md, DefaultBlockBit),
+		ReadOnly: true,
+	})
+	test.Equal(err, ErrVolumeReadOnly)
+
+	{
+		vol, err := NewVolume(flow.New(), &VolumeConfig{
+			Delegate: bio.NewHybrid(md, DefaultBlockBit),
+		})
+		test.Nil(err)
+		fd, err := vol.Open("hello", os.O_CREATE)
+		test.Nil(err)
+		test.Write(fd, []byte("hello"))
+		fd.Sync()
+		fd.Close()
+		vol.Close()
+	}
+	vh, err := ReadVolumeHeader(md)
+	test.Nil(err)
+
+	vol, err := NewVolume(flow.New(), &VolumeConfig{
+		Delegate: bio.NewHybrid(md, DefaultBlockBit),
+		ReadOnly: true,
+	})
+	test.Nil(err)
+	test.Equal(vol.Checkpoint(), int64(vh.Checkpoint))
+
+	_, err = vol.Open("hello2", os.O_CREATE)
+	test.Equal(err, ErrVolumeReadOnly)
+	_, err = vol.Open("hello", os.O_RDWR)
+	test.Equal(err, ErrVolumeReadOnly)
+	fd, err := vol.Open("hello", 0)
+	test.Nil(err)
+	test.ReadStringAt(fd, 0, "hello")
+	fd.Close()
+	vol.Close()
+
+	vh2, err := ReadVolumeHeader(md)
+	test.Nil(err)
+	test.Equal(vh2.Generation, vh.Generation)
+}
+
+func TestVolumeSourceStriped(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+	dirs := []string{
+		filepath.Join(root, "a"), filepath.Join(root, "b"), filepath.Join(root, "c"),
+	}
+	test.Equal(SplitDirs(" "+dirs[0]+", "+dirs[1]+",,"+dirs[2]), dirs)
+
+	vs, err := NewVolumeSource(dirs, nil)
+	test.Nil(err)
+	vol, err := NewVolume(flow.New(), &VolumeConfig{Delegate: vs})
+	test.Nil(err)
+	buf := test.RandBytes(9 << 20)
+	fd, err := vol.Open("hello", os.O_CREATE)
+	test.Nil(err)
+	test.Write(fd, buf)
+	fd.Sync()
+	fd.Close()
+	vol.Close()
+	vs.Close()
+	for _, dir := range dirs {
+		test.True(bio.IsStriped(dir))
+	}
+
+	vs, err = OpenVolumeSource(dirs, nil)
+	test.Nil(err)
+	vol, err = NewVolume(flow.New(), &VolumeConfig{Delegate: vs})
+	test.Nil(err)
+	fd, err = vol.Open("hello", 0)
+	test.Nil(err)
+	got := make([]byte, len(buf))
+	test.ReadAt(fd, got, 0)
+	test.EqualBytes(got, buf)
+	fd.Close()
+	vol.Close()
+	vs.Close()
+
+	_, err = OpenVolumeSource(dirs[:2], nil)
+	test.Equal(err, bio.ErrStripedMemberMissing)
+	_, err = OpenVolumeSourceReadOnly(dirs[1:2])
+	test.Equal(err, bio.ErrStripedMemberMissing)
+	test.Nil(os.RemoveAll(dirs[2]))
+	_, err = OpenVolumeSource(dirs, nil)
+	test.Equal(err, bio.ErrStripedMemberMissing)
+}
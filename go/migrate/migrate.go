@@ -0,0 +1,256 @@
+package migrate
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/allmad/madq/go/fs"
+	"github.com/allmad/madq/internal/bio"
+	"github.com/allmad/madq/internal/lfs"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+)
+
+var (
+	ErrDestLarger = logex.Define("the file in destination is larger than the source")
+	ErrMismatch   = logex.Define("the file in destination is not equal to the source")
+)
+
+// the lfs volume is flushed after copying FlushSize bytes, so an
+// interrupted migration loses this much at most.
+const FlushSize = 4 << 20
+
+const bufSize = 64 << 10
+
+type Config struct {
+	From string `desc:"the directory of the go/fs volume, or the directories separated by comma, it's opened read-only"`
+	To   string `desc:"the directory of the lfs volume, it's created if not exists"`
+}
+
+func (c *Config) FlaglyDesc() string {
+	return "migrate the files of a go/fs volume into an lfs volume"
+}
+
+func (c *Config) FlaglyHandle(f *flow.Flow) error {
+	defer f.Close()
+
+	if c.From == "" || c.To == "" {
+		return fmt.Errorf("error: from and to are required")
+	}
+
+	vs, err := fs.OpenVolumeSourceReadOnly(fs.SplitDirs(c.From))
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	src, err := fs.NewVolume(f, &fs.VolumeConfig{
+		Delegate: vs,
+		ReadOnly: true,
+	})
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	blk, err := bio.NewFile(c.To)
+	if err != nil {
+		return err
+	}
+	defer blk.Close()
+	dst, err := lfs.NewVolume(f, blk)
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	r, err := Migrate(src, dst, os.Stderr)
+	if err != nil {
+		return err
+	}
+	println(r.String())
+	return nil
+}
+
+type Report struct {
+	Files    int
+	Copied   int
+	Resumed  int
+	Skipped  int
+	Bytes    int64
+	Duration time.Duration
+}
+
+func (r *Report) String() string {
+	return fmt.Sprintf("migrated %v files (%v copied, %v resumed, %v skipped), %v bytes in %v",
+		r.Files, r.Copied, r.Resumed, r.Skipped, r.Bytes, r.Duration)
+}
+
+// Migrate copies the files in src to dst with their mtime, and verifies
+// them byte by byte. the progress is written to progress if not nil.
+//
+// a file is done once its mtime is set in dst, so an interrupted migration
+// can be restarted: the files done are skipped and the partial ones are
+// continued.
+func Migrate(src *fs.Volume, dst *lfs.Volume, progress io.Writer) (*Report, error) {
+	start := time.Now()
+	names := src.Names()
+	r := &Report{Files: len(names)}
+	for idx, name := range names {
+		state, n, err := migrateFile(src, dst, name)
+		if err != nil {
+			return nil, logex.Trace(err, name)
+		}
+		switch state {
+		case stateCopied:
+			r.Copied++
+		case stateResumed:
+			r.Resumed++
+		case stateSkipped:
+			r.Skipped++
+		}
+		r.Bytes += n
+		if progress != nil {
+			fmt.Fprintf(progress, "[%v/%v] %v: %v, %v bytes\n",
+				idx+1, len(names), name, state, n)
+		}
+	}
+	r.Duration = time.Since(start)
+	return r, nil
+}
+
+type fileState string
+
+const (
+	stateCopied  fileState = "copied"
+	stateResumed fileState = "resumed"
+	stateSkipped fileState = "skipped"
+)
+
+// source file, fd is nil if nothing is written
+type srcFile struct {
+	fd    *fs.Handle
+	size  int64
+	mtime time.Time
+}
+
+func openSrc(vol *fs.Volume, name string) (*srcFile, error) {
+	fd, err := vol.Open(name, 0)
+	if logex.Equal(err, fs.ErrInodeNotFound) {
+		// go/fs has no mtime for it, use the time of migration
+		return &srcFile{mtime: time.Now()}, nil
+	}
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	inode, err := fd.Stat()
+	if err != nil {
+		fd.Close()
+		return nil, logex.Trace(err)
+	}
+	return &srcFile{
+		fd:    fd,
+		size:  fd.Size(),
+		mtime: inode.Mtime.Get(),
+	}, nil
+}
+
+func (s *srcFile) ReadAt(b []byte, off int64) (int, error) {
+	if s.fd == nil {
+		return 0, io.EOF
+	}
+	return s.fd.ReadAt(b, off)
+}
+
+func (s *srcFile) Close() {
+	if s.fd != nil {
+		s.fd.Close()
+	}
+}
+
+// return the bytes copied
+func migrateFile(src *fs.Volume, dst *lfs.Volume, name string) (fileState, int64, error) {
+	s, err := openSrc(src, name)
+	if err != nil {
+		return "", 0, err
+	}
+	defer s.Close()
+
+	mtime, err := dst.Mtime(name)
+	if err != nil && !logex.Equal(err, lfs.ErrVolumeFileNotExists) {
+		return "", 0, logex.Trace(err)
+	}
+	state := stateCopied
+	if err == nil {
+		state = stateResumed
+	}
+
+	fd, err := dst.OpenFile(name, true)
+	if err != nil {
+		return "", 0, logex.Trace(err)
+	}
+	defer fd.Close()
+
+	off := fd.Size()
+	if !mtime.IsZero() && off == s.size {
+		return stateSkipped, 0, nil
+	}
+	if off > s.size {
+		return "", 0, ErrDestLarger.Trace(off, s.size)
+	}
+
+	buf := make([]byte, bufSize)
+	copied := s.size - off
+	written := 0
+	for off < s.size {
+		// go/fs doesn't read across the end of the file
+		if s.size-off < int64(len(buf)) {
+			buf = buf[:s.size-off]
+		}
+		n, err := s.ReadAt(buf, off)
+		if n == 0 && err != nil {
+			return "", 0, logex.Trace(err)
+		}
+		if _, err := fd.Write(buf[:n]); err != nil {
+			return "", 0, logex.Trace(err)
+		}
+		off += int64(n)
+		written += n
+		if written >= FlushSize {
+			fd.Flush()
+			written = 0
+		}
+	}
+
+	if err := verify(s, fd, s.size); err != nil {
+		return "", 0, err
+	}
+	if err := dst.SetMtime(name, s.mtime); err != nil {
+		return "", 0, logex.Trace(err)
+	}
+	fd.Flush()
+	return state, copied, nil
+}
+
+// compare the first size bytes of a and b
+func verify(a, b io.ReaderAt, size int64) error {
+	bufA := make([]byte, bufSize)
+	bufB := make([]byte, bufSize)
+	for off := int64(0); off < size; off += int64(len(bufA)) {
+		if size-off < int64(len(bufA)) {
+			bufA, bufB = bufA[:size-off], bufB[:size-off]
+		}
+		if _, err := a.ReadAt(bufA, off); err != nil {
+			return logex.Trace(err, off)
+		}
+		if _, err := b.ReadAt(bufB, off); err != nil {
+			return logex.Trace(err, off)
+		}
+		if !bytes.Equal(bufA, bufB) {
+			return ErrMismatch.Trace(off)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package migrate
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/allmad/madq/go/fs"
+	"github.com/allmad/madq/go/fs/fstest"
+	"github.com/allmad/madq/internal/bio"
+	"github.com/allmad/madq/internal/lfs"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+var testFiles = map[string]int{
+	"a":     100,
+	"b":     300 << 10,
+	"log.1": 3000,
+	"log.2": 0,
+}
+
+func testSrcVolume(dir string) (*fs.Volume, func()) {
+	vol, closer := fstest.OpenVolume(dir)
+	fstest.WriteFiles(vol, testFiles)
+	return vol, closer
+}
+
+func testDstVolume(dir string) (*lfs.Volume, func()) {
+	blk, err := bio.NewFile(dir)
+	test.Nil(err)
+	vol, err := lfs.NewVolume(flow.New(), blk)
+	test.Nil(err)
+	return vol, func() {
+		vol.Close()
+		blk.Close()
+	}
+}
+
+func testCheckDst(dst *lfs.Volume, src *fs.Volume) {
+	test.Equal(dst.List(), []string{"a", "b", "log.1", "log.2"})
+	for name, size := range testFiles {
+		fd, err := dst.OpenFile(name, false)
+		test.Nil(err)
+		test.Equal(fd.Size(), int64(size))
+		buf := make([]byte, size)
+		test.ReadAt(fd, buf, 0)
+		test.EqualBytes(buf, test.SeqBytes(size))
+		fd.Close()
+
+		mtime, err := dst.Mtime(name)
+		test.Nil(err)
+		test.False(mtime.IsZero())
+		if size > 0 {
+			fd, err := src.Open(name, 0)
+			test.Nil(err)
+			inode, err := fd.Stat()
+			test.Nil(err)
+			fd.Close()
+			test.True(mtime.Equal(inode.Mtime.Get()))
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+	defer os.RemoveAll(root)
+
+	src, srcCloser := testSrcVolume(root + "/src")
+	defer srcCloser()
+
+	dst, closer := testDstVolume(root + "/dst")
+	r, err := Migrate(src, dst, nil)
+	test.Nil(err)
+	test.Equal(r.Files, len(testFiles))
+	test.Equal(r.Copied, len(testFiles))
+	test.Equal(r.Bytes, int64(100+300<<10+3000))
+	closer()
+
+	// reopen, and nothing to do
+	dst, closer = testDstVolume(root + "/dst")
+	defer closer()
+	testCheckDst(dst, src)
+	r, err = Migrate(src, dst, nil)
+	test.Nil(err)
+	test.Equal(r.Skipped, len(testFiles))
+	test.Equal(r.Bytes, int64(0))
+}
+
+func TestMigrateResume(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+	defer os.RemoveAll(root)
+
+	src, srcCloser := testSrcVolume(root + "/src")
+	defer srcCloser()
+
+	// interrupted in the middle of "b", and "log.1" is copied without
+	// the mtime set
+	dst, closer := testDstVolume(root + "/dst")
+	{
+		fd, err := dst.OpenFile("b", true)
+		test.Nil(err)
+		test.Write(fd, test.SeqBytes(100<<10))
+		fd.Close()
+		fd, err = dst.OpenFile("log.1", true)
+		test.Nil(err)
+		test.Write(fd, test.SeqBytes(3000))
+		fd.Close()
+	}
+	closer()
+
+	dst, closer = testDstVolume(root + "/dst")
+	defer closer()
+	r, err := Migrate(src, dst, nil)
+	test.Nil(err)
+	test.Equal(r.Copied, 2)
+	test.Equal(r.Resumed, 2)
+	test.Equal(r.Bytes, int64(100+200<<10))
+	testCheckDst(dst, src)
+}
+
+func TestMigrateMismatch(t *testing.T) {
+	defer test.New(t)
+	root := test.Root()
+	defer os.RemoveAll(root)
+
+	src, srcCloser := testSrcVolume(root + "/src")
+	defer srcCloser()
+
+	dst, closer := testDstVolume(root + "/dst")
+	defer closer()
+	fd, err := dst.OpenFile("a", true)
+	test.Nil(err)
+	test.Write(fd, make([]byte, 50))
+	fd.Close()
+
+	_, err = Migrate(src, dst, nil)
+	test.Equal(err, ErrMismatch)
+
+	fd, err = dst.OpenFile("b", true)
+	test.Nil(err)
+	test.Write(fd, make([]byte, 400<<10))
+	fd.Close()
+	test.Nil(dst.Remove("a"))
+	_, err = Migrate(src, dst, nil)
+	test.Equal(err, ErrDestLarger)
+}
+
+func TestVerify(t *testing.T) {
+	defer test.New(t)
+	a := test.SeqBytes(bufSize + 10)
+	b := append([]byte(nil), a...)
+	test.Nil(verify(bytes.NewReader(a), bytes.NewReader(b), int64(len(a))))
+	b[bufSize+5]++
+	test.Equal(verify(bytes.NewReader(a), bytes.NewReader(b), int64(len(a))), ErrMismatch)
+	test.Nil(verify(bytes.NewReader(a), bytes.NewReader(b), bufSize))
+}
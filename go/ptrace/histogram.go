@@ -0,0 +1,146 @@
+package ptrace
+
+import (
+	"fmt"
+	"math/bits"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// 2^histSubBit sub-buckets in every power of 2, about 3% precision
+	histSubBit  = 5
+	histSub     = 1 << histSubBit
+	histBuckets = (64 - histSubBit) * histSub
+)
+
+// Histogram records durations in log-linear buckets like HdrHistogram,
+// it's safe to be used by multiple goroutines without lock.
+type Histogram struct {
+	counts [histBuckets]int64
+	count  int64
+	sum    int64
+	max    int64
+}
+
+func histIdx(v int64) int {
+	if v < histSub {
+		return int(v)
+	}
+	shift := bits.Len64(uint64(v)) - histSubBit - 1
+	return shift*histSub + int(v>>uint(shift))
+}
+
+// the highest value which is in the same bucket
+func histValue(idx int) int64 {
+	if idx < histSub {
+		return int64(idx)
+	}
+	shift := uint(idx/histSub - 1)
+	top := int64(idx - int(shift)*histSub)
+	return (top+1)<<shift - 1
+}
+
+func (h *Histogram) AddNow(t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	h.Add(time.Now().Sub(t))
+}
+
+func (h *Histogram) Add(d time.Duration) {
+	v := int64(d)
+	if v < 0 {
+		v = 0
+	}
+	atomic.AddInt64(&h.counts[histIdx(v)], 1)
+	atomic.AddInt64(&h.sum, v)
+	atomic.AddInt64(&h.count, 1)
+	for {
+		max := atomic.LoadInt64(&h.max)
+		if v <= max || atomic.CompareAndSwapInt64(&h.max, max, v) {
+			break
+		}
+	}
+}
+
+func (h *Histogram) Count() int64 {
+	return atomic.LoadInt64(&h.count)
+}
+
+func (h *Histogram) Sum() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.sum))
+}
+
+func (h *Histogram) Max() time.Duration {
+	return time.Duration(atomic.LoadInt64(&h.max))
+}
+
+func (h *Histogram) Mean() time.Duration {
+	count := h.Count()
+	if count == 0 {
+		return 0
+	}
+	return h.Sum() / time.Duration(count)
+}
+
+// p: in [0, 1]
+func (h *Histogram) Percentile(p float64) time.Duration {
+	var counts [histBuckets]int64
+	total := int64(0)
+	for idx := range counts {
+		counts[idx] = atomic.LoadInt64(&h.counts[idx])
+		total += counts[idx]
+	}
+	if total == 0 {
+		return 0
+	}
+
+	target := int64(p*float64(total) + 0.5)
+	if target < 1 {
+		target = 1
+	}
+	max := h.Max()
+	seen := int64(0)
+	for idx, c := range counts {
+		seen += c
+		if seen >= target {
+			if v := time.Duration(histValue(idx)); v < max {
+				return v
+			}
+			break
+		}
+	}
+	return max
+}
+
+// h = cur - prev, prev can be nil
+func (h *Histogram) delta(cur, prev *Histogram) {
+	for idx := range h.counts {
+		h.counts[idx] = atomic.LoadInt64(&cur.counts[idx])
+	}
+	h.count = atomic.LoadInt64(&cur.count)
+	h.sum = atomic.LoadInt64(&cur.sum)
+	h.max = atomic.LoadInt64(&cur.max)
+	if prev == nil {
+		return
+	}
+	for idx := range h.counts {
+		h.counts[idx] -= atomic.LoadInt64(&prev.counts[idx])
+	}
+	h.count -= atomic.LoadInt64(&prev.count)
+	h.sum -= atomic.LoadInt64(&prev.sum)
+}
+
+func (h *Histogram) String() string {
+	if h.Count() == 0 {
+		return "NaN"
+	}
+	return fmt.Sprintf("p50: %v, p90: %v, p99: %v, p999: %v, max: %v (%v/%v)",
+		h.Percentile(0.5), h.Percentile(0.9),
+		h.Percentile(0.99), h.Percentile(0.999),
+		h.Max(), h.Sum(), h.Count(),
+	)
+}
+
+func (h *Histogram) MarshalJSON() ([]byte, error) { return strJSON(h.String()) }
@@ -0,0 +1,67 @@
+package ptrace
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chzyer/test"
+)
+
+func TestHistogramIdx(t *testing.T) {
+	defer test.New(t)
+	last := -1
+	for _, v := range []int64{0, 1, histSub - 1, histSub, 2 * histSub, 1000, 1 << 40, 1<<63 - 1} {
+		idx := histIdx(v)
+		test.True(idx > last)
+		test.True(idx < histBuckets)
+		test.True(histValue(idx) >= v)
+		// precision
+		test.True(float64(histValue(idx)-v) <= float64(v)/histSub)
+		last = idx
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	defer test.New(t)
+	var h Histogram
+	test.Equal(h.String(), "NaN")
+	test.Equal(h.Percentile(0.99), time.Duration(0))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 1; j <= 100; j++ {
+				h.Add(time.Duration(i*100+j) * time.Microsecond)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	test.Equal(h.Count(), int64(1000))
+	test.Equal(h.Max(), time.Millisecond)
+	test.Equal(h.Mean(), 500500*time.Nanosecond)
+	for _, item := range []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0.5, 500 * time.Microsecond},
+		{0.9, 900 * time.Microsecond},
+		{0.99, 990 * time.Microsecond},
+		{0.999, 999 * time.Microsecond},
+		{1, time.Millisecond},
+	} {
+		got := h.Percentile(item.p)
+		test.True(got >= item.want)
+		test.True(float64(got-item.want) <= float64(item.want)/histSub)
+	}
+
+	ret, err := json.Marshal(&h)
+	test.Nil(err)
+	var str string
+	test.Nil(json.Unmarshal(ret, &str))
+	test.Equal(str, h.String())
+}
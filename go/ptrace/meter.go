@@ -0,0 +1,114 @@
+package ptrace
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// the longest window of Meter in seconds
+const MeterWindow = 60
+
+var meterWindows = []int{1, 10, MeterWindow}
+
+// Meter counts events in per-second buckets, and gives the moving rates
+// over the last 1s/10s/60s. only completed seconds are counted in rates.
+type Meter struct {
+	m     sync.Mutex
+	total int64
+	// one more slot for the current second
+	slots [MeterWindow + 1]meterSlot
+}
+
+type meterSlot struct {
+	sec int64
+	n   int64
+}
+
+func (m *Meter) Mark(n int64) {
+	m.mark(n, time.Now().Unix())
+}
+
+func (m *Meter) MarkInt(n int) {
+	m.Mark(int64(n))
+}
+
+func (m *Meter) mark(n int64, sec int64) {
+	slot := &m.slots[sec%int64(len(m.slots))]
+	m.m.Lock()
+	if slot.sec != sec {
+		slot.sec = sec
+		slot.n = 0
+	}
+	slot.n += n
+	m.total += n
+	m.m.Unlock()
+}
+
+func (m *Meter) Total() int64 {
+	m.m.Lock()
+	ret := m.total
+	m.m.Unlock()
+	return ret
+}
+
+// the sum of last n completed seconds
+func (m *Meter) sum(n int, now int64) int64 {
+	if n > MeterWindow {
+		n = MeterWindow
+	}
+	ret := int64(0)
+	m.m.Lock()
+	for sec := now - int64(n); sec < now; sec++ {
+		slot := &m.slots[sec%int64(len(m.slots))]
+		if slot.sec == sec {
+			ret += slot.n
+		}
+	}
+	m.m.Unlock()
+	return ret
+}
+
+// window is rounded down to seconds, at most MeterWindow
+func (m *Meter) PerSecond(window time.Duration) float64 {
+	n := int(window / time.Second)
+	if n <= 0 {
+		return 0
+	}
+	if n > MeterWindow {
+		n = MeterWindow
+	}
+	return float64(m.sum(n, time.Now().Unix())) / float64(n)
+}
+
+// treat the events as bytes, it's empty if window is less than 1s
+func (m *Meter) Rate(window time.Duration) Rate {
+	n := int(window / time.Second)
+	if n <= 0 {
+		return Rate{}
+	}
+	if n > MeterWindow {
+		n = MeterWindow
+	}
+	return Rate{
+		Size:     Size(m.sum(n, time.Now().Unix())),
+		Duration: time.Duration(n) * time.Second,
+	}
+}
+
+func (m *Meter) snapshot(src *Meter) {
+	src.m.Lock()
+	m.total = src.total
+	m.slots = src.slots
+	src.m.Unlock()
+}
+
+func (m *Meter) MarshalJSON() ([]byte, error) {
+	ret := make(map[string]interface{}, len(meterWindows)+1)
+	for _, w := range meterWindows {
+		d := time.Duration(w) * time.Second
+		ret[d.String()] = m.PerSecond(d)
+	}
+	ret["total"] = m.Total()
+	return json.Marshal(ret)
+}
@@ -0,0 +1,72 @@
+package ptrace
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chzyer/test"
+)
+
+func TestMeter(t *testing.T) {
+	defer test.New(t)
+	var m Meter
+	now := int64(1000)
+	for i := int64(0); i < 20; i++ {
+		m.mark(i, now-20+i)
+		m.mark(1, now-20+i)
+	}
+	m.mark(100, now) // not completed yet
+
+	test.Equal(m.Total(), int64(20+190+100))
+	test.Equal(m.sum(1, now), int64(20))
+	test.Equal(m.sum(10, now), int64(10+(10+19)*10/2))
+	test.Equal(m.sum(MeterWindow, now), int64(20+190))
+	test.Equal(m.sum(MeterWindow*2, now), int64(20+190))
+
+	// the slots are reused after a window
+	now += MeterWindow + 1
+	m.mark(3, now-1)
+	test.Equal(m.sum(MeterWindow, now), int64(3))
+	test.Equal(m.Total(), int64(20+190+100+3))
+
+	var snap Meter
+	snap.snapshot(&m)
+	test.Equal(snap.sum(1, now), int64(3))
+	test.Equal(snap.Total(), m.Total())
+}
+
+func TestMeterConcurrent(t *testing.T) {
+	defer test.New(t)
+	var m Meter
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				m.MarkInt(2)
+			}
+		}()
+	}
+	wg.Wait()
+	test.Equal(m.Total(), int64(8*1000*2))
+	test.True(m.PerSecond(MeterWindow*time.Second) <= float64(m.Total()))
+	test.Equal(m.PerSecond(0), float64(0))
+	test.Equal(m.Rate(500*time.Millisecond), Rate{})
+	test.Equal(Rate{}.String(), "0B in 0s (0B/S)")
+
+	ret, err := json.Marshal(&m)
+	test.Nil(err)
+	var obj map[string]float64
+	test.Nil(json.Unmarshal(ret, &obj))
+	test.Equal(obj["total"], float64(16000))
+	_, ok := obj["10s"]
+	test.True(ok)
+
+	rate := Rate{Size: 2 << 20, Duration: time.Second}
+	ret, err = json.Marshal(rate)
+	test.Nil(err)
+	test.Equal(string(ret), `"`+rate.String()+`"`)
+}
@@ -0,0 +1,179 @@
+package ptrace
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+	"sync/atomic"
+	"time"
+	"unicode"
+)
+
+const OpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+var histQuantiles = []float64{0.5, 0.9, 0.99, 0.999}
+
+// WriteOpenMetrics walks the struct obj by reflection and writes all the
+// ptrace types in it as OpenMetrics text, metric names are the snake case
+// of the field path joined by "_".
+//
+// Int and Size are counters, Ratio is two counters (hits and events),
+// RatioTime, RatioSize and Histogram are summaries, Meter is a counter and
+// a gauge of the moving rates labeled by window.
+func WriteOpenMetrics(w io.Writer, prefix string, obj interface{}) error {
+	v := reflect.ValueOf(obj)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	} else {
+		// make it addressable
+		nv := reflect.New(v.Type()).Elem()
+		nv.Set(v)
+		v = nv
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("openmetrics: struct is required, got %v", v.Type())
+	}
+
+	om := &openMetrics{w: bufio.NewWriter(w)}
+	om.walk(prefix, v)
+	om.printf("# EOF\n")
+	if om.err != nil {
+		return om.err
+	}
+	return om.w.Flush()
+}
+
+type OpenMetricsHandler struct {
+	prefix string
+	obj    interface{}
+}
+
+func NewOpenMetricsHandler(prefix string, obj interface{}) *OpenMetricsHandler {
+	return &OpenMetricsHandler{prefix, obj}
+}
+
+func (h *OpenMetricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", OpenMetricsContentType)
+	if err := WriteOpenMetrics(w, h.prefix, h.obj); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// -----------------------------------------------------------------------------
+
+type openMetrics struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (om *openMetrics) printf(format string, obj ...interface{}) {
+	if om.err != nil {
+		return
+	}
+	_, om.err = fmt.Fprintf(om.w, format, obj...)
+}
+
+func (om *openMetrics) family(name, typ, unit string) {
+	om.printf("# TYPE %v %v\n", name, typ)
+	if unit != "" {
+		om.printf("# UNIT %v %v\n", name, unit)
+	}
+}
+
+func (om *openMetrics) sample(name string, value float64) {
+	om.printf("%v %v\n", name, strconv.FormatFloat(value, 'g', -1, 64))
+}
+
+func (om *openMetrics) counter(name, unit string, value float64) {
+	om.family(name, "counter", unit)
+	om.sample(name+"_total", value)
+}
+
+func (om *openMetrics) summary(name string, sum float64, count int64) {
+	om.sample(name+"_sum", sum)
+	om.sample(name+"_count", float64(count))
+}
+
+func (om *openMetrics) walk(name string, v reflect.Value) {
+	switch obj := v.Addr().Interface().(type) {
+	case *Int:
+		om.counter(name, "", float64(obj.Load()))
+	case *Size:
+		om.counter(name+"_bytes", "bytes", float64(obj.Load()))
+	case *Ratio:
+		om.counter(name+"_hits", "", float64(obj.Value.Load()))
+		om.counter(name+"_events", "", float64(obj.Count.Load()))
+	case *RatioTime:
+		name += "_seconds"
+		duration, count := obj.Load()
+		om.family(name, "summary", "seconds")
+		om.summary(name, duration.Seconds(), count)
+	case *RatioSize:
+		name += "_bytes"
+		size, count := obj.Load()
+		om.family(name, "summary", "bytes")
+		om.summary(name, float64(size), count)
+	case *Histogram:
+		om.family(name+"_seconds", "summary", "seconds")
+		for _, q := range histQuantiles {
+			om.printf("%v_seconds{quantile=\"%v\"} %v\n", name, q,
+				strconv.FormatFloat(obj.Percentile(q).Seconds(), 'g', -1, 64))
+		}
+		om.summary(name+"_seconds", obj.Sum().Seconds(), obj.Count())
+		om.family(name+"_max_seconds", "gauge", "seconds")
+		om.sample(name+"_max_seconds", obj.Max().Seconds())
+	case *Meter:
+		om.counter(name, "", float64(obj.Total()))
+		om.family(name+"_rate", "gauge", "")
+		for _, w := range meterWindows {
+			om.printf("%v_rate{window=\"%vs\"} %v\n", name, w,
+				strconv.FormatFloat(obj.PerSecond(time.Duration(w)*time.Second), 'g', -1, 64))
+		}
+	case *time.Duration:
+		om.family(name+"_seconds", "gauge", "seconds")
+		om.sample(name+"_seconds", time.Duration(atomic.LoadInt64((*int64)(obj))).Seconds())
+	default:
+		switch v.Kind() {
+		case reflect.Struct:
+			t := v.Type()
+			for i := 0; i < t.NumField(); i++ {
+				if t.Field(i).PkgPath != "" {
+					continue
+				}
+				om.walk(name+"_"+snakeCase(t.Field(i).Name), v.Field(i))
+			}
+		case reflect.Int64:
+			om.family(name, "gauge", "")
+			om.sample(name, float64(loadInt64(v)))
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32:
+			om.family(name, "gauge", "")
+			om.sample(name, float64(v.Int()))
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			om.family(name, "gauge", "")
+			om.sample(name, float64(v.Uint()))
+		case reflect.Float32, reflect.Float64:
+			om.family(name, "gauge", "")
+			om.sample(name, v.Float())
+		}
+	}
+}
+
+// RawWrite => raw_write, InoIdxHit => ino_idx_hit, CPU => cpu
+func snakeCase(s string) string {
+	r := []rune(s)
+	ret := make([]rune, 0, len(r)+4)
+	for idx, c := range r {
+		if idx > 0 && unicode.IsUpper(c) {
+			prev := r[idx-1]
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && idx+1 < len(r) && unicode.IsLower(r[idx+1])) {
+				ret = append(ret, '_')
+			}
+		}
+		ret = append(ret, unicode.ToLower(c))
+	}
+	return string(ret)
+}
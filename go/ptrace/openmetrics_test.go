@@ -0,0 +1,184 @@
+package ptrace
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chzyer/test"
+)
+
+var (
+	omMetaRegexp   = regexp.MustCompile(`^# (TYPE|UNIT) ([a-z_][a-z0-9_]*) ([a-z]+)$`)
+	omSampleRegexp = regexp.MustCompile(`^([a-z_][a-z0-9_]*)(\{(?:quantile="[0-9.]+"|window="[0-9]+s")\})? (\S+)$`)
+)
+
+// parse the exposition and return samples, checks:
+// 1. every sample belongs to the family declared right before it
+// 2. families are unique
+// 3. ends with # EOF
+func parseOpenMetrics(b []byte) (map[string]float64, error) {
+	samples := make(map[string]float64)
+	families := make(map[string]string)
+	family := ""
+	eof := false
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		line := s.Text()
+		if eof {
+			return nil, fmt.Errorf("content after EOF: %v", line)
+		}
+		if line == "# EOF" {
+			eof = true
+			continue
+		}
+		if m := omMetaRegexp.FindStringSubmatch(line); m != nil {
+			if m[1] == "TYPE" {
+				if _, ok := families[m[2]]; ok {
+					return nil, fmt.Errorf("duplicate family: %v", m[2])
+				}
+				families[m[2]] = m[3]
+				family = m[2]
+			} else if m[2] != family || !strings.HasSuffix(family, "_"+m[3]) {
+				return nil, fmt.Errorf("invalid unit: %v", line)
+			}
+			continue
+		}
+		m := omSampleRegexp.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("invalid line: %v", line)
+		}
+		suffix := strings.TrimPrefix(m[1], family)
+		switch families[family] {
+		case "counter":
+			if suffix != "_total" {
+				return nil, fmt.Errorf("invalid counter sample: %v", line)
+			}
+		case "summary":
+			if suffix != "" && suffix != "_sum" && suffix != "_count" {
+				return nil, fmt.Errorf("invalid summary sample: %v", line)
+			}
+		case "gauge":
+			if suffix != "" {
+				return nil, fmt.Errorf("invalid gauge sample: %v", line)
+			}
+		}
+		if !strings.HasPrefix(m[1], family) {
+			return nil, fmt.Errorf("sample %v out of family %v", m[1], family)
+		}
+		value, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			return nil, err
+		}
+		samples[m[1]+m[2]] = value
+	}
+	if !eof {
+		return nil, fmt.Errorf("missing # EOF")
+	}
+	return samples, nil
+}
+
+type testOMStat struct {
+	Flush struct {
+		Count    Int
+		Size     Size
+		Hit      Ratio
+		Time     RatioTime
+		BufSize  RatioSize
+		RawWrite Histogram
+		Written  Meter
+	}
+	IOCount int
+	private Int
+}
+
+func TestOpenMetrics(t *testing.T) {
+	defer test.New(t)
+	test.Equal(snakeCase("RawWrite"), "raw_write")
+	test.Equal(snakeCase("InoIdxHit"), "ino_idx_hit")
+	test.Equal(snakeCase("IOCount"), "io_count")
+
+	var stat testOMStat
+	stat.Flush.Count.Add(3)
+	stat.Flush.Size.Add(1024)
+	stat.Flush.Hit.HitIf(true)
+	stat.Flush.Hit.HitIf(false)
+	stat.Flush.Time.AddNow(time.Now().Add(-time.Second))
+	stat.Flush.BufSize.AddInt(10)
+	stat.Flush.RawWrite.Add(time.Millisecond)
+	stat.Flush.Written.Mark(5)
+	stat.IOCount = 7
+
+	rec := httptest.NewRecorder()
+	NewOpenMetricsHandler("madq", &stat).ServeHTTP(rec, nil)
+	test.Equal(rec.Header().Get("Content-Type"), OpenMetricsContentType)
+
+	samples, err := parseOpenMetrics(rec.Body.Bytes())
+	test.Nil(err)
+	for name, value := range map[string]float64{
+		"madq_flush_count_total":                        3,
+		"madq_flush_size_bytes_total":                   1024,
+		"madq_flush_hit_hits_total":                     1,
+		"madq_flush_hit_events_total":                   2,
+		"madq_flush_time_seconds_count":                 1,
+		"madq_flush_buf_size_bytes_sum":                 10,
+		"madq_flush_buf_size_bytes_count":               1,
+		"madq_flush_raw_write_seconds_count":            1,
+		"madq_flush_raw_write_max_seconds":              0.001,
+		`madq_flush_raw_write_seconds{quantile="0.99"}`: 0.001,
+		"madq_io_count":                                 7,
+		"madq_flush_written_total":                      5,
+		`madq_flush_written_rate{window="60s"}`:         0,
+	} {
+		got, ok := samples[name]
+		test.True(ok)
+		test.Equal(got, value)
+	}
+	test.True(samples["madq_flush_time_seconds_sum"] >= 1)
+	test.Equal(len(samples), 20)
+
+	test.NotNil(WriteOpenMetrics(new(bytes.Buffer), "madq", 1))
+}
+
+func TestOpenMetricsConcurrent(t *testing.T) {
+	defer test.New(t)
+	var stat testOMStat
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				stat.Flush.Count.Add(1)
+				stat.Flush.Size.Add(1)
+				stat.Flush.Hit.Hit()
+				stat.Flush.Time.AddNow(time.Now())
+				stat.Flush.BufSize.AddInt(1)
+			}
+		}()
+	}
+
+	// readers must not race with the writers
+	for i := 0; i < 10; i++ {
+		test.Nil(WriteOpenMetrics(new(bytes.Buffer), "madq", &stat))
+		_ = stat.Flush.Time.String()
+		_ = stat.Flush.BufSize.String()
+		_, err := stat.Flush.Hit.MarshalJSON()
+		test.Nil(err)
+	}
+	wg.Wait()
+
+	duration, count := stat.Flush.Time.Load()
+	test.Equal(count, int64(4000))
+	test.True(duration >= 0)
+	size, count := stat.Flush.BufSize.Load()
+	test.Equal(size, int64(4000))
+	test.Equal(count, int64(4000))
+}
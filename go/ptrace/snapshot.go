@@ -0,0 +1,75 @@
+package ptrace
+
+import (
+	"fmt"
+	"reflect"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Snapshot copies the struct src into dst, counters are loaded atomically.
+// dst and src must be pointers to the same struct type.
+func Snapshot(dst, src interface{}) {
+	delta(checkSameStruct(dst, src), reflect.ValueOf(src).Elem(), reflect.Value{})
+}
+
+// Delta sets dst to cur - prev field by field, for rate calculations.
+// the max of Histogram can't be subtracted, it is the one in cur,
+// so does Meter.
+func Delta(dst, cur, prev interface{}) {
+	checkSameStruct(dst, prev)
+	delta(checkSameStruct(dst, cur),
+		reflect.ValueOf(cur).Elem(), reflect.ValueOf(prev).Elem())
+}
+
+func checkSameStruct(dst, src interface{}) reflect.Value {
+	dt, st := reflect.TypeOf(dst), reflect.TypeOf(src)
+	if dt != st || dt.Kind() != reflect.Ptr || dt.Elem().Kind() != reflect.Struct {
+		panic(fmt.Sprintf("ptrace: require pointers to the same struct, got %v and %v", dt, st))
+	}
+	return reflect.ValueOf(dst).Elem()
+}
+
+func loadInt64(v reflect.Value) int64 {
+	return atomic.LoadInt64((*int64)(unsafe.Pointer(v.UnsafeAddr())))
+}
+
+// prev is invalid for snapshot
+func delta(dst, cur, prev reflect.Value) {
+	if h, ok := dst.Addr().Interface().(*Histogram); ok {
+		var p *Histogram
+		if prev.IsValid() {
+			p = prev.Addr().Interface().(*Histogram)
+		}
+		h.delta(cur.Addr().Interface().(*Histogram), p)
+		return
+	}
+	// the rates are windowed already
+	if m, ok := dst.Addr().Interface().(*Meter); ok {
+		m.snapshot(cur.Addr().Interface().(*Meter))
+		return
+	}
+
+	switch dst.Kind() {
+	case reflect.Struct:
+		t := dst.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			var p reflect.Value
+			if prev.IsValid() {
+				p = prev.Field(i)
+			}
+			delta(dst.Field(i), cur.Field(i), p)
+		}
+	case reflect.Int64:
+		n := loadInt64(cur)
+		if prev.IsValid() {
+			n -= loadInt64(prev)
+		}
+		dst.SetInt(n)
+	default:
+		dst.Set(cur)
+	}
+}
@@ -16,19 +16,24 @@ func (r *RatioTime) AddNow(t time.Time) {
 		return
 	}
 
-	r.Duration += time.Now().Sub(t)
+	atomic.AddInt64((*int64)(&r.Duration), int64(time.Now().Sub(t)))
 	r.Count.Add(1)
 }
 
+func (r *RatioTime) Load() (time.Duration, int64) {
+	return time.Duration(atomic.LoadInt64((*int64)(&r.Duration))), r.Count.Load()
+}
+
 func (r *RatioTime) String() string {
-	if r.Count == 0 {
+	duration, count := r.Load()
+	if count == 0 {
 		return "NaN"
 	}
 
 	return fmt.Sprintf("%v (%v/%v)",
-		r.Duration/time.Duration(r.Count),
-		r.Duration,
-		r.Count,
+		duration/time.Duration(count),
+		duration,
+		count,
 	)
 }
 
@@ -54,13 +59,18 @@ func (r *RatioSize) Add(s int64) {
 	r.Count.Add(1)
 }
 
-func (r RatioSize) String() string {
-	if r.Count == 0 {
+func (r *RatioSize) Load() (int64, int64) {
+	return r.Size.Load(), r.Count.Load()
+}
+
+func (r *RatioSize) String() string {
+	size, count := r.Load()
+	if count == 0 {
 		return "NaN"
 	}
-	ratio := float64(r.Size) / float64(r.Count)
+	ratio := float64(size) / float64(count)
 	return fmt.Sprintf("%v (%v/%v)",
-		Unit(int64(ratio)), r.Size, r.Count)
+		Unit(int64(ratio)), Size(size), count)
 }
 
 func (r *RatioSize) MarshalJSON() ([]byte, error) {
@@ -97,11 +107,12 @@ func (r *Ratio) Miss() {
 }
 
 func (r *Ratio) MarshalJSON() ([]byte, error) {
-	if r.Count == 0 {
+	value, count := r.Value.Load(), r.Count.Load()
+	if count == 0 {
 		return strJSON("NaN")
 	}
 	return strJSON(fmt.Sprintf("%.2f%%(%v/%v)",
-		float64(r.Value*100)/float64(r.Count), r.Value, r.Count))
+		float64(value*100)/float64(count), value, count))
 }
 
 type Int int64
@@ -114,6 +125,10 @@ func (i *Int) Add(n int64) int64 {
 	return atomic.AddInt64((*int64)(i), n)
 }
 
+func (i *Int) Load() int64 {
+	return atomic.LoadInt64((*int64)(i))
+}
+
 // -----------------------------------------------------------------------------
 
 type Size int64
@@ -130,12 +145,16 @@ func (s *Size) Add(n int64) int64 {
 	return (*Int)(s).Add(n)
 }
 
+func (s *Size) Load() int64 {
+	return (*Int)(s).Load()
+}
+
 func (i Size) String() string {
 	return Unit(int64(i))
 }
 
 func (i *Size) MarshalJSON() ([]byte, error) {
-	return strJSON(i.String())
+	return strJSON(Size(i.Load()).String())
 }
 
 // -----------------------------------------------------------------------------
@@ -145,8 +164,15 @@ type Rate struct {
 	Duration time.Duration
 }
 
+func (i Rate) MarshalJSON() ([]byte, error) {
+	return strJSON(i.String())
+}
+
 func (i Rate) String() string {
-	speed := Size(int64(float64(i.Size) / i.Duration.Seconds()))
+	speed := Size(0)
+	if i.Duration > 0 {
+		speed = Size(int64(float64(i.Size) / i.Duration.Seconds()))
+	}
 
 	return fmt.Sprintf("%v in %v (%v/S)",
 		i.Size.String(), i.Duration.String(),
@@ -0,0 +1,78 @@
+package serve
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/allmad/madq/go/fs"
+	"github.com/allmad/madq/go/ptrace"
+	"github.com/allmad/madq/go/top"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+)
+
+type Config struct {
+	Dir       string `type:"[0]" desc:"volume directory, or the directories separated by comma which the volume is striped over"`
+	Metrics   string `desc:"serve OpenMetrics of the volume stats and the endpoint of madq top on this address, e.g. :9100"`
+	Cache     int    `default:"32" desc:"the capacity of the block cache in MB"`
+	ReadAhead int    `default:"4" desc:"the blocks prefetched for sequential reads, -1 disables it"`
+}
+
+func (c *Config) FlaglyDesc() string {
+	return "serve a volume"
+}
+
+func (c *Config) FlaglyHandle(f *flow.Flow) error {
+	defer f.Close()
+
+	if c.Dir == "" {
+		return fmt.Errorf("error: directory is required")
+	}
+	if c.Cache <= 0 {
+		return fmt.Errorf("error: cache must be positive, got %v", c.Cache)
+	}
+
+	vs, err := fs.OpenVolumeSource(fs.SplitDirs(c.Dir), nil)
+	if err != nil {
+		return err
+	}
+	defer vs.Close()
+	vs.SetCacheSize(int64(c.Cache) << 20)
+
+	vol, err := fs.NewVolume(f, &fs.VolumeConfig{
+		Delegate:  vs,
+		ReadAhead: c.ReadAhead,
+	})
+	if err != nil {
+		return err
+	}
+	defer vol.Close()
+
+	if c.Metrics != "" {
+		ln, err := ListenMetrics(c.Metrics, vol)
+		if err != nil {
+			return err
+		}
+		defer ln.Close()
+		logex.Info("serving metrics on", ln.Addr())
+	}
+
+	<-f.IsClose()
+	return nil
+}
+
+// ListenMetrics serves the stats of vol at /metrics in OpenMetrics format,
+// and the samples for madq top at /top
+func ListenMetrics(addr string, vol *fs.Volume) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", ptrace.NewOpenMetricsHandler("madq_fs", vol.Stat()))
+	mux.Handle("/top", top.NewHandler(vol))
+	go http.Serve(ln, mux)
+	return ln, nil
+}
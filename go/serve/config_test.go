@@ -0,0 +1,74 @@
+package serve
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/allmad/madq/go/bio"
+	"github.com/allmad/madq/go/fs"
+	"github.com/allmad/madq/go/ptrace"
+	"github.com/allmad/madq/go/top"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+func TestConfigCache(t *testing.T) {
+	defer test.New(t)
+	dir := test.Root()
+	defer os.RemoveAll(dir)
+
+	for _, size := range []int{0, -1} {
+		cfg := &Config{Dir: dir, Cache: size}
+		test.NotNil(cfg.FlaglyHandle(flow.New()))
+	}
+}
+
+func TestListenMetrics(t *testing.T) {
+	defer test.New(t)
+	vol, err := fs.NewVolume(flow.New(), &fs.VolumeConfig{
+		Delegate: bio.NewHybrid(test.NewMemDisk(), fs.DefaultBlockBit),
+	})
+	test.Nil(err)
+	defer vol.Close()
+
+	ln, err := ListenMetrics("127.0.0.1:0", vol)
+	test.Nil(err)
+	defer ln.Close()
+
+	resp, err := http.Get("http://" + ln.Addr().String() + "/metrics")
+	test.Nil(err)
+	defer resp.Body.Close()
+	test.Equal(resp.Header.Get("Content-Type"), ptrace.OpenMetricsContentType)
+	body, err := ioutil.ReadAll(resp.Body)
+	test.Nil(err)
+	test.True(strings.Contains(string(body), "# TYPE madq_fs_flusher_flush_raw_write_seconds summary\n"))
+	test.True(strings.HasSuffix(string(body), "# EOF\n"))
+}
+
+func TestListenTop(t *testing.T) {
+	defer test.New(t)
+	vol, err := fs.NewVolume(flow.New(), &fs.VolumeConfig{
+		Delegate: bio.NewHybrid(test.NewMemDisk(), fs.DefaultBlockBit),
+	})
+	test.Nil(err)
+	defer vol.Close()
+	fd, err := vol.Open("hello", os.O_CREATE)
+	test.Nil(err)
+	test.Write(fd, []byte("hello"))
+	fd.Sync()
+	fd.Close()
+
+	ln, err := ListenMetrics("127.0.0.1:0", vol)
+	test.Nil(err)
+	defer ln.Close()
+
+	s, err := top.NewHTTPSource(ln.Addr().String()).Sample()
+	test.Nil(err)
+	test.True(s.Live)
+	test.Equal(s.Files["hello"], int64(5))
+	test.Equal(s.Checkpoint, vol.Checkpoint())
+	test.True(s.FlushBytes > 0)
+}
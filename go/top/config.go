@@ -0,0 +1,66 @@
+package top
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+)
+
+type Config struct {
+	Dir      string `type:"[0]" desc:"open the volume in this directory read-only, or in the directories separated by comma. a running volume is shown as it was when last closed, use addr to watch it"`
+	Addr     string `desc:"attach to the metrics address of a running madq serve, e.g. localhost:9100"`
+	Interval int    `default:"1" desc:"refresh interval in seconds"`
+	Files    int    `default:"5" desc:"the number of files in each list"`
+	Count    int    `desc:"exit after refreshing count times, 0 means forever"`
+}
+
+func (c *Config) FlaglyDesc() string {
+	return "live dashboard of a volume"
+}
+
+func (c *Config) FlaglyHandle(f *flow.Flow) error {
+	defer f.Close()
+
+	var source Source
+	switch {
+	case c.Addr != "":
+		source = NewHTTPSource(c.Addr)
+	case c.Dir != "":
+		source = NewDirSource(c.Dir)
+	default:
+		return fmt.Errorf("error: directory or addr is required")
+	}
+	defer source.Close()
+	if c.Interval <= 0 {
+		c.Interval = 1
+	}
+
+	ticker := time.NewTicker(time.Duration(c.Interval) * time.Second)
+	defer ticker.Stop()
+
+	var prev *Sample
+	for i := 0; c.Count <= 0 || i < c.Count; i++ {
+		if i > 0 {
+			select {
+			case <-ticker.C:
+			case <-f.IsClose():
+				return nil
+			}
+		}
+
+		cur, err := source.Sample()
+		if err != nil {
+			// keep watching, the server may be restarting
+			logex.Error(err)
+			continue
+		}
+		if err := Render(os.Stdout, source.String(), prev, cur, c.Files); err != nil {
+			return err
+		}
+		prev = cur
+	}
+	return nil
+}
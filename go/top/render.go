@@ -0,0 +1,125 @@
+package top
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/allmad/madq/go/ptrace"
+)
+
+const (
+	ansiClear = "\x1b[H\x1b[2J"
+	ansiBold  = "\x1b[1m"
+	ansiReset = "\x1b[0m"
+)
+
+type fileStat struct {
+	name string
+	size int64
+	rate float64
+}
+
+// Render draws one screen of the dashboard, prev can be nil on the
+// first refresh, then all the rates are unknown.
+// n: the number of files in each list
+func Render(w io.Writer, source string, prev, cur *Sample, n int) error {
+	buf := bytes.NewBuffer(nil)
+	buf.WriteString(ansiClear)
+	fmt.Fprintf(buf, "%vmadq top - %v - %v%v\n", ansiBold, source,
+		cur.Time.Format("15:04:05"), ansiReset)
+	if !cur.Live {
+		buf.WriteString("(read-only, showing the last persisted state)\n")
+	}
+	fmt.Fprintf(buf, "checkpoint: %v (%v)\n\n", cur.Checkpoint, ptrace.Size(cur.Checkpoint))
+
+	var d time.Duration
+	if prev != nil {
+		d = cur.Time.Sub(prev.Time)
+	}
+	perSecond := func(get func(*Sample) int64) float64 {
+		if d <= 0 {
+			return -1
+		}
+		return float64(get(cur)-get(prev)) / d.Seconds()
+	}
+	rateSize := func(get func(*Sample) int64) string {
+		r := perSecond(get)
+		if r < 0 {
+			return "-"
+		}
+		return ptrace.Size(r).String() + "/S"
+	}
+	live := func(s string) string {
+		if !cur.Live {
+			return "-"
+		}
+		return s
+	}
+
+	write := func(s *Sample) int64 { return s.FlushBytes }
+	if !cur.Live {
+		write = func(s *Sample) int64 { return s.Checkpoint }
+	}
+	fmt.Fprintf(buf, "write: %v, read: %v, records: %v\n",
+		rateSize(write),
+		live(rateSize(func(s *Sample) int64 { return s.ReadBytes })),
+		live(rateCount(perSecond(func(s *Sample) int64 { return s.Records }))),
+	)
+
+	batch := "-"
+	if prev != nil && cur.FlushCount > prev.FlushCount {
+		batch = ptrace.Size((cur.FlushBytes - prev.FlushBytes) /
+			(cur.FlushCount - prev.FlushCount)).String()
+	}
+	fmt.Fprintf(buf, "flush: %v, batch: %v\n",
+		live(rateCount(perSecond(func(s *Sample) int64 { return s.FlushCount }))), live(batch))
+	fmt.Fprintf(buf, "cobuffer: %v\n", live(ptrace.Size(cur.Buffered).String()))
+	fmt.Fprintf(buf, "inode cache: next %v, inoidx %v\n\n",
+		live(cur.NextHit.String()), live(cur.InoIdxHit.String()))
+
+	files := make([]fileStat, 0, len(cur.Files))
+	for name, size := range cur.Files {
+		st := fileStat{name: name, size: size, rate: -1}
+		if d > 0 {
+			st.rate = float64(size-prev.Files[name]) / d.Seconds()
+		}
+		files = append(files, st)
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].size != files[j].size {
+			return files[i].size > files[j].size
+		}
+		return files[i].name < files[j].name
+	})
+	fmt.Fprintf(buf, "%v%-32v %12v%v\n", ansiBold, "LARGEST", "SIZE", ansiReset)
+	for idx := 0; idx < n && idx < len(files); idx++ {
+		fmt.Fprintf(buf, "%-32v %12v\n", files[idx].name, ptrace.Size(files[idx].size))
+	}
+
+	if d > 0 {
+		sort.Slice(files, func(i, j int) bool {
+			if files[i].rate != files[j].rate {
+				return files[i].rate > files[j].rate
+			}
+			return files[i].name < files[j].name
+		})
+		fmt.Fprintf(buf, "\n%v%-32v %12v%v\n", ansiBold, "FASTEST GROWING", "RATE", ansiReset)
+		for idx := 0; idx < n && idx < len(files) && files[idx].rate > 0; idx++ {
+			fmt.Fprintf(buf, "%-32v %10v/S\n", files[idx].name, ptrace.Size(files[idx].rate))
+		}
+	}
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func rateCount(r float64) string {
+	if r < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f/S", r)
+}
@@ -0,0 +1,206 @@
+package top
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/allmad/madq/go/fs"
+	"github.com/allmad/madq/go/ptrace"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
+)
+
+// Sample is what the dashboard shows at one moment, the counters are
+// totals since the volume is opened, rates come from two samples.
+type Sample struct {
+	Time time.Time
+	// false if the stats of the owner are not available,
+	// only the persisted state can be shown.
+	Live       bool
+	Checkpoint int64
+
+	FlushBytes int64
+	FlushCount int64
+	Records    int64
+	ReadBytes  int64
+	Buffered   int64
+
+	NextHit   Ratio
+	InoIdxHit Ratio
+
+	Files map[string]int64
+}
+
+type Ratio struct {
+	Hit   int64
+	Total int64
+}
+
+func newRatio(r *ptrace.Ratio) Ratio {
+	return Ratio{
+		Hit:   atomic.LoadInt64((*int64)(&r.Value)),
+		Total: atomic.LoadInt64((*int64)(&r.Count)),
+	}
+}
+
+func (r Ratio) String() string {
+	if r.Total == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f%% (%v/%v)", float64(r.Hit)*100/float64(r.Total), r.Hit, r.Total)
+}
+
+// NewSample only reads the stats and the file size snapshot of vol, so it
+// can be called from any goroutine while the owner is writing.
+func NewSample(vol *fs.Volume, live bool) *Sample {
+	stat := vol.Stat()
+	s := &Sample{
+		Time:       time.Now(),
+		Live:       live,
+		Checkpoint: vol.Checkpoint(),
+		FlushBytes: stat.Throughput.FlushBytes.Total(),
+		FlushCount: atomic.LoadInt64((*int64)(&stat.Flusher.Flush.Count)),
+		Records:    stat.Throughput.Records.Total(),
+		ReadBytes:  stat.Throughput.ReadBytes.Total(),
+		Buffered:   atomic.LoadInt64(&stat.Cobuffer.Buffered),
+		NextHit:    newRatio(&stat.Inode.Cache.NextHit),
+		InoIdxHit:  newRatio(&stat.Inode.Cache.InoIdxHit),
+		Files:      vol.FileSizes(),
+	}
+	return s
+}
+
+// -----------------------------------------------------------------------------
+
+type Source interface {
+	Sample() (*Sample, error)
+	String() string
+	Close()
+}
+
+// HTTPSource attaches to the endpoint of a running server
+type HTTPSource struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPSource(addr string) *HTTPSource {
+	return &HTTPSource{
+		url:    "http://" + addr + "/top",
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (s *HTTPSource) String() string { return s.url }
+
+func (s *HTTPSource) Close() {}
+
+func (s *HTTPSource) Sample() (*Sample, error) {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return nil, logex.Trace(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%v: %v", s.url, resp.Status)
+	}
+	var ret Sample
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return nil, logex.Trace(err)
+	}
+	return &ret, nil
+}
+
+// DirSource opens the volume read-only, so it can be used on a volume
+// which is owned by another process. the volume is kept open between
+// samples, and reopened only if the header on disk is flushed since then.
+//
+// the owner persists the checkpoint and the InodeMap only when the volume
+// is closed, so a volume which is still running is shown as it was when
+// last closed. use HTTPSource to watch the progress of a running server.
+type DirSource struct {
+	dir string
+
+	flow       *flow.Flow
+	vs         *fs.VolumeSource
+	vol        *fs.Volume
+	generation int64
+}
+
+func NewDirSource(dir string) *DirSource {
+	return &DirSource{dir: dir}
+}
+
+func (s *DirSource) String() string { return s.dir }
+
+func (s *DirSource) Sample() (*Sample, error) {
+	if s.vol != nil {
+		generation, err := s.vs.HeaderGeneration()
+		if err != nil || generation != s.generation {
+			s.Close()
+		}
+	}
+	if s.vol == nil {
+		if err := s.open(); err != nil {
+			return nil, err
+		}
+	}
+	return NewSample(s.vol, false), nil
+}
+
+func (s *DirSource) open() error {
+	vs, err := fs.OpenVolumeSourceReadOnly(fs.SplitDirs(s.dir))
+	if err != nil {
+		return err
+	}
+	// read before the volume, a flush in between is found next time
+	generation, err := vs.HeaderGeneration()
+	if err != nil {
+		vs.Close()
+		return err
+	}
+
+	f := flow.New()
+	vol, err := fs.NewVolume(f, &fs.VolumeConfig{
+		Delegate: vs,
+		ReadOnly: true,
+	})
+	if err != nil {
+		f.Close()
+		vs.Close()
+		return err
+	}
+	s.flow, s.vs, s.vol, s.generation = f, vs, vol, generation
+	return nil
+}
+
+func (s *DirSource) Close() {
+	if s.vol == nil {
+		return
+	}
+	s.vol.Close()
+	s.flow.Close()
+	s.vs.Close()
+	s.flow, s.vs, s.vol = nil, nil, nil
+}
+
+// -----------------------------------------------------------------------------
+
+// Handler serves the Sample of vol in json
+type Handler struct {
+	vol *fs.Volume
+}
+
+func NewHandler(vol *fs.Volume) *Handler {
+	return &Handler{vol}
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(NewSample(h.vol, true)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
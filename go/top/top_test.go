@@ -0,0 +1,142 @@
+package top
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/allmad/madq/go/fs/fstest"
+	"github.com/chzyer/test"
+)
+
+func TestDirSource(t *testing.T) {
+	defer test.New(t)
+	dir := test.Root()
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	_, err := NewDirSource(dir).Sample()
+	test.NotNil(err)
+
+	fstest.WriteVolume([]string{dir}, map[string]int{"a": 100, "b": 2000})
+	source := NewDirSource(dir)
+	defer source.Close()
+	prev, err := source.Sample()
+	test.Nil(err)
+	test.False(prev.Live)
+	test.Equal(prev.Files["a"], int64(100))
+	test.Equal(prev.Files["b"], int64(2000))
+	test.True(prev.Checkpoint > 3000)
+
+	// kept open if nothing is flushed
+	vol := source.vol
+	_, err = source.Sample()
+	test.Nil(err)
+	test.True(source.vol == vol)
+
+	// the volume is not changed by the source
+	fstest.WriteVolume([]string{dir}, map[string]int{"c": 300})
+	cur, err := source.Sample()
+	test.Nil(err)
+	test.True(source.vol != vol)
+	test.Equal(cur.Files["a"], int64(100))
+	test.Equal(cur.Files["c"], int64(300))
+	test.True(cur.Checkpoint > prev.Checkpoint)
+}
+
+func TestHandlerConcurrent(t *testing.T) {
+	defer test.New(t)
+	dir := test.Root()
+	os.RemoveAll(dir)
+	defer os.RemoveAll(dir)
+
+	vol, closer := fstest.OpenVolume(dir)
+	defer closer()
+
+	server := httptest.NewServer(NewHandler(vol))
+	defer server.Close()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				resp, err := server.Client().Get(server.URL)
+				test.Nil(err)
+				var s Sample
+				test.Nil(json.NewDecoder(resp.Body).Decode(&s))
+				resp.Body.Close()
+			}
+		}()
+	}
+
+	// the owner keeps creating and writing files meanwhile
+	for _, name := range []string{"a", "b", "c", "d"} {
+		fstest.WriteFile(vol, name, test.SeqBytes(100))
+	}
+	wg.Wait()
+
+	s, err := NewHTTPSource(strings.TrimPrefix(server.URL, "http://")).Sample()
+	test.Nil(err)
+	test.True(s.Live)
+	test.Equal(s.Files, map[string]int64{"a": 100, "b": 100, "c": 100, "d": 100})
+}
+
+func TestRender(t *testing.T) {
+	defer test.New(t)
+	now := time.Now()
+	prev := &Sample{
+		Time:       now,
+		Live:       true,
+		FlushBytes: 1000,
+		FlushCount: 1,
+		Files:      map[string]int64{"a": 10, "b": 500},
+	}
+	cur := &Sample{
+		Time:       now.Add(2 * time.Second),
+		Live:       true,
+		Checkpoint: 4096,
+		FlushBytes: 5000,
+		FlushCount: 3,
+		Records:    20,
+		NextHit:    Ratio{Hit: 3, Total: 4},
+		Files:      map[string]int64{"a": 2010, "b": 600, "c": 1},
+	}
+
+	buf := bytes.NewBuffer(nil)
+	test.Nil(Render(buf, "test", nil, prev, 5))
+	test.True(strings.HasPrefix(buf.String(), ansiClear))
+	test.False(strings.Contains(buf.String(), "FASTEST"))
+
+	buf.Reset()
+	test.Nil(Render(buf, "test", prev, cur, 2))
+	out := buf.String()
+	for _, s := range []string{
+		"checkpoint: 4096",
+		"records: 10.0/S",
+		"flush: 1.0/S",
+		"next 75.0% (3/4)",
+		"inoidx -",
+	} {
+		test.True(strings.Contains(out, s))
+	}
+
+	largest := out[strings.Index(out, "LARGEST"):strings.Index(out, "FASTEST")]
+	test.True(strings.Index(largest, "\na ") < strings.Index(largest, "\nb "))
+	test.False(strings.Contains(largest, "\nc "))
+
+	fastest := out[strings.Index(out, "FASTEST"):]
+	test.True(strings.Index(fastest, "\na ") < strings.Index(fastest, "\nb "))
+
+	cur.Live = false
+	buf.Reset()
+	test.Nil(Render(buf, "test", prev, cur, 2))
+	test.True(strings.Contains(buf.String(), "read-only"))
+	test.True(strings.Contains(buf.String(), "read: -"))
+}
@@ -1,136 +1,340 @@
 package bio
 
 import (
+	"io"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/chzyer/flow"
+	"github.com/chzyer/logex"
 )
 
-type DeviceMgr struct {
-	flow *flow.Flow
+var (
+	ErrDeviceMgrClosed = logex.Define("device manager is closed")
+)
+
+// the size of one of the two buffers
+const SegmentSize = 8 << 20
+
+// segment is a buffer of the data in [start, start+off) on device
+type segment struct {
+	start int64
+	buf   []byte
+	// allocated bytes
+	off int
+	// the writers which are not done
+	pending int
+	// closed when it's going to be flushed
+	sealed chan struct{}
+	// closed when it's on disk
+	flushed chan struct{}
+}
+
+func newSegment(start int64, buf []byte) *segment {
+	return &segment{
+		start:   start,
+		buf:     buf,
+		sealed:  make(chan struct{}),
+		flushed: make(chan struct{}),
+	}
+}
+
+func (s *segment) end() int64 {
+	return s.start + int64(s.off)
+}
 
-	dev    *Device
-	wg     sync.WaitGroup
+// copy the data in [off, off+len(b)) which is in s
+func (s *segment) copyTo(b []byte, off int64) {
+	from, to := off, off+int64(len(b))
+	if from < s.start {
+		from = s.start
+	}
+	if to > s.end() {
+		to = s.end()
+	}
+	if from >= to {
+		return
+	}
+	copy(b[from-off:to-off], s.buf[from-s.start:to-s.start])
+}
+
+type DeviceMgrStats struct {
+	// the number of segments written to disk
+	Flushes int64
+	Bytes   int64
+	// the number of times the active buffer is full while the other one is
+	// still flushing
+	Waits int64
+}
+
+// DeviceMgr is a group-commit allocator on a RawDisker. writers reserve
+// space in the active buffer and write to it, while the other buffer is
+// being flushed. the writes are flushed in one WriteAt per buffer.
+//
+// after a buffer is flushed, setOff is set to the end of the data on disk.
+type DeviceMgr struct {
+	flow   *flow.Flow
+	raw    RawDisker
 	setOff *int64
-	offset int64
+	size   int
 
-	flushChan     chan struct{}
-	needFlushChan chan struct{}
+	m    sync.Mutex
+	cond *sync.Cond
+	// the buffer writers reserve space in
+	active *segment
+	// the buffer being flushed, nil if none
+	flushing *segment
+	// the buffer which is not used
+	spare []byte
+	err   error
 
-	m sync.Mutex
+	flushChan chan *segment
+	quit      chan struct{}
+
+	stats DeviceMgrStats
 }
 
-func NewDeviceMgr(f *flow.Flow, d *Device, setOff *int64) *DeviceMgr {
+func NewDeviceMgr(f *flow.Flow, raw RawDisker, setOff *int64) *DeviceMgr {
+	return NewDeviceMgrEx(f, raw, setOff, SegmentSize)
+}
+
+// NewDeviceMgrEx starts at *setOff, with two buffers in size.
+func NewDeviceMgrEx(f *flow.Flow, raw RawDisker, setOff *int64, size int) *DeviceMgr {
 	dm := &DeviceMgr{
-		dev:           d,
-		setOff:        setOff,
-		offset:        d.Offset(),
-		flushChan:     make(chan struct{}),
-		needFlushChan: make(chan struct{}, 1),
+		raw:       raw,
+		setOff:    setOff,
+		size:      size,
+		active:    newSegment(atomic.LoadInt64(setOff), make([]byte, size)),
+		spare:     make([]byte, size),
+		flushChan: make(chan *segment, 1),
+		quit:      make(chan struct{}),
 	}
+	dm.cond = sync.NewCond(&dm.m)
 	f.ForkTo(&dm.flow, dm.Close)
+	// added before the loop runs, or a Close in between doesn't wait for it
+	dm.flow.Add(1)
+	go dm.loop()
 	return dm
 }
 
 func (d *DeviceMgr) loop() {
-	d.flow.Add(1)
 	defer d.flow.DoneAndClose()
 
 	ticker := time.NewTicker(100 * time.Millisecond)
 	defer ticker.Stop()
 
-loop:
 	for {
 		select {
+		case seg := <-d.flushChan:
+			d.flush(seg)
 		case <-ticker.C:
-			d.Flush()
-		case <-d.needFlushChan:
-			d.Flush()
-		case <-d.flow.IsClose():
-			break loop
+			d.m.Lock()
+			if d.active.off > 0 && d.flushing == nil {
+				d.sealLocked()
+			}
+			d.m.Unlock()
+		case <-d.quit:
+			return
 		}
 	}
 }
 
-func (d *DeviceMgr) Close() {
-	d.flow.Close()
-	d.Flush()
-}
-
-// when client received signal, it's time to Done writing
-// and try GetFlushNotify() to get a new one
-func (d *DeviceMgr) GetFlushNotify() chan struct{} {
+// write seg to disk after all the writers of it are done
+func (d *DeviceMgr) flush(seg *segment) {
 	d.m.Lock()
-	ret := d.flushChan
-	d.wg.Add(1)
+	for seg.pending > 0 {
+		d.cond.Wait()
+	}
 	d.m.Unlock()
-	return ret
-}
 
-func (d *DeviceMgr) Malloc(n int) int64 {
+	_, err := d.raw.WriteAt(seg.buf[:seg.off], seg.start)
+
 	d.m.Lock()
+	if err != nil {
+		// the checkpoint can't go over it
+		if d.err == nil {
+			d.err = logex.Trace(err)
+		}
+	} else if d.err == nil {
+		atomic.StoreInt64(d.setOff, seg.end())
+		atomic.AddInt64(&d.stats.Flushes, 1)
+		atomic.AddInt64(&d.stats.Bytes, int64(seg.off))
+	}
+	d.spare = seg.buf
+	d.flushing = nil
+	close(seg.flushed)
+	d.cond.Broadcast()
 	d.m.Unlock()
+}
 
-	ret := atomic.AddInt64(&d.offset, int64(n))
-	if ret > d.dev.FlushSize() {
-		select {
-		case d.needFlushChan <- struct{}{}:
-		default:
+// hand the active buffer to loop() and switch to the spare one, wait if
+// the spare one is still flushing.
+func (d *DeviceMgr) sealLocked() *segment {
+	if d.flushing != nil {
+		atomic.AddInt64(&d.stats.Waits, 1)
+		for d.flushing != nil {
+			d.cond.Wait()
 		}
 	}
-	return ret - int64(n)
+
+	seg := d.active
+	close(seg.sealed)
+	d.flushing = seg
+	d.active = newSegment(seg.end(), d.spare)
+	d.spare = nil
+	d.flushChan <- seg
+	return seg
+}
+
+func (d *DeviceMgr) Close() {
+	if !d.flow.MarkExit() {
+		return
+	}
+	if err := d.Flush(); err != nil {
+		logex.Error(err)
+	}
+	d.m.Lock()
+	if d.err == nil {
+		d.err = ErrDeviceMgrClosed.Trace()
+	}
+	d.m.Unlock()
+	close(d.quit)
+	d.flow.Close()
 }
 
 type DeviceWriter struct {
 	*Writer
-	d   *DeviceMgr
-	off int64
+	d    *DeviceMgr
+	seg  *segment
+	off  int64
+	done int32
 }
 
 func (d *DeviceWriter) Offset() int64 {
 	return d.off
 }
 
-func (d *DeviceMgr) MallocWriter(n int) *DeviceWriter {
-	off := d.Malloc(n)
-	return &DeviceWriter{
-		Writer: d.dev.GetWriter(off, n),
-		d:      d,
-		off:    off,
-	}
+// Sealed is closed when the buffer is going to be flushed, the writer
+// should be Done as soon as possible.
+func (d *DeviceWriter) Sealed() <-chan struct{} {
+	return d.seg.sealed
 }
 
-func (d *DeviceMgr) WriteDisk(off int64, disk Diskable) {
-	disk.WriteDisk(d.dev.GetWriter(off, disk.Size()))
+// Done marks the writing is finished, the buffer can't be flushed until
+// all of its writers are done.
+func (d *DeviceWriter) Done() {
+	if !atomic.CompareAndSwapInt32(&d.done, 0, 1) {
+		return
+	}
+	d.d.m.Lock()
+	d.seg.pending--
+	if d.seg.pending == 0 {
+		d.d.cond.Broadcast()
+	}
+	d.d.m.Unlock()
 }
 
-func (d *DeviceMgr) Done() {
-	d.wg.Done()
+// MallocWriter reserves n bytes, and it blocks if both of the buffers are
+// full. the writer must be Done after writing.
+func (d *DeviceMgr) MallocWriter(n int) (*DeviceWriter, error) {
+	if n > d.size {
+		return nil, ErrDeviceBufOverflow.Trace(n, d.size)
+	}
+
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.err != nil {
+		return nil, d.err
+	}
+	if d.active.off+n > len(d.active.buf) {
+		d.sealLocked()
+	}
+
+	seg := d.active
+	off := seg.off
+	seg.off += n
+	seg.pending++
+	return &DeviceWriter{
+		Writer: NewWriter(seg.buf[off : off+n]),
+		d:      d,
+		seg:    seg,
+		off:    seg.start + int64(off),
+	}, nil
 }
 
+// Flush writes all the data allocated before to disk. the concurrent
+// callers share one write.
 func (d *DeviceMgr) Flush() error {
 	d.m.Lock()
-	defer d.m.Unlock()
+	seg := d.flushing
+	if d.active.off > 0 && d.err == nil {
+		seg = d.sealLocked()
+	}
+	d.m.Unlock()
+
+	if seg != nil {
+		<-seg.flushed
+	}
 
-	// notify all done with writing
-	ch := d.flushChan
-	d.flushChan = make(chan struct{})
-	close(ch)
+	d.m.Lock()
+	err := d.err
+	d.m.Unlock()
+	if logex.Equal(err, ErrDeviceMgrClosed) {
+		return nil
+	}
+	return err
+}
 
-	d.wg.Wait()
-	if err := d.dev.Flush(); err != nil {
-		return err
+func (d *DeviceMgr) Stats() DeviceMgrStats {
+	return DeviceMgrStats{
+		Flushes: atomic.LoadInt64(&d.stats.Flushes),
+		Bytes:   atomic.LoadInt64(&d.stats.Bytes),
+		Waits:   atomic.LoadInt64(&d.stats.Waits),
 	}
-	atomic.StoreInt64(d.setOff, d.dev.Offset())
-	return nil
 }
 
 func (d *DeviceMgr) Raw() RawDisker {
-	return d.dev.Raw()
+	return d.raw
 }
 
+// ReadAt reads the data allocated, from the buffers or disk.
 func (d *DeviceMgr) ReadAt(b []byte, off int64) (int, error) {
-	return d.dev.ReadAt(b, off)
+	d.m.Lock()
+	end := d.active.end()
+	memStart := d.active.start
+	if d.flushing != nil {
+		memStart = d.flushing.start
+		d.flushing.copyTo(b, off)
+	}
+	d.active.copyTo(b, off)
+	d.m.Unlock()
+
+	if off >= end {
+		return 0, logex.Trace(io.EOF)
+	}
+	n := len(b)
+	if off+int64(n) > end {
+		n = int(end - off)
+	}
+
+	// the rest is on disk
+	if off < memStart {
+		size := n
+		if off+int64(size) > memStart {
+			size = int(memStart - off)
+		}
+		nDisk, err := d.raw.ReadAt(b[:size], off)
+		if nDisk < size {
+			if err == nil {
+				err = io.ErrUnexpectedEOF
+			}
+			return nDisk, logex.Trace(err)
+		}
+	}
+
+	if n < len(b) {
+		return n, logex.Trace(io.EOF)
+	}
+	return n, nil
 }
@@ -0,0 +1,174 @@
+package bio
+
+import (
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+// a MemDisk which is safe for concurrent use, and slow on writing
+type testSlowDisk struct {
+	m     sync.Mutex
+	disk  *test.MemDisk
+	delay time.Duration
+}
+
+func newTestSlowDisk(delay time.Duration) *testSlowDisk {
+	return &testSlowDisk{disk: test.NewMemDisk(), delay: delay}
+}
+
+func (d *testSlowDisk) WriteAt(b []byte, off int64) (int, error) {
+	time.Sleep(d.delay)
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.disk.WriteAt(b, off)
+}
+
+func (d *testSlowDisk) ReadAt(b []byte, off int64) (int, error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.disk.ReadAt(b, off)
+}
+
+// the content of the data at off
+func testPattern(off int64, n int) []byte {
+	ret := make([]byte, n)
+	for i := range ret {
+		ret[i] = byte((off + int64(i)) * 7)
+	}
+	return ret
+}
+
+func TestDeviceMgr(t *testing.T) {
+	defer test.New(t)
+
+	disk := newTestSlowDisk(0)
+	start := int64(100)
+	setOff := start
+	dm := NewDeviceMgrEx(flow.New(), disk, &setOff, 1024)
+	defer dm.Close()
+
+	{
+		_, err := dm.MallocWriter(1025)
+		test.Equal(err, ErrDeviceBufOverflow)
+	}
+
+	w, err := dm.MallocWriter(10)
+	test.Nil(err)
+	test.Equal(w.Offset(), start)
+	w.Byte(testPattern(w.Offset(), 10))
+
+	// the flush waits for the writer
+	flushed := make(chan error)
+	go func() {
+		flushed <- dm.Flush()
+	}()
+	select {
+	case <-w.Sealed():
+	case <-time.After(time.Second):
+		t.Fatal("not sealed")
+	}
+	select {
+	case <-flushed:
+		t.Fatal("flushed before writer is done")
+	case <-time.After(20 * time.Millisecond):
+	}
+	{ // readable in buffer
+		buf := make([]byte, 10)
+		test.ReadAt(dm, buf, start)
+		test.EqualBytes(buf, testPattern(start, 10))
+	}
+	w.Done()
+	w.Done()
+	test.Nil(<-flushed)
+	test.Equal(setOff, start+10)
+
+	// half on disk, half in buffer
+	w, err = dm.MallocWriter(10)
+	test.Nil(err)
+	test.Equal(w.Offset(), start+10)
+	w.Byte(testPattern(w.Offset(), 10))
+	w.Done()
+	buf := make([]byte, 20)
+	test.ReadAt(dm, buf, start)
+	test.EqualBytes(buf, testPattern(start, 20))
+
+	n, err := dm.ReadAt(buf, start+15)
+	test.Equal(n, 5)
+	test.NotNil(err)
+	test.Equal(setOff, start+10)
+}
+
+func TestDeviceMgrStress(t *testing.T) {
+	defer test.New(t)
+
+	disk := newTestSlowDisk(time.Millisecond)
+	var setOff int64
+	dm := NewDeviceMgrEx(flow.New(), disk, &setOff, 16<<10)
+
+	type region struct {
+		off  int64
+		size int
+	}
+	var (
+		m       sync.Mutex
+		regions []region
+		wg      sync.WaitGroup
+	)
+	for i := 0; i < 32; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			r := rand.New(rand.NewSource(seed))
+			for j := 0; j < 200; j++ {
+				size := r.Intn(2<<10) + 1
+				w, err := dm.MallocWriter(size)
+				test.Nil(err)
+				data := testPattern(w.Offset(), size)
+				// write in pieces
+				for len(data) > 0 {
+					n := r.Intn(len(data)) + 1
+					w.Byte(data[:n])
+					data = data[n:]
+				}
+				w.Done()
+
+				// read back from buffers or disk
+				buf := make([]byte, size)
+				test.ReadAt(dm, buf, w.Offset())
+				test.EqualBytes(buf, testPattern(w.Offset(), size))
+
+				m.Lock()
+				regions = append(regions, region{w.Offset(), size})
+				m.Unlock()
+				if r.Intn(50) == 0 {
+					test.Nil(dm.Flush())
+				}
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	test.Nil(dm.Flush())
+	dm.Close()
+
+	// no overlap, no hole, and all on disk
+	var total int64
+	for _, r := range regions {
+		total += int64(r.size)
+		buf := make([]byte, r.size)
+		test.ReadAt(disk, buf, r.off)
+		test.EqualBytes(buf, testPattern(r.off, r.size))
+	}
+	test.Equal(setOff, total)
+	stats := dm.Stats()
+	test.Equal(stats.Bytes, total)
+	test.True(stats.Flushes > 1)
+	test.True(stats.Waits > 0)
+
+	_, err := dm.MallocWriter(1)
+	test.Equal(err, ErrDeviceMgrClosed)
+}
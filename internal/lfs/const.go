@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"unsafe"
 
+	"github.com/allmad/madq/internal/bio"
 	"github.com/chzyer/logex"
-	"github.com/chzyer/madq/internal/bio"
 )
 
 const (
@@ -68,7 +68,7 @@ func (n *Inode) InitBlock(idx int, addr int64) {
 
 func (n *Inode) SetBlock(idx int, size int, addr int64) {
 	n.BlockMeta[idx].Set(size, addr)
-	n.End = n.Start + int64(size)
+	n.End += int64(size)
 }
 
 func (n *Inode) AddBlockSize(idx int, size int) {
@@ -3,10 +3,11 @@ package lfs
 import (
 	"fmt"
 	"io"
+	"sync"
 
+	"github.com/allmad/madq/internal/bio"
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
-	"github.com/chzyer/madq/internal/bio"
 )
 
 var (
@@ -14,24 +15,29 @@ var (
 )
 
 type FileDelegate interface {
-	GetFlushNotify() chan struct{}
-	MallocWriter(n int) *bio.DeviceWriter
+	MallocWriter(n int) (*bio.DeviceWriter, error)
 	NextInode(*Inode) (*Inode, error)
 	PrevInode(*Inode) (*Inode, error)
 	ForceFlush()
 	ReadDeviceAt([]byte, int64) (int, error)
 	GetInode(ino int32) (*Inode, error)
-	DoneFlush()
+	SaveInode(*Inode)
 }
 
 type File struct {
 	flow     *flow.Flow
 	name     string
 	ino      int32
-	inode    *Inode
 	delegate FileDelegate
 	offset   int64
 
+	// guard the lastest inode which is changed by loop(), the previous
+	// inodes are never changed
+	inodeGuard sync.RWMutex
+	inode      *Inode
+	// the inode of the last read
+	readInode *Inode
+
 	wchan chan *writeReq
 }
 
@@ -48,53 +54,87 @@ func NewFile(f *flow.Flow, delegate FileDelegate, ino int32, name string) (*File
 	}
 	fd.inode = inode
 
-	go fd.loop()
 	f.ForkTo(&fd.flow, fd.Close)
+	// added before the loop runs, or a Close in between doesn't wait for it
+	fd.flow.Add(1)
+	go fd.loop()
 	return fd, nil
 }
 
 func (f *File) loop() {
-	f.flow.Add(1)
 	defer f.flow.DoneAndClose()
 
 	inode := f.inode
 	currentIdx := -1
 
-	var wantFlush chan struct{}
 	var memWriter *bio.DeviceWriter
-	getNewInode := func() {
-		panic("new to make a new one")
+
+	// the rest of the block is never used, and it's free to be flushed
+	doneBlock := func() {
+		if memWriter != nil {
+			memWriter.Done()
+			memWriter = nil
+		}
+	}
+
+	// find an empty block, or roll over to the next inode
+	nextBlock := func() error {
+		// never wait for others while holding a block
+		doneBlock()
+		currentIdx = inode.FindAvailable()
+		if currentIdx == -1 {
+			next, err := f.delegate.NextInode(inode)
+			if err != nil {
+				return logex.Trace(err)
+			}
+			f.inodeGuard.Lock()
+			f.inode = next
+			f.inodeGuard.Unlock()
+			inode = next
+			currentIdx = inode.FindAvailable()
+		}
+		w, err := f.delegate.MallocWriter(BlockSize)
+		if err != nil {
+			return logex.Trace(err)
+		}
+		memWriter = w
+		return nil
 	}
+
 loop:
 	for {
+		var sealed <-chan struct{}
+		if memWriter != nil {
+			sealed = memWriter.Sealed()
+		}
+
 		select {
 		case wreq := <-f.wchan:
 			if memWriter == nil {
-				wantFlush = f.delegate.GetFlushNotify()
-				memWriter = f.delegate.MallocWriter(BlockSize)
-				currentIdx = inode.FindAvailable()
-				if currentIdx == -1 {
-					getNewInode()
+				if err := nextBlock(); err != nil {
+					wreq.Reply <- &writeResp{0, err}
+					continue
 				}
 			}
 
 			data := wreq.Data
 		write:
 			n := memWriter.Byte(data)
+			f.inodeGuard.Lock()
 			if inode.BlockMeta[currentIdx].IsEmpty() {
 				inode.SetBlock(currentIdx, n, memWriter.Offset())
 			} else {
 				inode.AddBlockSize(currentIdx, n)
 			}
+			f.inodeGuard.Unlock()
+			f.delegate.SaveInode(inode)
 
 			if len(data[n:]) > 0 {
 				// we got more to write
 				data = data[n:]
-				newMemWriter := f.delegate.MallocWriter(BlockSize)
-				memWriter = newMemWriter
-				currentIdx = inode.FindAvailable()
-				if currentIdx == -1 {
-					getNewInode()
+				if err := nextBlock(); err != nil {
+					wreq.Reply <- &writeResp{len(wreq.Data) - len(data), err}
+					continue
 				}
 				goto write
 			}
@@ -102,15 +142,12 @@ loop:
 			// finish
 			wreq.Reply <- &writeResp{len(wreq.Data), nil}
 			if memWriter.Available() == 0 {
-				memWriter = nil
-				wantFlush = nil
+				doneBlock()
 			}
-		case <-wantFlush:
-			wantFlush = nil
-			memWriter = nil
-			f.delegate.DoneFlush()
-			// flush inode to buffer
+		case <-sealed:
+			doneBlock()
 		case <-f.flow.IsClose():
+			doneBlock()
 			break loop
 		}
 	}
@@ -122,21 +159,98 @@ func (f *File) Read(b []byte) (n int, err error) {
 	return n, err
 }
 
+// the size of file
+func (f *File) Size() int64 {
+	f.inodeGuard.RLock()
+	ret := f.inode.End
+	f.inodeGuard.RUnlock()
+	return ret
+}
+
 func (f *File) ReadAt(b []byte, offset int64) (n int, err error) {
-	// now need to translate to raw offset
-	if offset > f.inode.Start {
+	for n < len(b) {
+		var nr int
+		nr, err = f.readAt(b[n:], offset+int64(n))
+		n += nr
+		if err != nil {
+			break
+		}
+	}
+	return n, err
+}
 
+// read in one block
+func (f *File) readAt(b []byte, offset int64) (int, error) {
+	f.inodeGuard.RLock()
+	lastest, end := f.inode, f.inode.End
+	f.inodeGuard.RUnlock()
+	if offset >= end {
+		return 0, io.EOF
+	}
+
+	inode, err := f.seekInode(lastest, offset)
+	if err != nil {
+		return 0, logex.Trace(err, offset)
+	}
+
+	// the lastest inode is still changing
+	if inode == lastest {
+		f.inodeGuard.RLock()
+		defer f.inodeGuard.RUnlock()
+	}
+	blkStart := inode.Start
+	for _, blk := range inode.BlockMeta {
+		if blk.IsEmpty() {
+			break
+		}
+		size := int64(blk.GetLength())
+		if offset < blkStart+size {
+			n := blkStart + size - offset
+			if n > int64(len(b)) {
+				n = int64(len(b))
+			}
+			return f.delegate.ReadDeviceAt(b[:n], blk.GetAddr()+offset-blkStart)
+		}
+		blkStart += size
 	}
 	return 0, io.EOF
+}
 
-	return f.delegate.ReadDeviceAt(b, offset)
+// find the inode contains offset, walk back from the inode of last read
+// or the lastest one.
+func (f *File) seekInode(lastest *Inode, offset int64) (*Inode, error) {
+	f.inodeGuard.RLock()
+	inode := f.readInode
+	f.inodeGuard.RUnlock()
+	if inode == nil || offset >= inode.End {
+		inode = lastest
+	}
+
+	for offset < inode.Start {
+		prev, err := f.delegate.PrevInode(inode)
+		if err != nil {
+			return nil, err
+		}
+		inode = prev
+	}
+
+	if inode != lastest {
+		f.inodeGuard.Lock()
+		f.readInode = inode
+		f.inodeGuard.Unlock()
+	}
+	return inode, nil
 }
 
 func (f *File) Write(b []byte) (n int, err error) {
-	ret := make(chan *writeResp)
-	f.wchan <- &writeReq{
-		Data:  b,
-		Reply: ret,
+	if len(b) == 0 {
+		return 0, nil
+	}
+	ret := make(chan *writeResp, 1)
+	select {
+	case f.wchan <- &writeReq{Data: b, Reply: ret}:
+	case <-f.flow.IsClose():
+		return 0, ErrFileClosed.Trace()
 	}
 	resp := <-ret
 	return resp.N, resp.Err
@@ -152,13 +266,18 @@ func (f *File) Name() string {
 
 func (f *File) Close() {
 	f.flow.Close()
-	for wreq := range f.wchan {
-		wreq.Reply <- &writeResp{
-			N:   0,
-			Err: ErrFileClosed.Trace(),
+	// reply the pending requests
+	for {
+		select {
+		case wreq := <-f.wchan:
+			wreq.Reply <- &writeResp{
+				N:   0,
+				Err: ErrFileClosed.Trace(),
+			}
+		default:
+			return
 		}
 	}
-	// read all wchan
 }
 
 // -----------------------------------------------------------------------------
@@ -0,0 +1,151 @@
+package lfs
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"testing"
+
+	"github.com/allmad/madq/internal/bio"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+func testNewVolume() *Volume {
+	test.CleanTmp()
+	blk, err := bio.NewFile(test.Root())
+	test.Nil(err)
+	blk.Delete(false)
+
+	vol, err := NewVolume(flow.New(), blk)
+	test.Nil(err)
+	return vol
+}
+
+// count the inodes by walking back from the lastest one
+func testInodeChain(vol *Volume, fd *File) []*Inode {
+	inode := fd.inode
+	chain := []*Inode{inode}
+	for inode.Start > 0 {
+		prev, err := vol.PrevInode(inode)
+		test.Nil(err)
+		test.Equal(prev.End, inode.Start)
+		chain = append(chain, prev)
+		inode = prev
+	}
+	return chain
+}
+
+func TestFileReadWrite(t *testing.T) {
+	defer test.New(t)
+	vol := testNewVolume()
+	defer vol.Close()
+
+	fd, err := vol.OpenFile("hello", true)
+	test.Nil(err)
+	defer fd.Close()
+
+	// in random sizes, and flush in the middle so the blocks are not full
+	r := rand.New(rand.NewSource(1))
+	data := test.RandBytes(600 << 10)
+	for written := 0; written < len(data); {
+		n := r.Intn(10<<10) + 1
+		if written+n > len(data) {
+			n = len(data) - written
+		}
+		test.Write(fd, data[written:written+n])
+		written += n
+		if r.Intn(5) == 0 {
+			fd.Flush()
+		}
+	}
+	test.Equal(fd.Size(), int64(len(data)))
+
+	// one inode holds 11 blocks at most
+	chain := testInodeChain(vol, fd)
+	test.True(len(chain) > len(data)/(11*BlockSize))
+
+	buf := make([]byte, len(data))
+	test.ReadAt(fd, buf, 0)
+	test.EqualBytes(buf, data)
+
+	for i := 0; i < 200; i++ {
+		off := r.Intn(len(data))
+		n := r.Intn(20 << 10)
+		if off+n > len(data) {
+			n = len(data) - off
+		}
+		test.ReadAt(fd, buf[:n], int64(off))
+		test.EqualBytes(buf[:n], data[off:off+n])
+	}
+
+	// across the end
+	n, err := fd.ReadAt(buf[:100], int64(len(data)-10))
+	test.Equal(n, 10)
+	test.Equal(err, io.EOF)
+	test.EqualBytes(buf[:10], data[len(data)-10:])
+}
+
+func TestFileInterleave(t *testing.T) {
+	defer test.New(t)
+	vol := testNewVolume()
+	defer vol.Close()
+
+	names := []string{"a", "b", "c"}
+	fds := make([]*File, len(names))
+	datas := make([][]byte, len(names))
+	for idx, name := range names {
+		fd, err := vol.OpenFile(name, true)
+		test.Nil(err)
+		defer fd.Close()
+		fds[idx] = fd
+		datas[idx] = test.RandBytes(100 << 10)
+	}
+
+	// the blocks of the files are interleaved in the device
+	for off := 0; off < 100<<10; off += 3000 {
+		for idx, fd := range fds {
+			end := off + 3000
+			if end > len(datas[idx]) {
+				end = len(datas[idx])
+			}
+			test.Write(fd, datas[idx][off:end])
+		}
+	}
+	fds[0].Flush()
+
+	for idx, fd := range fds {
+		buf := make([]byte, len(datas[idx]))
+		test.ReadAt(fd, buf, 0)
+		test.EqualBytes(buf, datas[idx])
+		test.True(len(testInodeChain(vol, fd)) > 1)
+	}
+}
+
+func TestFileConcurrentWrite(t *testing.T) {
+	defer test.New(t)
+	vol := testNewVolume()
+	defer vol.Close()
+
+	// more than the two buffers of device without flushing, by several
+	// files at the same time
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		fd, err := vol.OpenFile(fmt.Sprint("file", i), true)
+		test.Nil(err)
+		defer fd.Close()
+		wg.Add(1)
+		go func(fd *File) {
+			defer wg.Done()
+			data := test.RandBytes(5 << 20)
+			for off := 0; off < len(data); off += 64 << 10 {
+				test.Write(fd, data[off:off+64<<10])
+			}
+			buf := make([]byte, len(data))
+			test.ReadAt(fd, buf, 0)
+			test.EqualBytes(buf, data)
+		}(fd)
+	}
+	wg.Wait()
+}
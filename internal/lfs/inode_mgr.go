@@ -2,13 +2,15 @@ package lfs
 
 import (
 	"io"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/allmad/madq/internal/bio"
+	"github.com/allmad/madq/internal/util"
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
-	"github.com/chzyer/madq/internal/bio"
-	"github.com/chzyer/madq/internal/util"
 )
 
 var (
@@ -39,16 +41,25 @@ type InodeMgr struct {
 	flow         *flow.Flow
 	state        InodeMgrState
 	reservedArea *ReservedArea
-	needFlush    chan struct{}
 
-	// addr => Inode
+	// guard inodeMap, inodeTableMap, reservedArea, the addresses on disk
+	// and dirtyInodeTable
+	m sync.Mutex
+	// addr => Inode, the old addresses are kept to resolve Prev
 	inodeMap map[Address]*Inode
 	// addr => InodeTable
 	inodeTableMap map[Address]*InodeTable
+	// the address of the lastest copy on disk
+	inodeAddr      map[*Inode]Address
+	inodeTableAddr map[*InodeTable]Address
+	// InodeTable => index of IndirectInodeTable
+	dirtyInodeTable map[*InodeTable]int
 
-	dirtyGuard      sync.Mutex
-	dirtyInode      []*Inode
-	dirtyInodeTable []*InodeTable
+	dirtyGuard sync.Mutex
+	// the snapshots of inodes which are not persisted
+	dirtyInode map[*Inode]Inode
+
+	flushGuard sync.Mutex
 
 	// set on started, please get via `getDev()`
 	dev *bio.DeviceMgr
@@ -56,81 +67,191 @@ type InodeMgr struct {
 
 func NewInodeMgr(f *flow.Flow) *InodeMgr {
 	im := &InodeMgr{
-		needFlush:     make(chan struct{}, 1),
-		reservedArea:  NewReservedArea(),
-		inodeMap:      make(map[Address]*Inode),
-		inodeTableMap: make(map[Address]*InodeTable),
+		reservedArea:    NewReservedArea(),
+		inodeMap:        make(map[Address]*Inode),
+		inodeTableMap:   make(map[Address]*InodeTable),
+		inodeAddr:       make(map[*Inode]Address),
+		inodeTableAddr:  make(map[*InodeTable]Address),
+		dirtyInodeTable: make(map[*InodeTable]int),
+		dirtyInode:      make(map[*Inode]Inode),
 	}
 
 	f.ForkTo(&im.flow, im.Close)
 	return im
 }
 
-func (i *InodeMgr) markDirtyInode(inode *Inode) {
+// SaveInode marks inode dirty with a snapshot of it, it will be persisted
+// on next Flush(). the caller must be the one who changes the inode.
+func (i *InodeMgr) SaveInode(inode *Inode) {
+	snapshot := *inode
 	i.dirtyGuard.Lock()
-	i.dirtyInode = append(i.dirtyInode, inode)
+	i.dirtyInode[inode] = snapshot
 	i.dirtyGuard.Unlock()
-	select {
-	case <-i.needFlush:
-	default:
+}
+
+// mark inodeTable dirty, and make it found by the in-memory address,
+// the copy on disk is not the lastest one any more.
+func (i *InodeMgr) markDirtyInodeTable(l1 int, inodeTable *InodeTable) {
+	inodeTableAddr := InodeTableAddress(inodeTable)
+	if i.reservedArea.IndirectInodeTable[l1] != inodeTableAddr {
+		delete(i.inodeTableMap, i.reservedArea.IndirectInodeTable[l1])
+		i.inodeTableMap[inodeTableAddr] = inodeTable
+		i.reservedArea.IndirectInodeTable[l1] = inodeTableAddr
 	}
+	i.dirtyInodeTable[inodeTable] = l1
 }
 
-func (i *InodeMgr) markDirtyInodeTable(inodeTable *InodeTable) {
-	i.dirtyGuard.Lock()
-	i.dirtyInodeTable = append(i.dirtyInodeTable, inodeTable)
-	i.dirtyGuard.Unlock()
-	select {
-	case <-i.needFlush:
-	default:
+// the address on disk of the inode which addr points to
+func (i *InodeMgr) diskInodeAddr(addr Address) (Address, error) {
+	inode, ok := i.inodeMap[addr]
+	if !ok {
+		if addr.InMemory() {
+			return 0, ErrAddressIsInMemory.Trace()
+		}
+		return addr, nil
 	}
+	diskAddr, ok := i.inodeAddr[inode]
+	if !ok {
+		return 0, ErrAddressIsInMemory.Trace(addr)
+	}
+	return diskAddr, nil
 }
 
-func (i *InodeMgr) loop(dev *bio.DeviceMgr) {
-	i.flow.Add(1)
-	defer i.flow.DoneAndClose()
+type inodeSnapshots []*Inode
 
-	var flushChan chan struct{}
-	flush := func() {
-		i.dirtyGuard.Lock()
-		size := len(i.dirtyInode)*InodeSize + len(i.dirtyInodeTable)*InodeTableSize
+func (s inodeSnapshots) Len() int      { return len(s) }
+func (s inodeSnapshots) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s inodeSnapshots) Less(i, j int) bool {
+	if s[i].Ino != s[j].Ino {
+		return s[i].Ino < s[j].Ino
+	}
+	return s[i].Start < s[j].Start
+}
 
-		if size > 0 && flushChan == nil {
-			flushChan = dev.GetFlushNotify()
-		}
+// write dirty inodes and inode tables into the buffer of dev, and replace
+// their in-memory addresses by the ones on disk.
+func (i *InodeMgr) flushDirty(dev *bio.DeviceMgr) (err error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	// inodes are only created with i.m held, so all of the inodes in
+	// the tables are in dirty or on disk.
+	i.dirtyGuard.Lock()
+	dirty := i.dirtyInode
+	i.dirtyInode = make(map[*Inode]Inode)
+	i.dirtyGuard.Unlock()
 
-		w := dev.MallocWriter(size)
-		_ = w
-		logex.Info(i.dirtyInode)
-		for _, inode := range i.dirtyInode {
-			_ = inode
+	// the older inodes go first, the newer ones point to them by Prev
+	inodes := make(inodeSnapshots, 0, len(dirty))
+	for inode := range dirty {
+		inodes = append(inodes, inode)
+	}
+	sort.Sort(inodes)
+
+	// put the snapshots which are not written back on error, so that
+	// they are retried on next flush. the dirty inode tables are only
+	// removed after written, the rest of them are still there.
+	written := 0
+	defer func() {
+		if err != nil {
+			i.redirtyInodes(dirty, inodes[written:])
+		}
+	}()
+
+	for _, inode := range inodes {
+		snapshot := dirty[inode]
+		if snapshot.Prev != 0 {
+			prev, err := i.diskInodeAddr(Address(snapshot.Prev))
+			if err != nil {
+				return logex.Trace(err)
+			}
+			snapshot.Prev = int64(prev)
+		}
+		addr, err := writeDisk(dev, &snapshot)
+		if err != nil {
+			return logex.Trace(err)
+		}
+		i.inodeAddr[inode] = addr
+		i.inodeMap[addr] = inode
+
+		// point to the new copy if it's the lastest inode of the file
+		l1, l2 := i.reservedArea.GetIdx(inode.Ino)
+		inodeTable, err := i.getInodeTable(i.reservedArea.IndirectInodeTable[l1])
+		if err != nil {
+			return logex.Trace(err)
+		}
+		if i.inodeMap[inodeTable.Address[l2]] == inode {
+			inodeTable.Address[l2] = addr
+			i.markDirtyInodeTable(l1, inodeTable)
+		}
+		written++
+	}
 
+	for inodeTable, l1 := range i.dirtyInodeTable {
+		snapshot := *inodeTable
+		for j, addr := range snapshot.Address {
+			if !addr.InMemory() {
+				continue
+			}
+			diskAddr, err := i.diskInodeAddr(addr)
+			if err != nil {
+				return logex.Trace(err)
+			}
+			snapshot.Address[j] = diskAddr
 		}
-		i.dirtyInode = i.dirtyInode[:0]
-		i.dirtyInodeTable = i.dirtyInodeTable[:0]
 
-		i.dirtyGuard.Unlock()
+		addr, err := writeDisk(dev, &snapshot)
+		if err != nil {
+			return logex.Trace(err)
+		}
+		i.inodeTableAddr[inodeTable] = addr
+		i.inodeTableMap[addr] = inodeTable
+		if i.reservedArea.IndirectInodeTable[l1] == InodeTableAddress(inodeTable) {
+			delete(i.inodeTableMap, InodeTableAddress(inodeTable))
+			i.reservedArea.IndirectInodeTable[l1] = addr
+		}
+		delete(i.dirtyInodeTable, inodeTable)
+	}
+	return nil
+}
 
-		if flushChan != nil {
-			dev.Done()
+// mark the snapshots of inodes dirty again, the newer ones saved during
+// the flush are kept.
+func (i *InodeMgr) redirtyInodes(dirty map[*Inode]Inode, inodes []*Inode) {
+	i.dirtyGuard.Lock()
+	defer i.dirtyGuard.Unlock()
+	for _, inode := range inodes {
+		if _, ok := i.dirtyInode[inode]; !ok {
+			i.dirtyInode[inode] = dirty[inode]
 		}
 	}
+}
 
-loop:
-	for {
-		select {
-		case <-i.needFlush:
-			if flushChan == nil {
-				flushChan = dev.GetFlushNotify()
-			}
-		case <-flushChan:
-			flush()
-			flushChan = nil
-		case <-i.flow.IsClose():
-			flush()
-			break loop
+// append d to dev, one writer for each so that it never holds a buffer
+// while waiting for the other one.
+func writeDisk(dev *bio.DeviceMgr, d bio.Diskable) (Address, error) {
+	w, err := dev.MallocWriter(d.Size())
+	if err != nil {
+		return 0, logex.Trace(err)
+	}
+	d.WriteDisk(w.Writer)
+	w.Done()
+	return Address(w.Offset()), nil
+}
+
+// write the reserved area with the addresses on disk, the changes which
+// are not persisted yet are ignored.
+func (i *InodeMgr) writeReservedArea(raw bio.RawDisker) error {
+	ra := *i.reservedArea
+	ra.Superblock.Checkpoint = atomic.LoadInt64(&i.reservedArea.Superblock.Checkpoint)
+	for idx, addr := range ra.IndirectInodeTable {
+		if !addr.InMemory() {
+			continue
 		}
+		// it's 0 if the table is never persisted
+		ra.IndirectInodeTable[idx] = i.inodeTableAddr[i.inodeTableMap[addr]]
 	}
+	return bio.WriteAt(raw, 0, &ra)
 }
 
 func (i *InodeMgr) Close() {
@@ -148,7 +269,6 @@ func (i *InodeMgr) Start(dev *bio.DeviceMgr) {
 	if !i.state.Set(InodeMgrStateStarting) {
 		return
 	}
-	go i.loop(dev)
 	i.dev = dev
 	i.state.Set(InodeMgrStateStarted)
 }
@@ -166,8 +286,13 @@ func (i *InodeMgr) Init(raw bio.RawDisker) error {
 	return err
 }
 
+// GenNextInode allocs the next inode of the file, which starts at the end
+// of inode and links to it by Prev. it becomes the lastest inode of the file.
 func (i *InodeMgr) GenNextInode(inode *Inode) (*Inode, error) {
-	oldInodeAddr, err := i.GetInodeAddr(inode.Ino)
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	oldInodeAddr, err := i.getInodeAddr(inode.Ino)
 	if err != nil {
 		return nil, logex.Trace(err)
 	}
@@ -179,13 +304,19 @@ func (i *InodeMgr) GenNextInode(inode *Inode) (*Inode, error) {
 }
 
 func (i *InodeMgr) GetInodeAddr(ino int32) (Address, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	return i.getInodeAddr(ino)
+}
+
+func (i *InodeMgr) getInodeAddr(ino int32) (Address, error) {
 	l1, l2 := i.reservedArea.GetIdx(ino)
 	tableAddr := i.reservedArea.IndirectInodeTable[l1]
 	if !tableAddr.Valid() {
 		return 0, ErrInodeMgrInodeNotFound.Trace()
 	}
 
-	it, err := i.GetInodeTable(tableAddr)
+	it, err := i.getInodeTable(tableAddr)
 	if err != nil {
 		return 0, err
 	}
@@ -193,28 +324,47 @@ func (i *InodeMgr) GetInodeAddr(ino int32) (Address, error) {
 	return it.Address[l2], nil
 }
 
+// the lastest inode of ino
 func (i *InodeMgr) GetInode(ino int32) (*Inode, error) {
-	inodeAddr, err := i.GetInodeAddr(ino)
+	i.m.Lock()
+	defer i.m.Unlock()
+
+	inodeAddr, err := i.getInodeAddr(ino)
 	if err != nil {
 		return nil, logex.Trace(err)
 	}
 	if !inodeAddr.Valid() {
 		return nil, ErrInodeMgrInodeNotFound.Trace()
 	}
-	inode, err := i.GetInodeByAddr(inodeAddr)
+	inode, err := i.getInodeByAddr(inodeAddr)
 	if err != nil {
 		return nil, err
 	}
 	return inode, nil
 }
 
+// Flush persists the dirty inodes and inode tables, and makes a checkpoint
+// by writing the reserved area.
 func (i *InodeMgr) Flush() error {
-	if err := i.dev.Flush(); err != nil {
+	dev, err := i.getDev()
+	if err != nil {
+		return logex.Trace(err)
+	}
+
+	i.flushGuard.Lock()
+	defer i.flushGuard.Unlock()
+
+	if err := i.flushDirty(dev); err != nil {
+		return logex.Trace(err)
+	}
+	if err := dev.Flush(); err != nil {
 		return logex.Trace(err)
 	}
 
 	// sync reservice area
-	err := bio.WriteAt(i.dev.Raw(), 0, i.reservedArea)
+	i.m.Lock()
+	err = i.writeReservedArea(dev.Raw())
+	i.m.Unlock()
 	if err != nil {
 		return logex.Trace(err)
 	}
@@ -223,6 +373,12 @@ func (i *InodeMgr) Flush() error {
 
 // get inode table from cache or disk
 func (i *InodeMgr) GetInodeTable(addr Address) (*InodeTable, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	return i.getInodeTable(addr)
+}
+
+func (i *InodeMgr) getInodeTable(addr Address) (*InodeTable, error) {
 	if !addr.Valid() {
 		return nil, ErrAddressNotValid.Trace()
 	}
@@ -248,11 +404,18 @@ func (i *InodeMgr) GetInodeTable(addr Address) (*InodeTable, error) {
 		return nil, logex.Trace(err)
 	}
 	i.inodeTableMap[addr] = it
+	i.inodeTableAddr[it] = addr
 	return it, nil
 }
 
 // make sure addr is Valid()
 func (i *InodeMgr) GetInodeByAddr(addr Address) (*Inode, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
+	return i.getInodeByAddr(addr)
+}
+
+func (i *InodeMgr) getInodeByAddr(addr Address) (*Inode, error) {
 	if !addr.Valid() {
 		return nil, ErrAddressNotValid.Trace()
 	}
@@ -271,44 +434,80 @@ func (i *InodeMgr) GetInodeByAddr(addr Address) (*Inode, error) {
 		return nil, logex.Trace(err)
 	}
 	i.inodeMap[addr] = inode
+	i.inodeAddr[inode] = addr
 	return inode, nil
 }
 
 func (i *InodeMgr) InodeCount() int {
+	i.m.Lock()
+	defer i.m.Unlock()
 	return int(i.reservedArea.Superblock.InodeCnt)
 }
 
 func (i *InodeMgr) RemoveInode(ino int32) error {
+	i.m.Lock()
+	defer i.m.Unlock()
 	return i.removeInode(ino)
 }
 
 func (i *InodeMgr) removeInode(ino int32) error {
-	dev, err := i.getDev()
-	if err != nil {
-		return logex.Trace(err)
+	// only the last one can be reused
+	if ino == i.reservedArea.Superblock.InodeCnt-1 {
+		i.reservedArea.Superblock.InodeCnt--
 	}
-
-	i.reservedArea.Superblock.InodeCnt--
 	l1, l2 := i.reservedArea.GetIdx(ino)
-	inodeTableAddr := i.reservedArea.IndirectInodeTable[l1]
-	inodeTable, err := i.GetInodeTable(inodeTableAddr)
+	inodeTable, err := i.getInodeTable(i.reservedArea.IndirectInodeTable[l1])
 	if err != nil {
 		return logex.Trace(err)
 	}
 
-	inodeTableWriter := dev.MallocWriter(InodeTableSize)
 	inodeAddr := inodeTable.Address[l2]
 	inodeTable.Address[l2] = 0
-	inodeTableWriter.WriteDisk(inodeTable)
-	i.reservedArea.IndirectInodeTable[l1] = Address(inodeTableWriter.Offset())
-	delete(i.inodeMap, inodeAddr)
-	delete(i.inodeTableMap, inodeTableAddr)
-	i.inodeTableMap[Address(inodeTableWriter.Offset())] = inodeTable
-
+	i.markDirtyInodeTable(l1, inodeTable)
+	if inode, ok := i.inodeMap[inodeAddr]; ok {
+		delete(i.inodeMap, inodeAddr)
+		i.dirtyGuard.Lock()
+		delete(i.dirtyInode, inode)
+		i.dirtyGuard.Unlock()
+	}
 	return nil
 }
 
+// make inode the lastest one of its file
+func (i *InodeMgr) setLastestInode(inode *Inode) {
+	inodeAddr := InodeAddress(inode)
+	i.inodeMap[inodeAddr] = inode
+	i.SaveInode(inode)
+
+	l1, l2 := i.reservedArea.GetIdx(inode.Ino)
+	inodeTable, _ := i.getInodeTable(i.reservedArea.IndirectInodeTable[l1])
+	if inodeTable == nil {
+		// make a new inodeTable
+		inodeTable = new(InodeTable)
+	}
+	inodeTable.Address[l2] = inodeAddr
+	i.markDirtyInodeTable(l1, inodeTable)
+}
+
+// ResetInode replaces the inodes of ino by an empty one. no checkpoint is
+// made until fn returns, so what fn writes to the file is persisted along
+// with the reset.
+func (i *InodeMgr) ResetInode(ino int32, fn func() error) error {
+	i.flushGuard.Lock()
+	defer i.flushGuard.Unlock()
+
+	i.m.Lock()
+	i.setLastestInode(&Inode{
+		Ino:    ino,
+		Create: time.Now().UnixNano(),
+	})
+	i.m.Unlock()
+	return fn()
+}
+
 func (i *InodeMgr) NewInode() (*Inode, error) {
+	i.m.Lock()
+	defer i.m.Unlock()
 	return i.newInode(nil, 0)
 }
 
@@ -332,32 +531,6 @@ func (i *InodeMgr) newInode(prev *Inode, addr int64) (*Inode, error) {
 		}
 	}
 
-	inodeAddr := InodeAddress(inode)
-	i.inodeMap[inodeAddr] = inode
-	i.markDirtyInode(inode)
-
-	l1, l2 := i.reservedArea.GetIdx(inode.Ino)
-	inodeTableAddrIndirect := i.reservedArea.IndirectInodeTable[l1]
-	inodeTable, _ := i.GetInodeTable(inodeTableAddrIndirect)
-	if inodeTable != nil {
-		inodeTable.Address[l2] = inodeAddr
-		inodeTableAddr := InodeTableAddress(inodeTable)
-		i.reservedArea.IndirectInodeTable[l1] = inodeTableAddr
-		if inodeTableAddr != inodeTableAddrIndirect {
-			i.inodeTableMap[inodeTableAddr] = inodeTable
-			delete(i.inodeTableMap, inodeTableAddrIndirect)
-		}
-	} else {
-		// make a new inodeTable
-		inodeTable = new(InodeTable)
-		// set the first address to offset of Inode
-		inodeTable.Address[l2] = inodeAddr
-		inodeTableAddr := InodeTableAddress(inodeTable)
-		inodeTableAddrIndirect.Update(inodeTableAddr)
-		i.inodeTableMap[inodeTableAddr] = inodeTable
-		i.reservedArea.IndirectInodeTable[l1] = inodeTableAddr
-	}
-	i.markDirtyInodeTable(inodeTable)
-
+	i.setLastestInode(inode)
 	return inode, nil
 }
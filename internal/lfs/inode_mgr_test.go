@@ -3,8 +3,8 @@ package lfs
 import (
 	"testing"
 
+	"github.com/allmad/madq/internal/bio"
 	"github.com/chzyer/flow"
-	"github.com/chzyer/madq/internal/bio"
 	"github.com/chzyer/test"
 )
 
@@ -24,9 +24,15 @@ func TestInodeMgr(t *testing.T) {
 	}
 	ptr := im.GetPointer()
 
-	devmgr := bio.NewDeviceMgr(f, bio.NewDevice(block, *ptr), ptr)
+	devmgr := bio.NewDeviceMgr(f, block, ptr)
 	im.Start(devmgr)
 
+	{ // the root directory
+		inode, err := im.NewInode()
+		test.Nil(err)
+		test.Equal(inode.Ino, 0)
+	}
+
 	{
 		inode, err := im.NewInode()
 		test.Nil(err)
@@ -54,11 +60,39 @@ func TestInodeMgr(t *testing.T) {
 			err := im.Init(block)
 			test.Nil(err)
 			ptr := im.GetPointer()
-			dev := bio.NewDevice(block, *ptr)
-			im.Start(bio.NewDeviceMgr(f, dev, ptr))
+			im.Start(bio.NewDeviceMgr(f, block, ptr))
 		}
 		inode2, err := im.GetInode(inode.Ino)
 		test.Nil(err)
 		test.Equal(inode, inode2)
 	}
 }
+
+func TestInodeMgrFlushDirtyFailed(t *testing.T) {
+	defer test.New(t)
+
+	test.CleanTmp()
+	block, err := bio.NewFile(test.Root())
+	test.Nil(err)
+	f := flow.New()
+
+	im := NewInodeMgr(f)
+	test.Nil(im.Init(block))
+	ptr := im.GetPointer()
+	im.Start(bio.NewDeviceMgr(f, block, ptr))
+
+	inode, err := im.NewInode()
+	test.Nil(err)
+	im.SaveInode(inode)
+
+	// too small to hold an inode
+	small := bio.NewDeviceMgrEx(f, block, ptr, 1)
+	test.Equal(im.flushDirty(small), bio.ErrDeviceBufOverflow)
+	im.dirtyGuard.Lock()
+	_, ok := im.dirtyInode[inode]
+	im.dirtyGuard.Unlock()
+	test.True(ok)
+
+	test.Nil(im.Flush())
+	test.Equal(len(im.dirtyInode), 0)
+}
@@ -1,8 +1,8 @@
 package lfs
 
 import (
+	"github.com/allmad/madq/internal/bio"
 	"github.com/chzyer/logex"
-	"github.com/chzyer/madq/internal/bio"
 )
 
 const (
@@ -1,16 +1,108 @@
 package lfs
 
-import "github.com/chzyer/logex"
+import (
+	"sort"
+	"sync"
+
+	"github.com/allmad/madq/internal/bio"
+	"github.com/chzyer/logex"
+)
+
+var (
+	ErrRootDirInvalidName = logex.Define("invalid file name")
+)
+
+// -----------------------------------------------------------------------------
+// DirRecord
+
+const (
+	DirRecordAdd int32 = iota + 1
+	DirRecordRemove
+	DirRecordRename
+	DirRecordMtime
+)
+
+const DirRecordHeaderSize = 28
+
+var (
+	DirRecordMagic        = []byte{0x8a, 0x9c, 0x0, 0x3}
+	ErrDecodeNotDirRecord = logex.Define("not dir record")
+)
+
+// the change of RootDir which is appended to the root file
+type DirRecord struct {
+	Op      int32
+	Ino     int32
+	Mtime   int64 // unixnano, add and mtime only
+	Name    string
+	NewName string // rename only
+}
+
+func (d *DirRecord) Size() int {
+	return DirRecordHeaderSize + len(d.Name) + len(d.NewName)
+}
+
+func (d *DirRecord) ReadDisk(r bio.DiskReader) error {
+	if !r.Verify(DirRecordMagic) {
+		r.Skip(-len(DirRecordMagic))
+		return ErrDecodeNotDirRecord.Trace(r.Byte(len(DirRecordMagic)))
+	}
+	d.Op = r.Int32()
+	d.Ino = r.Int32()
+	d.Mtime = r.Int64()
+	nameSize := r.Int32()
+	newNameSize := r.Int32()
+	d.Name = string(r.Byte(int(nameSize)))
+	d.NewName = string(r.Byte(int(newNameSize)))
+	return nil
+}
+
+func (d *DirRecord) WriteDisk(w bio.DiskWriter) {
+	w.Byte(DirRecordMagic)
+	w.Int32(d.Op)
+	w.Int32(d.Ino)
+	w.Int64(d.Mtime)
+	w.Int32(int32(len(d.Name)))
+	w.Int32(int32(len(d.NewName)))
+	w.Byte([]byte(d.Name))
+	w.Byte([]byte(d.NewName))
+}
+
+// the size of the record at the head of data, -1 if it's incomplete
+func dirRecordSize(data []byte) int {
+	if len(data) < DirRecordHeaderSize {
+		return -1
+	}
+	r := bio.NewReader(data)
+	r.Skip(len(DirRecordMagic) + 16)
+	size := DirRecordHeaderSize + int(r.Int32()) + int(r.Int32())
+	if size < DirRecordHeaderSize || size > len(data) {
+		return -1
+	}
+	return size
+}
+
+// -----------------------------------------------------------------------------
+// RootDir
+
+// the root file is compacted when it has RootDirCompactMin records at least
+// and more than half of them are out of date.
+const RootDirCompactMin = 128
 
 type RootDir struct {
 	volume *Volume
-	fd     *File
 
+	m     sync.Mutex
+	fd    *File
 	cache map[string]int32
+	// name => unixnano, only for the files which mtime is set
+	mtime map[string]int64
+	// the number of records in the root file
+	records int
 }
 
 func NewRootDir(v *Volume) (*RootDir, error) {
-	fd, err := v.OpenFile("/", true)
+	fd, err := NewFile(v.flow, v, 0, "/")
 	if err != nil {
 		return nil, logex.Trace(err)
 	}
@@ -18,22 +110,223 @@ func NewRootDir(v *Volume) (*RootDir, error) {
 		volume: v,
 		fd:     fd,
 		cache:  make(map[string]int32),
+		mtime:  make(map[string]int64),
+	}
+	if err := rd.replay(); err != nil {
+		fd.Close()
+		return nil, logex.Trace(err)
 	}
 	return rd, nil
 }
 
+// rebuild the cache from the records in the root file
+func (r *RootDir) replay() error {
+	data := make([]byte, r.fd.Size())
+	if _, err := r.fd.ReadAt(data, 0); err != nil {
+		return logex.Trace(err)
+	}
+
+	for len(data) > 0 {
+		size := dirRecordSize(data)
+		if size < 0 {
+			break
+		}
+		var record DirRecord
+		if err := record.ReadDisk(bio.NewReader(data[:size])); err != nil {
+			return logex.Trace(err, r.fd.Size()-int64(len(data)))
+		}
+		r.apply(&record)
+		r.records++
+		data = data[size:]
+	}
+
+	if len(data) > 0 {
+		// the last record is torn, rewrite the file so that the new
+		// records are not appended after it
+		logex.Infof("root dir: %v bytes of torn record, compacting", len(data))
+		return logex.Trace(r.compact())
+	}
+	return nil
+}
+
+func (r *RootDir) apply(record *DirRecord) {
+	switch record.Op {
+	case DirRecordAdd:
+		r.cache[record.Name] = record.Ino
+		r.setMtime(record.Name, record.Mtime)
+	case DirRecordRemove:
+		delete(r.cache, record.Name)
+		delete(r.mtime, record.Name)
+	case DirRecordRename:
+		r.cache[record.NewName] = r.cache[record.Name]
+		r.setMtime(record.NewName, r.mtime[record.Name])
+		delete(r.cache, record.Name)
+		delete(r.mtime, record.Name)
+	case DirRecordMtime:
+		r.setMtime(record.Name, record.Mtime)
+	}
+}
+
+func (r *RootDir) setMtime(name string, mtime int64) {
+	if mtime == 0 {
+		delete(r.mtime, name)
+		return
+	}
+	r.mtime[name] = mtime
+}
+
+// write record to the root file, and apply it to the cache
+func (r *RootDir) append(record *DirRecord) error {
+	data := make([]byte, record.Size())
+	record.WriteDisk(bio.NewWriter(data))
+	if _, err := r.fd.Write(data); err != nil {
+		return logex.Trace(err)
+	}
+	r.apply(record)
+	r.records++
+
+	// the record is written and applied already, the caller must not undo
+	// it for a failed compaction. it's retried on the next append.
+	if r.records >= RootDirCompactMin && r.records > 2*len(r.cache) {
+		if err := r.compact(); err != nil {
+			logex.Error("root dir: compact failed:", err)
+		}
+	}
+	return nil
+}
+
+// rewrite the root file with the names in cache only
+func (r *RootDir) compact() error {
+	names := make([]string, 0, len(r.cache))
+	for name := range r.cache {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var data []byte
+	for _, name := range names {
+		record := &DirRecord{
+			Op:    DirRecordAdd,
+			Ino:   r.cache[name],
+			Mtime: r.mtime[name],
+			Name:  name,
+		}
+		buf := make([]byte, record.Size())
+		record.WriteDisk(bio.NewWriter(buf))
+		data = append(data, buf...)
+	}
+
+	return r.volume.inodeMgr.ResetInode(0, func() error {
+		r.fd.Close()
+		fd, err := NewFile(r.volume.flow, r.volume, 0, "/")
+		if err != nil {
+			return logex.Trace(err)
+		}
+		r.fd = fd
+		if _, err := fd.Write(data); err != nil {
+			return logex.Trace(err)
+		}
+		r.records = len(names)
+		return nil
+	})
+}
+
+func checkName(name string) error {
+	if name == "" || name == "/" {
+		return ErrRootDirInvalidName.Trace(name)
+	}
+	return nil
+}
+
 func (r *RootDir) Find(name string) int32 {
 	if name == "/" {
 		return 0
 	}
+	r.m.Lock()
 	ret, ok := r.cache[name]
+	r.m.Unlock()
 	if !ok {
 		return -1
 	}
 	return ret
 }
 
+// the names of all files, in order
+func (r *RootDir) List() []string {
+	r.m.Lock()
+	names := make([]string, 0, len(r.cache))
+	for name := range r.cache {
+		names = append(names, name)
+	}
+	r.m.Unlock()
+	sort.Strings(names)
+	return names
+}
+
 func (r *RootDir) Add(name string, ino int32) error {
-	r.cache[name] = ino
-	return nil
+	if err := checkName(name); err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.cache[name]; ok {
+		return ErrVolumeFileAlreadyExists.Trace(name)
+	}
+	return r.append(&DirRecord{Op: DirRecordAdd, Ino: ino, Name: name})
+}
+
+// remove name and returns the ino of it
+func (r *RootDir) Remove(name string) (int32, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	ino, ok := r.cache[name]
+	if !ok {
+		return -1, ErrVolumeFileNotExists.Trace(name)
+	}
+	if err := r.append(&DirRecord{Op: DirRecordRemove, Ino: ino, Name: name}); err != nil {
+		return -1, logex.Trace(err)
+	}
+	return ino, nil
+}
+
+func (r *RootDir) Rename(name, newName string) error {
+	if err := checkName(newName); err != nil {
+		return err
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	ino, ok := r.cache[name]
+	if !ok {
+		return ErrVolumeFileNotExists.Trace(name)
+	}
+	if _, ok := r.cache[newName]; ok {
+		return ErrVolumeFileAlreadyExists.Trace(newName)
+	}
+	return r.append(&DirRecord{
+		Op: DirRecordRename, Ino: ino, Name: name, NewName: newName,
+	})
+}
+
+// the mtime set by SetMtime, it's zero if never set.
+func (r *RootDir) Mtime(name string) (int64, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if _, ok := r.cache[name]; !ok {
+		return 0, ErrVolumeFileNotExists.Trace(name)
+	}
+	return r.mtime[name], nil
+}
+
+func (r *RootDir) SetMtime(name string, mtime int64) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	ino, ok := r.cache[name]
+	if !ok {
+		return ErrVolumeFileNotExists.Trace(name)
+	}
+	return r.append(&DirRecord{
+		Op: DirRecordMtime, Ino: ino, Mtime: mtime, Name: name,
+	})
 }
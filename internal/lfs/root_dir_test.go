@@ -0,0 +1,143 @@
+package lfs
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/allmad/madq/internal/bio"
+	"github.com/chzyer/flow"
+	"github.com/chzyer/test"
+)
+
+func testOpenVolume(root string) (*Volume, *bio.File) {
+	blk, err := bio.NewFile(root)
+	test.Nil(err)
+	vol, err := NewVolume(flow.New(), blk)
+	test.Nil(err)
+	return vol, blk
+}
+
+func testReopenVolume(root string, vol *Volume, blk *bio.File) (*Volume, *bio.File) {
+	vol.Close()
+	test.Nil(blk.Close())
+	return testOpenVolume(root)
+}
+
+func TestDirRecord(t *testing.T) {
+	defer test.New(t)
+
+	record := &DirRecord{Op: DirRecordRename, Ino: 3, Mtime: 5, Name: "a", NewName: "bc"}
+	data := make([]byte, record.Size()+10)
+	record.WriteDisk(bio.NewWriter(data))
+	test.Equal(dirRecordSize(data), record.Size())
+	test.Equal(dirRecordSize(data[:record.Size()-1]), -1)
+
+	var got DirRecord
+	test.Nil(got.ReadDisk(bio.NewReader(data)))
+	test.Equal(&got, record)
+}
+
+func TestRootDirReopen(t *testing.T) {
+	defer test.New(t)
+	test.CleanTmp()
+	root := test.Root()
+	vol, blk := testOpenVolume(root)
+
+	for i := 0; i < 5; i++ {
+		fd, err := vol.OpenFile(fmt.Sprint("file", i), true)
+		test.Nil(err)
+		test.WriteString(fd, fmt.Sprint("data", i))
+		fd.Close()
+	}
+	test.Nil(vol.Remove("file1"))
+	test.Equal(vol.Remove("file1"), ErrVolumeFileNotExists)
+	mtime := time.Unix(1500000000, 123)
+	test.Nil(vol.SetMtime("file2", mtime))
+	test.Nil(vol.Rename("file2", "renamed"))
+	test.Equal(vol.Rename("file3", "file4"), ErrVolumeFileAlreadyExists)
+	test.Equal(vol.Rename("file3", "/"), ErrRootDirInvalidName)
+	{
+		_, err := vol.OpenFile("", true)
+		test.Equal(err, ErrRootDirInvalidName)
+	}
+
+	vol, blk = testReopenVolume(root, vol, blk)
+	defer blk.Delete(true)
+	defer vol.Close()
+
+	test.Equal(vol.List(), []string{"file0", "file3", "file4", "renamed"})
+	_, err := vol.OpenFile("file1", false)
+	test.Equal(err, ErrVolumeFileNotExists)
+	{
+		got, err := vol.Mtime("renamed")
+		test.Nil(err)
+		test.True(got.Equal(mtime))
+		got, err = vol.Mtime("file0")
+		test.Nil(err)
+		test.True(got.IsZero())
+	}
+	for name, data := range map[string]string{
+		"file0":   "data0",
+		"renamed": "data2",
+		"file4":   "data4",
+	} {
+		fd, err := vol.OpenFile(name, false)
+		test.Nil(err)
+		test.ReadString(fd, data)
+		fd.Close()
+	}
+
+	// the ino of the removed file is not reused by others
+	fd, err := vol.OpenFile("new", true)
+	test.Nil(err)
+	defer fd.Close()
+	test.Equal(fd.Size(), int64(0))
+}
+
+func TestRootDirCompact(t *testing.T) {
+	defer test.New(t)
+	test.CleanTmp()
+	root := test.Root()
+	vol, blk := testOpenVolume(root)
+
+	fd, err := vol.OpenFile("keep", true)
+	test.Nil(err)
+	test.WriteString(fd, "keep")
+	fd.Close()
+	mtime := time.Unix(1500000000, 0)
+	test.Nil(vol.SetMtime("keep", mtime))
+
+	compacted := false
+	for i := 0; i < RootDirCompactMin*2; i++ {
+		name := fmt.Sprint("tmp", i)
+		fd, err := vol.OpenFile(name, true)
+		test.Nil(err)
+		fd.Close()
+		if i%2 == 0 {
+			test.Nil(vol.Rename(name, name+"-renamed"))
+			name += "-renamed"
+		}
+		test.Nil(vol.Remove(name))
+		if vol.rootDir.records < 3 {
+			compacted = true
+		}
+	}
+	test.True(compacted)
+	test.True(vol.rootDir.records < RootDirCompactMin)
+	test.True(vol.rootDir.fd.Size() < int64(RootDirCompactMin*DirRecordHeaderSize))
+	vol.rootDir.fd.Flush()
+
+	vol, blk = testReopenVolume(root, vol, blk)
+	defer blk.Delete(true)
+	defer vol.Close()
+
+	test.Equal(vol.List(), []string{"keep"})
+	got, err := vol.Mtime("keep")
+	test.Nil(err)
+	test.True(got.Equal(mtime))
+	fd, err = vol.OpenFile("keep", false)
+	test.Nil(err)
+	defer fd.Close()
+	test.ReadString(fd, "keep")
+}
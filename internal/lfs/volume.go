@@ -2,10 +2,11 @@ package lfs
 
 import (
 	"fmt"
+	"time"
 
+	"github.com/allmad/madq/internal/bio"
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
-	"github.com/chzyer/madq/internal/bio"
 )
 
 var (
@@ -16,13 +17,12 @@ var (
 
 type Volume struct {
 	raw    bio.RawDisker
-	dev    *bio.Device
 	devmgr *bio.DeviceMgr
 
 	flow *flow.Flow
 
 	inodeMgr *InodeMgr
-	rootDir  RootDir
+	rootDir  *RootDir
 }
 
 func NewVolume(f *flow.Flow, raw bio.RawDisker) (*Volume, error) {
@@ -45,9 +45,7 @@ func (v *Volume) init() error {
 		return logex.Trace(err)
 	}
 
-	pointer := v.inodeMgr.GetPointer()
-	v.dev = bio.NewDevice(v.raw, *pointer)
-	v.devmgr = bio.NewDeviceMgr(v.flow, v.dev, pointer)
+	v.devmgr = bio.NewDeviceMgr(v.flow, v.raw, v.inodeMgr.GetPointer())
 
 	v.inodeMgr.Start(v.devmgr)
 
@@ -65,7 +63,7 @@ func (v *Volume) init() error {
 	if err != nil {
 		return logex.Trace(err)
 	}
-	v.rootDir = *rootDir
+	v.rootDir = rootDir
 	return nil
 }
 
@@ -77,6 +75,9 @@ func (v *Volume) createFile(name string) (ino int32, err error) {
 	if ino := v.rootDir.Find(name); ino >= 0 {
 		return -1, ErrVolumeFileAlreadyExists.Trace()
 	}
+	if err := checkName(name); err != nil {
+		return -1, err
+	}
 	inode, err := v.inodeMgr.NewInode()
 	if err != nil {
 		return -1, err
@@ -106,11 +107,48 @@ func (v *Volume) OpenFile(name string, autoCreate bool) (*File, error) {
 	return NewFile(v.flow, v, ino, name)
 }
 
+// the names of the files in volume
+func (v *Volume) List() []string {
+	return v.rootDir.List()
+}
+
+// Remove removes the file by name, the file opened before is still
+// readable until closed.
+func (v *Volume) Remove(name string) error {
+	ino, err := v.rootDir.Remove(name)
+	if err != nil {
+		return logex.Trace(err)
+	}
+	return logex.Trace(v.inodeMgr.RemoveInode(ino))
+}
+
+func (v *Volume) Rename(name, newName string) error {
+	return logex.Trace(v.rootDir.Rename(name, newName))
+}
+
+// Mtime returns the mtime set by SetMtime, lfs doesn't change it on
+// writing. it's zero time if never set.
+func (v *Volume) Mtime(name string) (time.Time, error) {
+	mtime, err := v.rootDir.Mtime(name)
+	if err != nil {
+		return time.Time{}, logex.Trace(err)
+	}
+	if mtime == 0 {
+		return time.Time{}, nil
+	}
+	return time.Unix(0, mtime), nil
+}
+
+func (v *Volume) SetMtime(name string, mtime time.Time) error {
+	return logex.Trace(v.rootDir.SetMtime(name, mtime.UnixNano()))
+}
+
 func (v *Volume) Close() {
 	if !v.flow.MarkExit() {
 		return
 	}
-	// clean in writeChan
+	// make a checkpoint before files are closed
+	v.ForceFlush()
 	v.flow.Close()
 }
 
@@ -121,14 +159,10 @@ func (v *Volume) ReadDeviceAt(b []byte, off int64) (int, error) {
 	return v.devmgr.ReadAt(b, off)
 }
 
-func (v *Volume) MallocWriter(n int) *bio.DeviceWriter {
+func (v *Volume) MallocWriter(n int) (*bio.DeviceWriter, error) {
 	return v.devmgr.MallocWriter(n)
 }
 
-func (v *Volume) GetFlushNotify() chan struct{} {
-	return v.devmgr.GetFlushNotify()
-}
-
 func (v *Volume) ForceFlush() {
 	err := v.inodeMgr.Flush()
 	if err != nil {
@@ -148,10 +182,10 @@ func (v *Volume) GetInode(ino int32) (*Inode, error) {
 	return v.inodeMgr.GetInode(ino)
 }
 
-func (v *Volume) NextInode(i *Inode) (*Inode, error) {
-	return v.inodeMgr.GenNextInode(i)
+func (v *Volume) SaveInode(i *Inode) {
+	v.inodeMgr.SaveInode(i)
 }
 
-func (v *Volume) DoneFlush() {
-	v.devmgr.Done()
+func (v *Volume) NextInode(i *Inode) (*Inode, error) {
+	return v.inodeMgr.GenNextInode(i)
 }
@@ -1,10 +1,11 @@
 package lfs
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/allmad/madq/internal/bio"
 	"github.com/chzyer/flow"
-	"github.com/chzyer/madq/internal/bio"
 	"github.com/chzyer/test"
 )
 
@@ -36,3 +37,59 @@ func TestVolume(t *testing.T) {
 		f.Flush()
 	}
 }
+
+func TestVolumeReopen(t *testing.T) {
+	defer test.New(t)
+	test.CleanTmp()
+
+	root := test.Root()
+	blk, err := bio.NewFile(root)
+	test.Nil(err)
+	blk.Delete(false)
+
+	vol, err := NewVolume(flow.New(), blk)
+	test.Nil(err)
+
+	// several inodes per file, and writes after the last flush are
+	// persisted by the checkpoint on close
+	data := make([][]byte, 3)
+	inos := make([]int32, len(data))
+	for i := range data {
+		data[i] = test.RandBytes((i + 1) * 100 << 10)
+		fd, err := vol.OpenFile(fmt.Sprint("file", i), true)
+		test.Nil(err)
+		inos[i] = fd.ino
+		for off := 0; off < len(data[i]); off += 5000 {
+			end := off + 5000
+			if end > len(data[i]) {
+				end = len(data[i])
+			}
+			test.Write(fd, data[i][off:end])
+			if off%(50<<10) < 5000 {
+				fd.Flush()
+			}
+		}
+	}
+	inodeCnt := vol.inodeMgr.InodeCount()
+	vol.Close()
+	test.Nil(blk.Close())
+
+	blk, err = bio.NewFile(root)
+	test.Nil(err)
+	defer blk.Delete(true)
+	f := flow.New()
+	vol, err = NewVolume(f, blk)
+	test.Nil(err)
+	defer vol.Close()
+	test.Equal(vol.inodeMgr.InodeCount(), inodeCnt)
+
+	for i := range data {
+		fd, err := NewFile(f, vol, inos[i], fmt.Sprint("file", i))
+		test.Nil(err)
+		test.Equal(fd.Size(), int64(len(data[i])))
+		buf := make([]byte, len(data[i]))
+		test.ReadAt(fd, buf, 0)
+		test.EqualBytes(buf, data[i])
+		fd.Close()
+	}
+}
@@ -3,17 +3,26 @@ package main
 import (
 	"runtime"
 
+	"github.com/allmad/madq/go/archive"
 	"github.com/allmad/madq/go/bench"
 	"github.com/allmad/madq/go/debug"
+	"github.com/allmad/madq/go/migrate"
+	"github.com/allmad/madq/go/serve"
+	"github.com/allmad/madq/go/top"
 	"github.com/chzyer/flagly"
 	"github.com/chzyer/flow"
 	"github.com/chzyer/logex"
 )
 
 type Madq struct {
-	CPU   int           `default:"1"`
-	Bench *bench.Config `flagly:"handler"`
-	Debug *debug.Config `flagly:"handler"`
+	CPU     int                   `default:"1"`
+	Bench   *bench.Config         `flagly:"handler"`
+	Debug   *debug.Config         `flagly:"handler"`
+	Serve   *serve.Config         `flagly:"handler"`
+	Top     *top.Config           `flagly:"handler"`
+	Export  *archive.ExportConfig `flagly:"handler"`
+	Import  *archive.ImportConfig `flagly:"handler"`
+	Migrate *migrate.Config       `flagly:"handler"`
 }
 
 func (m *Madq) FlaglyEnter() {